@@ -0,0 +1,161 @@
+//go:build !go1.18
+// +build !go1.18
+
+package radix
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// NewAtomic returns a new AtomicTree.
+func NewAtomic(caseInsensitive bool) *AtomicTree {
+	var at AtomicTree
+	at.v.Store(New(caseInsensitive))
+	return &at
+}
+
+// AtomicTree is a tree where readers never lock, writers copy the nodes they modify
+// and atomically swap in the new tree, readers using the old tree are not affected.
+// Writers are serialized, which makes it best suited for read-mostly trees.
+// The zero value is usable.
+type AtomicTree struct {
+	mux sync.Mutex // serializes writers
+	v   atomic.Value
+}
+
+// Load returns the current version of the tree, it *MUST NOT* be modified.
+func (at *AtomicTree) Load() *Tree {
+	if t, _ := at.v.Load().(*Tree); t != nil {
+		return t
+	}
+	return &Tree{}
+}
+
+func (at *AtomicTree) Set(key string, val interface{}) (old interface{}, found bool) {
+	at.mux.Lock()
+	t := at.Load().cow(key)
+	old, found = t.Set(key, val)
+	at.v.Store(t)
+	at.mux.Unlock()
+	return
+}
+
+func (at *AtomicTree) Delete(key string) (old interface{}, found bool) {
+	at.mux.Lock()
+	if t := at.Load(); t.getLeaf(key) != nil {
+		t = t.cow(key)
+		old, found = t.Delete(key)
+		at.v.Store(t)
+	}
+	at.mux.Unlock()
+	return
+}
+
+// Transaction calls fn with a copy of the current version of the tree, if fn returns nil,
+// the copy atomically replaces the tree, otherwise the changes are discarded and the error is returned.
+// Readers keep using the previous version until fn returns, and never see partial changes.
+func (at *AtomicTree) Transaction(fn func(t *Tree) error) error {
+	at.mux.Lock()
+	defer at.mux.Unlock()
+
+	nt := at.Load().Clone()
+	if err := fn(nt); err != nil {
+		return err
+	}
+	at.v.Store(nt)
+	return nil
+}
+
+func (at *AtomicTree) Len() int {
+	return at.Load().Len()
+}
+
+func (at *AtomicTree) Get(key string) (interface{}, bool) {
+	return at.Load().Get(key)
+}
+
+func (at *AtomicTree) LongestPrefix(prefix string) (string, interface{}, bool) {
+	return at.Load().LongestPrefix(prefix)
+}
+
+func (at *AtomicTree) Minimum() (string, interface{}, bool) {
+	return at.Load().Minimum()
+}
+
+func (at *AtomicTree) Maximum() (string, interface{}, bool) {
+	return at.Load().Maximum()
+}
+
+// Walk walks the current version of the tree, changes made inside fn are not visible to the walk.
+func (at *AtomicTree) Walk(fn WalkFn) bool {
+	return at.Load().Walk(fn)
+}
+
+// WalkPrefix walks the current version of the tree, changes made inside fn are not visible to the walk.
+func (at *AtomicTree) WalkPrefix(prefix string, fn WalkFn) bool {
+	return at.Load().WalkPrefix(prefix, fn)
+}
+
+// WalkPath walks the current version of the tree, changes made inside fn are not visible to the walk.
+func (at *AtomicTree) WalkPath(path string, fn WalkFn) bool {
+	return at.Load().WalkPath(path, fn)
+}
+
+// SharedNodeCount returns how many nodes are shared between t and other,
+// it's mainly useful to verify that copy-on-write updates only copy the modified path.
+func (t *Tree) SharedNodeCount(other *Tree) (n int) {
+	nodes := map[*node]struct{}{}
+	walkNodes(&t.root, func(n *node) {
+		nodes[n] = struct{}{}
+	})
+	walkNodes(&other.root, func(on *node) {
+		if _, ok := nodes[on]; ok {
+			n++
+		}
+	})
+	return
+}
+
+// cow returns a copy of the tree sharing all the nodes except the ones on the path of key,
+// so key can be set or deleted without modifying t.
+func (t *Tree) cow(key string) *Tree {
+	nt := *t
+	nt.cowPath(key)
+	return &nt
+}
+
+func (t *Tree) cowPath(key string) {
+	var (
+		n      = &t.root
+		search = key
+		hp     = hasPrefixFn(t.fold)
+	)
+
+	n.Edges = append([]edge(nil), n.Edges...)
+	for {
+		if len(search) == 0 {
+			if n.Leaf != nil {
+				l := *n.Leaf
+				n.Leaf = &l
+			}
+			return
+		}
+
+		label := nextRune(search)
+		child := n.getEdge(label, t.fold)
+		if child == nil {
+			return
+		}
+
+		cp := *child
+		cp.Edges = append([]edge(nil), child.Edges...)
+		n.updateEdge(label, &cp, t.fold)
+		n = &cp
+
+		if !hp(search, n.Prefix) {
+			return
+		}
+		search = search[len(n.Prefix):]
+	}
+}
@@ -6,6 +6,8 @@ package radix
 import (
 	"fmt"
 	"math/rand"
+	"runtime"
+	"sort"
 	"testing"
 )
 
@@ -16,6 +18,10 @@ func BenchmarkTree(b *testing.B) {
 	benchTree(b, New[int](false))
 }
 
+func BenchmarkTreePool(b *testing.B) {
+	benchTree(b, New[int](false, WithNodePool()))
+}
+
 func BenchmarkTreeFold(b *testing.B) {
 	benchTree(b, New[int](true))
 }
@@ -32,7 +38,19 @@ func benchTree(b *testing.B, t *Tree[int]) {
 
 	b.ResetTimer()
 
+	b.Run("Build", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			nt := t.emptyCopy()
+			for x, k := range keys {
+				nt.Set(k, x)
+			}
+			sink = nt.Len()
+		}
+	})
+
 	b.Run("Set", func(b *testing.B) {
+		b.ReportAllocs()
 		first := true
 		for i := 0; i < b.N; i++ {
 			for x, k := range keys {
@@ -78,3 +96,242 @@ func benchTree(b *testing.B, t *Tree[int]) {
 		}
 	})
 }
+
+func BenchmarkWalkPrefix(b *testing.B) {
+	t := New[int](false)
+	for i := 0; i < 10000; i++ {
+		t.Set(fmt.Sprintf("/api/%02d/%03d/%04d", i%10, i%100, i+1), i)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		t.WalkPrefix("/api/0", func(k string, v int) bool {
+			sink += v
+			return false
+		})
+	}
+}
+
+func BenchmarkWalkSum(b *testing.B) {
+	b.Run("Default", func(b *testing.B) {
+		benchWalkSum(b, New[int](false))
+	})
+	b.Run("Slab", func(b *testing.B) {
+		benchWalkSum(b, New[int](false, WithValueSlab(4096)))
+	})
+}
+
+func benchWalkSum(b *testing.B, t *Tree[int]) {
+	b.Helper()
+	r := rand.New(rand.NewSource(42))
+	for i := 0; i < 100000; i++ {
+		t.Set(fmt.Sprintf("/api/%02d/%03d/%04d", r.Intn(100), r.Intn(1000), i), i)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		t.Walk(func(k string, v int) bool {
+			sink += v
+			return false
+		})
+	}
+}
+
+func BenchmarkWalkBuf(b *testing.B) {
+	t := New[int](false)
+	for i := 0; i < 10000; i++ {
+		t.Set(fmt.Sprintf("/api/%02d/%03d/%04d", i%10, i%100, i+1), i)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		t.WalkBuf(func(k []byte, v int) bool {
+			sink += len(k)
+			return false
+		})
+	}
+}
+
+func BenchmarkConcurrentReads(b *testing.B) {
+	keys := make([]string, 10000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("/api/%02d/%03d/%04d", i%10, i%100, i+1)
+	}
+
+	st, at := NewSafe[int](false), NewAtomic[int](false)
+	for i, k := range keys {
+		st.Set(k, i)
+		at.Set(k, i)
+	}
+
+	bench := func(b *testing.B, get func(string) (int, bool), set func(string, int) (int, bool)) {
+		done := make(chan struct{})
+		go func() {
+			for i := 0; ; i++ {
+				select {
+				case <-done:
+					return
+				default:
+					set(keys[i%len(keys)], i)
+				}
+			}
+		}()
+		b.ResetTimer()
+		b.RunParallel(func(pb *testing.PB) {
+			i := rand.Intn(len(keys))
+			for pb.Next() {
+				if _, ok := get(keys[i%len(keys)]); !ok {
+					b.Fatal("missing key")
+				}
+				i++
+			}
+		})
+		b.StopTimer()
+		close(done)
+	}
+
+	b.Run("Safe", func(b *testing.B) { bench(b, st.Get, st.Set) })
+	b.Run("Atomic", func(b *testing.B) { bench(b, at.Get, at.Set) })
+}
+
+func BenchmarkNodeArena(b *testing.B) {
+	keys := make([]string, 100000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("/api/%02d/%03d/%04d", i%10, i%100, i+1)
+	}
+	build := func(opts ...Option) *Tree[int] {
+		t := New[int](false, opts...)
+		for i, k := range keys {
+			t.Set(k, i)
+		}
+		return t
+	}
+
+	for _, bc := range []struct {
+		name string
+		opts []Option
+	}{
+		{"Default", nil},
+		{"Arena", []Option{WithNodeArena(4096), WithValueSlab(4096)}},
+	} {
+		b.Run(bc.name+"/Build", func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				sink = build(bc.opts...).Len()
+			}
+		})
+
+		b.Run(bc.name+"/GC", func(b *testing.B) {
+			t := build(bc.opts...)
+			runtime.GC()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				runtime.GC()
+			}
+			runtime.KeepAlive(t)
+		})
+	}
+}
+
+func BenchmarkNodePool(b *testing.B) {
+	keys := make([]string, 100000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("/api/%02d/%03d/%04d", i%10, i%100, i+1)
+	}
+
+	for _, bc := range []struct {
+		name string
+		opts []Option
+	}{
+		{"Default", nil},
+		{"Pool", []Option{WithNodePool()}},
+	} {
+		b.Run(bc.name+"/Churn", func(b *testing.B) {
+			t := New[int](false, bc.opts...)
+			for i, k := range keys[:len(keys)/2] {
+				t.Set(k, i)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				t.Set(keys[(i+len(keys)/2)%len(keys)], i)
+				t.Delete(keys[i%len(keys)])
+			}
+		})
+	}
+}
+
+func BenchmarkSetSorted(b *testing.B) {
+	keys := make([]string, 200000)
+	values := make([]int, len(keys))
+	for i := range keys {
+		keys[i] = fmt.Sprintf("/api/%02d/%03d/%06d", i%10, i%100, i)
+		values[i] = i
+	}
+	sort.Strings(keys)
+
+	b.Run("Set", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			t := New[int](false)
+			for j, k := range keys {
+				t.Set(k, values[j])
+			}
+			sink = t.Len()
+		}
+	})
+
+	b.Run("SetSorted", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			t := New[int](false)
+			if err := t.SetSorted(keys, values); err != nil {
+				b.Fatal(err)
+			}
+			sink = t.Len()
+		}
+	})
+}
+
+func BenchmarkMergeMapSorted(b *testing.B) {
+	m := make(map[string]int, 100000)
+	for i := 0; i < 100000; i++ {
+		m[fmt.Sprintf("/api/%02d/%03d/%06d", i%10, i%100, i)] = i
+	}
+
+	b.Run("MergeMap", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			sink = New[int](false).MergeMap(m).Len()
+		}
+	})
+
+	b.Run("MergeMapSorted", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			sink = New[int](false).MergeMapSorted(m).Len()
+		}
+	})
+}
+
+func BenchmarkGetBytes(b *testing.B) {
+	t := New[int](false)
+	keys := make([][]byte, 1000)
+	for i := range keys {
+		k := fmt.Sprintf("/api/v1/users/%d/profile", i)
+		t.Set(k, i)
+		keys[i] = []byte(k)
+	}
+
+	b.Run("Get", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			sink, _ = t.Get(string(keys[i%len(keys)]))
+		}
+	})
+
+	b.Run("GetBytes", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			sink, _ = t.GetBytes(keys[i%len(keys)])
+		}
+	})
+}
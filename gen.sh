@@ -6,7 +6,11 @@ typ="$1"
 
 echo "[go.oneofone.dev/radix] generating typed version using '${typ}' as value type."
 
-perl -pe 's@go1\.18@!go1.18@g;s@\[VT.*?]@@g;s@VT@'${typ}'@g;s@^//go:gen.*$@@g' "${base}/radix.go" > radix_go117.go
-perl -pe 's@go1\.18@!go1.18@g;s@\[VT.*?]@@g;s@VT@'${typ}'@g;s@^//go:gen.*$@@g' "${base}/safe.go" > safe_go117.go
-perl -pe 's@go1\.18@!go1.18@g;s@\[VT.*?]@@g;s@(New|Tree)\[.+?\]@\1@g;s@^//go:gen.*$@@g' "${base}/radix_test.go" > radix_go117_test.go
-gopls format -w radix_go117.go
+for f in radix safe succinct scope view atomic iterator; do
+	perl -pe 's@go1\.18@!go1.18@g;s@\[VT.*?]@@g;s@VT@'${typ}'@g;s@^//go:gen.*$@@g' "${base}/${f}.go" > ${f}_go117.go
+	gopls format -w ${f}_go117.go
+done
+
+for f in radix succinct; do
+	perl -pe 's@go1\.18@!go1.18@g;s@\[VT.*?]@@g;s@(New|Tree)\[.+?\]@\1@g;s@\b([A-Z]\w*|node)\[interface\{\}\]@\1@g;s@^//go:gen.*$@@g' "${base}/${f}_test.go" > ${f}_go117_test.go
+done
@@ -0,0 +1,32 @@
+//go:build go1.18
+// +build go1.18
+
+package radix
+
+// Number is the set of types supported by MergeAdd.
+type Number interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr |
+		~float32 | ~float64
+}
+
+// MergeAdd adds the values of ot to t, keys missing from t are treated as zero.
+func MergeAdd[VT Number](t, ot *Tree[VT]) *Tree[VT] {
+	return t.MergeFunc(ot, func(_ string, old, new VT) VT {
+		return old + new
+	})
+}
+
+// MapValues returns a new tree with the same keys and case sensitivity as t, with the values converted by fn.
+func MapValues[VT, NT any](t *Tree[VT], fn func(key string, v VT) NT) *Tree[NT] {
+	keys, values := make([]string, 0, t.size), make([]NT, 0, t.size)
+	walkNode(&t.root, func(k string, v VT) bool {
+		keys, values = append(keys, k), append(values, fn(k, v))
+		return false
+	})
+
+	nt := &Tree[NT]{fold: t.fold, strictFold: t.strictFold}
+	// can't fail, the walk is in order
+	_ = nt.SetSorted(keys, values)
+	return nt
+}
@@ -0,0 +1,137 @@
+//go:build go1.18
+// +build go1.18
+
+package radix
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestMergeAdd(t *testing.T) {
+	a := New[int](false).MergeMap(map[string]int{"a": 1, "b": 2, "c": 3})
+	b := New[int](false).MergeMap(map[string]int{"b": 10, "c": -3, "d": 4})
+
+	exp := map[string]int{"a": 1, "b": 12, "c": 0, "d": 4}
+	if m := MergeAdd(a, b).ToMap(); !reflect.DeepEqual(m, exp) {
+		t.Fatalf("mis-match: %v %v", m, exp)
+	}
+	if b.Len() != 3 {
+		t.Fatalf("other tree was modified: %v", b.ToMap())
+	}
+}
+
+func TestGob(t *testing.T) {
+	for _, fold := range []bool{false, true} {
+		r := New[int](fold)
+		for i := 0; i < 1000; i++ {
+			r.Set(generateUUID(), i)
+		}
+		r.Set("", -1)
+		r.Set("Äpfel", -2)
+
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(r); err != nil {
+			t.Fatal(err)
+		}
+
+		dr := New[int](!fold)
+		dr.Set("existing", 1)
+		if err := gob.NewDecoder(&buf).Decode(dr); err != nil {
+			t.Fatal(err)
+		}
+		if err := dr.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		if dr.Len() != r.Len() || !reflect.DeepEqual(dr.ToMap(), r.ToMap()) {
+			t.Fatalf("fold=%v: mis-match", fold)
+		}
+		if _, ok := dr.Get("äPFEL"); ok != fold {
+			t.Fatalf("fold=%v: the case sensitivity wasn't decoded", fold)
+		}
+	}
+
+	// corrupted payloads leave the tree untouched
+	for _, gt := range []gobTree[int]{
+		{Keys: []string{"b", "a"}, Values: []int{1, 2}},
+		{Keys: []string{"a", "b"}, Values: []int{1}},
+	} {
+		var buf bytes.Buffer
+		if err := gob.NewEncoder(&buf).Encode(gt); err != nil {
+			t.Fatal(err)
+		}
+
+		dr := New[int](true)
+		dr.Set("existing", 1)
+		if err := dr.GobDecode(buf.Bytes()); err == nil {
+			t.Fatalf("%v: expected an error", gt)
+		}
+		if !dr.fold || !reflect.DeepEqual(dr.ToMap(), map[string]int{"existing": 1}) {
+			t.Fatalf("%v: the tree was modified: %v", gt, dr.ToMap())
+		}
+	}
+	if err := New[int](false).GobDecode([]byte("garbage")); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	type point struct {
+		X, Y int
+		Tag  string
+	}
+
+	r := New[point](false)
+	r.Set("a", point{1, 2, "a"})
+	r.Set("a/b", point{3, 4, ""})
+
+	b, err := r.GobEncode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dr Tree[point]
+	if err := dr.GobDecode(b); err != nil {
+		t.Fatal(err)
+	}
+	if exp := map[string]point{"a": {1, 2, "a"}, "a/b": {3, 4, ""}}; !reflect.DeepEqual(dr.ToMap(), exp) {
+		t.Fatalf("mis-match: %v %v", dr.ToMap(), exp)
+	}
+	if err := dr.GobDecode(b[:len(b)-1]); err == nil {
+		t.Fatal("expected an error for truncated data")
+	}
+}
+
+func TestMapValues(t *testing.T) {
+	type user struct {
+		Name string
+		Age  int
+	}
+
+	for _, fold := range []bool{false, true} {
+		r := New[string](fold)
+		r.Set("/users/Bob", `{"Name":"bob","Age":30}`)
+		r.Set("/users/alice", `{"Name":"alice","Age":25}`)
+		r.Set("", `{}`)
+
+		mr := MapValues(r, func(k string, v string) user {
+			var u user
+			if err := json.Unmarshal([]byte(v), &u); err != nil {
+				t.Fatalf("%s: %v", k, err)
+			}
+			return u
+		})
+		if err := mr.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(mr.Keys(), r.Keys()) {
+			t.Fatalf("fold=%v: expected %q, got %q", fold, r.Keys(), mr.Keys())
+		}
+		if u, _ := mr.Get("/users/alice"); u != (user{"alice", 25}) {
+			t.Fatalf("unexpected value: %+v", u)
+		}
+		if u, ok := mr.Get("/USERS/bob"); ok != fold || (fold && u != (user{"bob", 30})) {
+			t.Fatalf("fold=%v: unexpected value: %+v %v", fold, u, ok)
+		}
+	}
+}
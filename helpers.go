@@ -1,12 +1,25 @@
 package radix
 
 import (
+	"io"
 	"math"
 	"strings"
 	"unicode"
 	"unicode/utf8"
 )
 
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (n int, err error) {
+	n, err = cw.w.Write(p)
+	cw.n += int64(n)
+	return
+}
+
 func hasPrefixFn(fold bool) func(s, pre string) bool {
 	if !fold {
 		return strings.HasPrefix
@@ -15,6 +28,38 @@ func hasPrefixFn(fold bool) func(s, pre string) bool {
 	return HasPrefixFold
 }
 
+func compareFn(fold bool) func(a, b string) int {
+	if !fold {
+		return strings.Compare
+	}
+
+	return compareFold
+}
+
+// compareFold compares two strings rune by rune, ignoring case,
+// which matches the order of walking a case-insensitive tree.
+func compareFold(a, b string) int {
+	for a != "" && b != "" {
+		ra, na := utf8.DecodeRuneInString(a)
+		rb, nb := utf8.DecodeRuneInString(b)
+		if ra, rb = foldRune(ra), foldRune(rb); ra != rb {
+			if ra < rb {
+				return -1
+			}
+			return 1
+		}
+		a, b = a[na:], b[nb:]
+	}
+
+	switch {
+	case a != "":
+		return 1
+	case b != "":
+		return -1
+	}
+	return 0
+}
+
 func longestPrefixFn(fold bool) func(a, b string) int {
 	if !fold {
 		return LongestPrefix
@@ -108,11 +153,86 @@ func HasPrefixFold(s, pre string) (_ bool) {
 	return true
 }
 
+// hasPrefixBytes is hasPrefixFn for byte slices.
+func hasPrefixBytes(s []byte, pre string, fold bool) bool {
+	if len(s) < len(pre) {
+		return false
+	}
+	if !fold {
+		return string(s[:len(pre)]) == pre
+	}
+
+	for i, pr := range pre {
+		if pr < utf8.RuneSelf {
+			if !asciiEq(byte(pr), s[i]) {
+				return false
+			}
+			continue
+		}
+
+		if sr, _ := utf8.DecodeRune(s[i:]); !runeEq(pr, sr) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasBytesPrefix is hasPrefixFn for a prefix stored as a byte slice.
+func hasBytesPrefix(s string, pre []byte, fold bool) bool {
+	if len(s) < len(pre) {
+		return false
+	}
+	if !fold {
+		return s[:len(pre)] == string(pre)
+	}
+
+	for i := 0; i < len(pre); {
+		if pre[i] < utf8.RuneSelf {
+			if !asciiEq(pre[i], s[i]) {
+				return false
+			}
+			i++
+			continue
+		}
+
+		pr, n := utf8.DecodeRune(pre[i:])
+		if sr, _ := utf8.DecodeRuneInString(s[i:]); !runeEq(pr, sr) {
+			return false
+		}
+		i += n
+	}
+	return true
+}
+
 func runeEq(sr, tr rune) bool {
 	if sr == tr {
 		return true
 	}
-	return unicode.To(unicode.LowerCase, sr) == unicode.To(unicode.LowerCase, tr)
+	return foldRune(sr) == foldRune(tr)
+}
+
+// foldRune returns the rune case-insensitive trees use for r, runes are equal ignoring case if they have
+// the same foldRune.
+// It's based on Unicode simple case folding (unicode.SimpleFold), which doesn't include the Turkic mappings,
+// so 'İ' and 'ı' don't match 'i' and 'I', while 'Σ', 'σ' and 'ς' all match.
+// Runes of different UTF-8 lengths never match, so the Kelvin sign doesn't match 'k' and 'ſ' doesn't match 's',
+// since keys that are equal ignoring case must have the same length.
+func foldRune(r rune) rune {
+	if r < utf8.RuneSelf {
+		return rune(asciiTable[r])
+	}
+
+	// the lowercase of the uppercase handles runes with more than one lowercase form, like 'ς'
+	f := unicode.ToLower(unicode.ToUpper(r))
+	if f == r || utf8.RuneLen(f) != utf8.RuneLen(r) {
+		return r
+	}
+	for c := unicode.SimpleFold(r); c != r; c = unicode.SimpleFold(c) {
+		if c == f {
+			return f
+		}
+	}
+	return r
 }
 
 var asciiTable = func() (t [math.MaxUint8 + 1]byte) {
@@ -140,3 +260,63 @@ func nextRune(s string) rune {
 	r, _ := utf8.DecodeRuneInString(s)
 	return r
 }
+
+// globPattern is a Matcher for the patterns supported by WalkGlob.
+type globPattern struct {
+	pattern string
+	fold    bool
+}
+
+func (g globPattern) MatchPrefix(prefix string) bool {
+	return globMatch(g.pattern, prefix, g.fold, true)
+}
+
+func (g globPattern) Match(key string) bool {
+	return globMatch(g.pattern, key, g.fold, false)
+}
+
+// globMatch reports if s matches pattern, where * matches any run of runes and ? matches a single rune.
+// If partial is set, it reports if s could be extended to match pattern instead.
+func globMatch(pattern, s string, fold, partial bool) bool {
+	var px, sx, nextPx, nextSx int
+	for px < len(pattern) || sx < len(s) {
+		if px < len(pattern) {
+			pr, pn := utf8.DecodeRuneInString(pattern[px:])
+			switch pr {
+			case '*':
+				// try to match the rest at sx first, then restart at the next rune
+				nextPx, nextSx = px, sx+1
+				if sx < len(s) {
+					_, sn := utf8.DecodeRuneInString(s[sx:])
+					nextSx = sx + sn
+				}
+				px += pn
+				continue
+			case '?':
+				if sx < len(s) {
+					_, sn := utf8.DecodeRuneInString(s[sx:])
+					px, sx = px+pn, sx+sn
+					continue
+				}
+			default:
+				if sx < len(s) {
+					sr, sn := utf8.DecodeRuneInString(s[sx:])
+					if sr == pr || (fold && runeEq(sr, pr)) {
+						px, sx = px+pn, sx+sn
+						continue
+					}
+				}
+			}
+		}
+
+		if partial && sx == len(s) {
+			return true
+		}
+		if 0 < nextSx && nextSx <= len(s) {
+			px, sx = nextPx, nextSx
+			continue
+		}
+		return false
+	}
+	return true
+}
@@ -0,0 +1,46 @@
+//go:build go1.23
+// +build go1.23
+
+package radix
+
+import "iter"
+
+// All returns an iterator over the tree's entries, in the same order as Walk.
+// It is *NOT* safe to modify the tree while iterating.
+func (t *Tree[VT]) All() iter.Seq2[string, VT] {
+	return func(yield func(string, VT) bool) {
+		t.Walk(func(k string, v VT) bool {
+			return !yield(k, v)
+		})
+	}
+}
+
+// AllPrefix returns an iterator over the entries under prefix, in the same order as WalkPrefix.
+// It is *NOT* safe to modify the tree while iterating.
+func (t *Tree[VT]) AllPrefix(prefix string) iter.Seq2[string, VT] {
+	return func(yield func(string, VT) bool) {
+		t.WalkPrefix(prefix, func(k string, v VT) bool {
+			return !yield(k, v)
+		})
+	}
+}
+
+// All returns an iterator over the view's entries, in the same order as Walk.
+// Views of a SafeTree hold the read lock while iterating, so the tree can't be modified inside the loop.
+func (v ReadOnlyView[VT]) All() iter.Seq2[string, VT] {
+	return func(yield func(string, VT) bool) {
+		v.r.Walk(func(k string, val VT) bool {
+			return !yield(k, val)
+		})
+	}
+}
+
+// AllPrefix returns an iterator over the view's entries under prefix, in the same order as WalkPrefix.
+// Views of a SafeTree hold the read lock while iterating, so the tree can't be modified inside the loop.
+func (v ReadOnlyView[VT]) AllPrefix(prefix string) iter.Seq2[string, VT] {
+	return func(yield func(string, VT) bool) {
+		v.r.WalkPrefix(prefix, func(k string, val VT) bool {
+			return !yield(k, val)
+		})
+	}
+}
@@ -0,0 +1,72 @@
+//go:build go1.23
+// +build go1.23
+
+package radix
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAll(t *testing.T) {
+	r := New[int](false)
+	keys := []string{"", "a", "ab", "abc", "abd", "b", "bc"}
+	for i, k := range keys {
+		r.Set(k, i)
+	}
+
+	var got []string
+	for k, v := range r.All() {
+		if keys[v] != k {
+			t.Fatalf("bad value for %q: %d", k, v)
+		}
+		got = append(got, k)
+	}
+	if !reflect.DeepEqual(got, keys) {
+		t.Fatalf("expected %q, got %q", keys, got)
+	}
+
+	got = got[:0]
+	for k := range r.AllPrefix("ab") {
+		got = append(got, k)
+		if k == "abc" {
+			break
+		}
+	}
+	if exp := []string{"ab", "abc"}; !reflect.DeepEqual(got, exp) {
+		t.Fatalf("expected %q, got %q", exp, got)
+	}
+
+	for range r.AllPrefix("x") {
+		t.Fatal("expected no entries")
+	}
+}
+
+func TestReadOnlyViewAll(t *testing.T) {
+	r := New[int](false)
+	keys := []string{"a", "ab", "abc", "b"}
+	for i, k := range keys {
+		r.Set(k, i)
+	}
+
+	for _, v := range []ReadOnlyView[int]{r.ReadOnly(), r.Safe().ReadOnly()} {
+		var got []string
+		for k, val := range v.All() {
+			if keys[val] != k {
+				t.Fatalf("bad value for %q: %d", k, val)
+			}
+			got = append(got, k)
+		}
+		if !reflect.DeepEqual(got, keys) {
+			t.Fatalf("expected %q, got %q", keys, got)
+		}
+
+		got = got[:0]
+		for k := range v.AllPrefix("ab") {
+			got = append(got, k)
+		}
+		if exp := []string{"ab", "abc"}; !reflect.DeepEqual(got, exp) {
+			t.Fatalf("expected %q, got %q", exp, got)
+		}
+	}
+}
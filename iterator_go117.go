@@ -0,0 +1,157 @@
+//go:build !go1.18
+// +build !go1.18
+
+package radix
+
+// Iterator returns a stateful iterator over the tree, positioned before the first key.
+// It is *NOT* safe to modify the tree while using the iterator.
+func (t *Tree) Iterator() *Iterator {
+	it := &Iterator{t: t}
+	it.it.stack = append(it.it.stack, &t.root)
+	return it
+}
+
+// Iterator is a stateful iterator over the keys of a tree, in order.
+type Iterator struct {
+	t      *Tree
+	it     leafIter
+	l      *leafNode
+	prefix string
+}
+
+// Next advances the iterator to the next key, returns false when there are no more keys.
+func (it *Iterator) Next() bool {
+	if it.l = it.it.next(); it.l == nil {
+		return false
+	}
+	if it.prefix != "" && !hasPrefixFn(it.t.fold)(it.l.Key, it.prefix) {
+		// keys are in order, so there are no more keys under the prefix
+		it.l, it.it.stack = nil, it.it.stack[:0]
+		return false
+	}
+	return true
+}
+
+// Key returns the current key.
+func (it *Iterator) Key() string {
+	if it.l == nil {
+		return ""
+	}
+	return it.l.Key
+}
+
+// Value returns the current value.
+func (it *Iterator) Value() (v interface{}) {
+	if it.l == nil {
+		return
+	}
+	return it.l.Value
+}
+
+// SeekPrefix positions the iterator before the first key under prefix, and bounds it to that prefix.
+func (it *Iterator) SeekPrefix(prefix string) {
+	it.SeekPrefixGE(prefix, "")
+}
+
+// SeekPrefixGE positions the iterator before the first key under prefix that is greater than or equal to key,
+// and bounds it to that prefix, which allows resuming a prefix scan.
+func (it *Iterator) SeekPrefixGE(prefix, key string) {
+	if compareFn(it.t.fold)(key, prefix) < 0 {
+		key = prefix
+	}
+	it.prefix, it.l = prefix, nil
+	it.it.stack = it.t.seekGE(it.it.stack[:0], key)
+}
+
+// Cursor returns a cursor positioned before the first key.
+// Modifying the tree invalidates all its live cursors.
+func (t *Tree) Cursor() *Cursor {
+	c := &Cursor{}
+	c.it.stack = append(c.it.stack, &t.root)
+	return c
+}
+
+// CursorFrom returns a cursor positioned before the first key that is greater than or equal to key.
+// Modifying the tree invalidates all its live cursors.
+func (t *Tree) CursorFrom(key string) *Cursor {
+	c := &Cursor{}
+	c.it.stack = t.seekGE(nil, key)
+	return c
+}
+
+// Cursor iterates over the keys of a tree in order, it keeps the path to the next key,
+// so iteration can be stopped and resumed without walking the tree again.
+type Cursor struct {
+	it leafIter
+}
+
+// Next returns the next key and its value, ok is false when there are no more keys.
+func (c *Cursor) Next() (key string, v interface{}, ok bool) {
+	l := c.it.next()
+	if l == nil {
+		return
+	}
+	return l.Key, l.Value, true
+}
+
+// WalkRange walks the keys k where from <= k < to in order, skipping the subtrees outside the range.
+// An empty from starts at the first key and an empty to walks until the last key.
+func (t *Tree) WalkRange(from, to string, fn WalkFn) bool {
+	cmp := compareFn(t.fold)
+	it := leafIter{stack: t.seekGE(nil, from)}
+	for l := it.next(); l != nil; l = it.next() {
+		if to != "" && cmp(l.Key, to) >= 0 {
+			break
+		}
+		if fn(l.Key, l.Value) {
+			return true
+		}
+	}
+	return false
+}
+
+// seekGE appends to stack the nodes that hold all the keys greater than or equal to key,
+// in the order expected by leafIter.
+func (t *Tree) seekGE(stack []*node, key string) []*node {
+	var (
+		n      = &t.root
+		search = key
+		hp     = hasPrefixFn(t.fold)
+		cmp    = compareFn(t.fold)
+	)
+
+	for {
+		if len(search) == 0 {
+			// n's own key is key
+			return append(stack, n)
+		}
+
+		r := nextRune(search)
+		idx := n.edgeIndex(r, t.fold)
+		found := idx < len(n.Edges) && n.Edges[idx].Label == foldLabel(r, t.fold)
+
+		// All the larger edges hold larger keys, push them in reverse so the smallest is popped first
+		first := idx
+		if found {
+			first++
+		}
+		for i := len(n.Edges) - 1; i >= first; i-- {
+			stack = append(stack, n.Edges[i].Node)
+		}
+		if !found {
+			return stack
+		}
+
+		c := n.Edges[idx].Node
+		if hp(search, c.Prefix) {
+			search, n = search[len(c.Prefix):], c
+			continue
+		}
+
+		// All the keys under c are either smaller or larger than key
+		if cmp(search, c.Prefix) < 0 {
+			stack = append(stack, c)
+		}
+		return stack
+	}
+}
@@ -0,0 +1,30 @@
+//go:build go1.21
+// +build go1.21
+
+package radix
+
+import "cmp"
+
+// MaxValue returns the key and value of the entry with the largest value in the tree, it walks the whole tree.
+// If multiple entries have the largest value, the first one in key order is returned.
+func MaxValue[VT cmp.Ordered](t *Tree[VT]) (key string, v VT, found bool) {
+	t.Walk(func(k string, kv VT) bool {
+		if !found || kv > v {
+			key, v, found = k, kv, true
+		}
+		return false
+	})
+	return
+}
+
+// MinValue returns the key and value of the entry with the smallest value in the tree, it walks the whole tree.
+// If multiple entries have the smallest value, the first one in key order is returned.
+func MinValue[VT cmp.Ordered](t *Tree[VT]) (key string, v VT, found bool) {
+	t.Walk(func(k string, kv VT) bool {
+		if !found || kv < v {
+			key, v, found = k, kv, true
+		}
+		return false
+	})
+	return
+}
@@ -0,0 +1,30 @@
+//go:build go1.21
+// +build go1.21
+
+package radix
+
+import "testing"
+
+func TestMinMaxValue(t *testing.T) {
+	r := New[int](false)
+	if _, _, ok := MaxValue(r); ok {
+		t.Fatal("expected no value")
+	}
+	if _, _, ok := MinValue(r); ok {
+		t.Fatal("expected no value")
+	}
+
+	r.MergeMap(map[string]int{"b": 5, "a": 1, "d": 5, "c": 3, "e": 1, "f": 2})
+
+	if k, v, ok := MaxValue(r); !ok || k != "b" || v != 5 {
+		t.Fatalf("MaxValue: expected (b, 5), got (%s, %d, %v)", k, v, ok)
+	}
+	if k, v, ok := MinValue(r); !ok || k != "a" || v != 1 {
+		t.Fatalf("MinValue: expected (a, 1), got (%s, %d, %v)", k, v, ok)
+	}
+
+	s := New[string](false).MergeMap(map[string]string{"x": "zz", "y": "aa"})
+	if k, v, _ := MaxValue(s); k != "x" || v != "zz" {
+		t.Fatalf("MaxValue: expected (x, zz), got (%s, %s)", k, v)
+	}
+}
@@ -4,12 +4,22 @@
 package radix
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
 	"encoding/json"
 	"fmt"
 	"io"
+	"math/rand"
+	"reflect"
+	"runtime"
 	"sort"
 	"strings"
-	"unicode"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+	"unsafe"
 )
 
 // WalkFn is used when walking the tree. Takes a
@@ -17,6 +27,33 @@ import (
 // be terminated.
 type WalkFn func(s string, v interface{}) bool
 
+// CollectKeys returns a WalkFn that appends all the visited keys to dst.
+func CollectKeys(dst *[]string) WalkFn {
+	return func(k string, _ interface{}) bool {
+		*dst = append(*dst, k)
+		return false
+	}
+}
+
+// CollectInto returns a WalkFn that adds all the visited entries to m.
+func CollectInto(m map[string]interface{}) WalkFn {
+	return func(k string, v interface{}) bool {
+		m[k] = v
+		return false
+	}
+}
+
+// Limit returns a WalkFn that calls inner and aborts the walk after n visits.
+func Limit(n int, inner WalkFn) WalkFn {
+	return func(k string, v interface{}) bool {
+		if n <= 0 {
+			return true
+		}
+		n--
+		return inner(k, v) || n == 0
+	}
+}
+
 // edge is used to represent an edge node
 type edge struct {
 	Node  *node `json:"node,omitempty"`
@@ -75,22 +112,43 @@ func (n *node) isLeafInTheWind() bool {
 
 func (n *node) addEdge(e edge, fold bool) {
 	if fold {
-		e.Label = unicode.ToLower(e.Label)
+		e.Label = foldRune(e.Label)
 	}
 
+	// sorted input always appends, so check the last edge before searching
 	num := len(n.Edges)
-	idx := sort.Search(num, func(i int) bool {
-		return n.Edges[i].Label >= e.Label
-	})
+	idx := num
+	if num > 0 && n.Edges[num-1].Label >= e.Label {
+		idx = sort.Search(num, func(i int) bool {
+			return n.Edges[i].Label >= e.Label
+		})
+	}
+
+	if num == cap(n.Edges) {
+		// grow and insert in one pass, nodes with edges almost always end up with at least 2
+		ncap := 2 * num
+		if ncap < minEdges {
+			ncap = minEdges
+		}
+		edges := make([]edge, num+1, ncap)
+		copy(edges, n.Edges[:idx])
+		edges[idx] = e
+		copy(edges[idx+1:], n.Edges[idx:])
+		n.Edges = edges
+		return
+	}
 
-	n.Edges = append(n.Edges, edge{})
+	n.Edges = n.Edges[:num+1]
 	copy(n.Edges[idx+1:], n.Edges[idx:])
 	n.Edges[idx] = e
 }
 
+// minEdges is the initial capacity of a node's edges.
+const minEdges = 2
+
 func (n *node) updateEdge(label rune, node *node, fold bool) {
 	if fold {
-		label = unicode.ToLower(label)
+		label = foldRune(label)
 	}
 
 	i, j := 0, len(n.Edges)
@@ -110,9 +168,24 @@ func (n *node) updateEdge(label rune, node *node, fold bool) {
 	panic("replacing missing edge")
 }
 
+// edgeIndex returns the index of the first edge with a label that is greater than or equal to label.
+func (n *node) edgeIndex(label rune, fold bool) int {
+	label = foldLabel(label, fold)
+	return sort.Search(len(n.Edges), func(i int) bool {
+		return n.Edges[i].Label >= label
+	})
+}
+
+func foldLabel(label rune, fold bool) rune {
+	if fold {
+		return foldRune(label)
+	}
+	return label
+}
+
 func (n *node) getEdge(label rune, fold bool) *node {
 	if fold {
-		label = unicode.ToLower(label)
+		label = foldRune(label)
 	}
 
 	i, j := 0, len(n.Edges)
@@ -133,7 +206,7 @@ func (n *node) getEdge(label rune, fold bool) *node {
 
 func (n *node) delEdge(label rune, fold bool) {
 	if fold {
-		label = unicode.ToLower(label)
+		label = foldRune(label)
 	}
 	num := len(n.Edges)
 	idx := sort.Search(num, func(i int) bool {
@@ -146,12 +219,235 @@ func (n *node) delEdge(label rune, fold bool) {
 	}
 }
 
+// Option is used to enable optional tree features in New and NewSafe.
+type Option func(o *options)
+
+type options struct {
+	valueIndex bool
+	slabSize   int
+	nodeArena  int
+	nodePool   bool
+	metrics    bool
+	strictFold bool
+	timestamps bool
+	clock      func() time.Time
+}
+
+// WithValueIndex enables a reverse value index used by KeyForValue.
+// It roughly doubles the memory usage and values must be hashable,
+// or Set will panic.
+// The index holds a single key per value, the last one set, if values aren't unique
+// and that key is removed, KeyForValue falls back to walking the tree.
+func WithValueIndex() Option {
+	return func(o *options) { o.valueIndex = true }
+}
+
+// WithValueSlab allocates leaves (and their values) in contiguous chunks of size entries
+// rather than individually, which improves cache locality for walk-heavy workloads.
+// Leaves freed by Delete are reused, however DeletePrefix doesn't recycle them.
+func WithValueSlab(size int) Option {
+	return func(o *options) { o.slabSize = size }
+}
+
+// WithNodeArena allocates nodes in contiguous chunks of size nodes rather than individually,
+// which reduces the number of allocations and GC pressure for large trees that are built once.
+// Nodes are never reused, a chunk is freed once all its nodes are removed from the tree, or the tree is discarded.
+func WithNodeArena(size int) Option {
+	return func(o *options) { o.nodeArena = size }
+}
+
+// WithNodePool recycles the nodes and leaves removed by Delete, DeletePrefix and node merges through a sync.Pool,
+// which reduces GC pressure for trees where keys are constantly added and removed.
+// Recycled nodes and leaves are zeroed before reuse, nodes allocated using WithNodeArena are never recycled.
+func WithNodePool() Option {
+	return func(o *options) { o.nodePool = true }
+}
+
+// WithStrictFold makes case-insensitive trees keep the first casing of a key,
+// setting a key that only differs in case from an existing key doesn't modify the tree, see SetFold.
+func WithStrictFold() Option {
+	return func(o *options) { o.strictFold = true }
+}
+
+// WithTimestamps records the time each key was added, see AgeOf and OlderThan.
+// clock is used to get the current time, if it's nil, time.Now is used.
+// Updating the value of an existing key doesn't change its timestamp.
+func WithTimestamps(clock func() time.Time) Option {
+	return func(o *options) { o.timestamps, o.clock = true, clock }
+}
+
+// WithMetrics enables counting operations, see Tree.Metrics.
+func WithMetrics() Option {
+	return func(o *options) { o.metrics = true }
+}
+
+// TreeMetrics holds the operation counters of a tree created using WithMetrics.
+type TreeMetrics struct {
+	Sets    uint64
+	Gets    uint64
+	Deletes uint64
+
+	// Hits and Misses count the results of Get.
+	Hits   uint64
+	Misses uint64
+}
+
 // New returns an empty Tree.
-// The same as just using `var t Tree`.
-func New(caseInsensitive bool) *Tree {
-	return &Tree{
+// The same as just using `var t Tree` if no options are passed.
+// Case-insensitive trees use Unicode simple case folding, see FoldKey.
+func New(caseInsensitive bool, opts ...Option) *Tree {
+	t := &Tree{
 		fold: caseInsensitive,
 	}
+	t.init(opts)
+	return t
+}
+
+func (t *Tree) init(opts []Option) {
+	var o options
+	for _, fn := range opts {
+		fn(&o)
+	}
+	if o.valueIndex {
+		t.vidx = map[interface{}]string{}
+	}
+	t.slabSize = o.slabSize
+	t.nodeArena = o.nodeArena
+	if o.nodePool {
+		t.pool = newNodePool()
+	}
+	t.strictFold = o.strictFold
+	if o.metrics {
+		t.metrics = &TreeMetrics{}
+	}
+	if o.timestamps {
+		t.times, t.clock = map[string]time.Time{}, o.clock
+		if t.clock == nil {
+			t.clock = time.Now
+		}
+	}
+}
+
+// Metrics returns a snapshot of the tree's operation counters,
+// it returns zero values if the tree wasn't created using WithMetrics.
+func (t *Tree) Metrics() (m TreeMetrics) {
+	if tm := t.metrics; tm != nil {
+		m.Sets = atomic.LoadUint64(&tm.Sets)
+		m.Gets = atomic.LoadUint64(&tm.Gets)
+		m.Deletes = atomic.LoadUint64(&tm.Deletes)
+		m.Hits = atomic.LoadUint64(&tm.Hits)
+		m.Misses = atomic.LoadUint64(&tm.Misses)
+	}
+	return
+}
+
+func (t *Tree) newLeaf(key string, value interface{}) *leafNode {
+	if t.slabSize <= 0 {
+		if t.pool != nil {
+			l := t.pool.leaves.Get().(*leafNode)
+			l.Key, l.Value = key, value
+			return l
+		}
+		return &leafNode{Key: key, Value: value}
+	}
+
+	var l *leafNode
+	if n := len(t.freeLeaves); n > 0 {
+		l = t.freeLeaves[n-1]
+		t.freeLeaves[n-1] = nil
+		t.freeLeaves = t.freeLeaves[:n-1]
+	} else {
+		if len(t.slab) == cap(t.slab) {
+			t.slab = make([]leafNode, 0, t.slabSize)
+		}
+		t.slab = append(t.slab, leafNode{})
+		l = &t.slab[len(t.slab)-1]
+	}
+
+	l.Key, l.Value = key, value
+	return l
+}
+
+func (t *Tree) newNode(prefix string, leaf *leafNode) *node {
+	if t.nodeArena <= 0 {
+		if t.pool != nil {
+			n := t.pool.nodes.Get().(*node)
+			n.Leaf, n.Prefix = leaf, prefix
+			return n
+		}
+		return &node{Leaf: leaf, Prefix: prefix}
+	}
+
+	if len(t.nodes) == cap(t.nodes) {
+		t.nodes = make([]node, 0, t.nodeArena)
+	}
+	t.nodes = append(t.nodes, node{Leaf: leaf, Prefix: prefix})
+	return &t.nodes[len(t.nodes)-1]
+}
+
+func (t *Tree) freeLeaf(l *leafNode) {
+	if t.slabSize <= 0 {
+		if t.pool != nil {
+			*l = leafNode{}
+			t.pool.leaves.Put(l)
+		}
+		return
+	}
+	*l = leafNode{}
+	t.freeLeaves = append(t.freeLeaves, l)
+}
+
+// freeNode recycles n if the tree uses a node pool, n must not be reachable from any tree.
+func (t *Tree) freeNode(n *node) {
+	if t.pool == nil || t.nodeArena > 0 {
+		return
+	}
+	*n = node{}
+	t.pool.nodes.Put(n)
+}
+
+// freeSubtree recycles n, all its descendants and their leaves.
+func (t *Tree) freeSubtree(n *node) {
+	sp := getWalkStack()
+	stack := append((*sp)[:0], n)
+	used := len(stack)
+	for len(stack) > 0 {
+		n = stack[len(stack)-1].(*node)
+		stack = stack[:len(stack)-1]
+
+		for _, e := range n.Edges {
+			stack = append(stack, e.Node)
+		}
+		if len(stack) > used {
+			used = len(stack)
+		}
+
+		if n.Leaf != nil {
+			t.freeLeaf(n.Leaf)
+		}
+		t.freeNode(n)
+	}
+	putWalkStack(sp, stack, used)
+}
+
+// merge merges n with its only child and recycles the child.
+func (t *Tree) merge(n *node) {
+	child := n.Edges[0].Node
+	n.mergeChild()
+	t.freeNode(child)
+}
+
+// nodePool holds the recycled nodes and leaves, see WithNodePool.
+type nodePool struct {
+	nodes  sync.Pool
+	leaves sync.Pool
+}
+
+func newNodePool() *nodePool {
+	var p nodePool
+	p.nodes.New = func() interface{} { return &node{} }
+	p.leaves.New = func() interface{} { return &leafNode{} }
+	return &p
 }
 
 // Tree implements a radix tree. This can be treated as a
@@ -168,6 +464,37 @@ type Tree struct {
 	// form of case-insensitivity.
 	fold bool
 
+	// strictFold is set if case-variants of existing keys are rejected, see WithStrictFold.
+	strictFold bool
+
+	// metrics is set if the tree was created using WithMetrics.
+	metrics *TreeMetrics
+
+	// vidx is the optional reverse value index, see WithValueIndex,
+	// vidxDups is set once a value was indexed for more than one key.
+	vidx     map[interface{}]string
+	vidxDups bool
+
+	// the current leaf slab and freed leaves, see WithValueSlab.
+	slab       []leafNode
+	freeLeaves []*leafNode
+	slabSize   int
+
+	// the time each key was added and the clock, see WithTimestamps.
+	times map[string]time.Time
+	clock func() time.Time
+
+	// the current node chunk, see WithNodeArena.
+	nodes     []node
+	nodeArena int
+
+	// the recycled nodes and leaves, see WithNodePool.
+	pool *nodePool
+
+	// the shortest and longest key lengths and how many keys have them.
+	minLen, minN int
+	maxLen, maxN int
+
 	zero interface{}
 }
 
@@ -177,12 +504,233 @@ func (t *Tree) Len() int {
 }
 
 // Set is used to set a value and return the previous one if any.
+// In case-insensitive trees, the last casing of a key that was set is the one stored and returned by walks,
+// unless the tree was created using WithStrictFold.
 func (t *Tree) Set(key string, value interface{}) (interface{}, bool) {
+	old, found, _ := t.SetInfo(key, value)
+	return old, found
+}
+
+// SetInfo is like Set, but also reports if key was added to the tree and is a prefix of existing keys,
+// for example setting "a" after "a/b".
+func (t *Tree) SetInfo(key string, value interface{}) (old interface{}, found, prefixKey bool) {
+	_, old, found, prefixKey = t.setInfo(&t.root, key, key, value)
+	return
+}
+
+// setInfo does the bookkeeping for SetInfo, inserting key starting at n, where search is the rest of the key under n.
+func (t *Tree) setInfo(n *node, key, search string, value interface{}) (_ *node, old interface{}, found, prefixKey bool) {
+	if t.metrics != nil {
+		atomic.AddUint64(&t.metrics.Sets, 1)
+	}
+	if t.strictFold && t.fold {
+		if l := t.getLeaf(key); l != nil && l.Key != key {
+			return nil, l.Value, true, false
+		}
+	}
+	return t.store(n, key, search, value)
+}
+
+// store sets key starting at n, where search is the rest of the key under n, and updates the size,
+// key lengths, timestamps and the value index.
+func (t *Tree) store(n *node, key, search string, value interface{}) (_ *node, old interface{}, found, prefixKey bool) {
+	n, old, found = t.setAt(n, key, search, value, true)
+	prevKey := n.Leaf.Key
+	if !found {
+		t.addKeyLen(len(key))
+		t.stamp(n.Leaf.Key)
+		prefixKey = len(n.Edges) > 0
+	} else if prevKey != key {
+		// the last casing wins
+		n.Leaf.Key = key
+		t.restamp(prevKey, key)
+	}
+	if t.vidx != nil {
+		if found {
+			t.unindex(prevKey, old)
+		}
+		t.index(key, value)
+	}
+	return n, old, found, prefixKey
+}
+
+// SetSorted sets keys[i] to values[i], the keys must be sorted in the tree's order (see Walk),
+// which allows starting each insertion from the path of the previous key rather than the root.
+// It returns an error if the keys aren't sorted, after setting the keys before the unsorted one.
+func (t *Tree) SetSorted(keys []string, values []interface{}) error {
+	if len(keys) != len(values) {
+		return fmt.Errorf("radix: got %d keys and %d values", len(keys), len(values))
+	}
+
+	var (
+		cmp = compareFn(t.fold)
+		lcp = longestPrefixFn(t.fold)
+
+		// the nodes on the path of the previous key and where their prefixes end in it
+		path = []*node{&t.root}
+		ends = []int{0}
+	)
+
+	for i, k := range keys {
+		common := 0
+		if i > 0 {
+			if cmp(keys[i-1], k) > 0 {
+				return fmt.Errorf("radix: keys aren't sorted, %q comes after %q", k, keys[i-1])
+			}
+			common = lcp(keys[i-1], k)
+		}
+
+		// Resume from the deepest node that is a prefix of both keys
+		j := len(path) - 1
+		for ends[j] > common {
+			j--
+		}
+		path, ends = path[:j+1], ends[:j+1]
+
+		off := ends[j]
+		if n, _, _, _ := t.setInfo(path[j], k, k[off:], values[i]); n == nil {
+			// rejected by WithStrictFold, the path is still a prefix of k
+			continue
+		}
+
+		// Record the path of the new key below where we resumed
+		for n, search := path[j], k[off:]; len(search) > 0; {
+			n = n.getEdge(nextRune(search), t.fold)
+			search, off = search[len(n.Prefix):], off+len(n.Prefix)
+			path, ends = append(path, n), append(ends, off)
+		}
+	}
+	return nil
+}
+
+// GetOrSet returns the existing value for key if found, otherwise it sets and returns value.
+// loaded is true if the value was found, false if it was set.
+func (t *Tree) GetOrSet(key string, value interface{}) (actual interface{}, loaded bool) {
+	n, _, loaded := t.set(key, value, false)
+	if t.metrics != nil {
+		t.countGet(loaded)
+		if !loaded {
+			atomic.AddUint64(&t.metrics.Sets, 1)
+		}
+	}
+	if !loaded {
+		t.addKeyLen(len(key))
+		t.stamp(n.Leaf.Key)
+		if t.vidx != nil {
+			t.index(n.Leaf.Key, value)
+		}
+	}
+	return n.Leaf.Value, loaded
+}
+
+// SetIfAbsent sets key to value only if it doesn't exist, returns the current value of key
+// and whether value was set.
+func (t *Tree) SetIfAbsent(key string, value interface{}) (actual interface{}, set bool) {
+	actual, loaded := t.GetOrSet(key, value)
+	return actual, !loaded
+}
+
+// Update calls fn with the current value of key, and whether it exists,
+// if fn returns true the returned value is stored, otherwise the key is deleted.
+// Deleting a key that doesn't exist is a no-op, like Set, the casing of key replaces the stored one.
+// The tree is only traversed once, for a SafeTree, see SafeTree.UpdateKey.
+func (t *Tree) Update(key string, fn func(old interface{}, found bool) (interface{}, bool)) {
+	parent, n, label, search, found := t.find(key)
+	if !found {
+		if v, keep := fn(t.zero, false); keep {
+			if t.metrics != nil {
+				atomic.AddUint64(&t.metrics.Sets, 1)
+			}
+			t.store(n, key, search, v)
+		}
+		return
+	}
+
+	l := n.Leaf
+	v, keep := fn(l.Value, true)
+	if !keep {
+		if t.metrics != nil {
+			atomic.AddUint64(&t.metrics.Deletes, 1)
+		}
+		t.deleteAt(parent, n, label)
+		return
+	}
+
+	if t.metrics != nil {
+		atomic.AddUint64(&t.metrics.Sets, 1)
+	}
+	if !t.strictFold && l.Key != key {
+		t.restamp(l.Key, key)
+	} else {
+		key = l.Key
+	}
+	if t.vidx != nil {
+		t.unindex(l.Key, l.Value)
+		t.index(key, v)
+	}
+	l.Key, l.Value = key, v
+}
+
+// FoldKey returns key the way the tree compares it, case-folded rune by rune if the tree is case-insensitive,
+// keys with the same FoldKey refer to the same entry.
+// Folding is based on Unicode simple case folding without the Turkic mappings, so 'İ' and 'ı' don't match 'i',
+// while 'Σ', 'σ' and 'ς' all match, runes only match runes of the same UTF-8 length, so the Kelvin sign doesn't match 'k'.
+// Invalid UTF-8 bytes are kept as is.
+func (t *Tree) FoldKey(key string) string {
+	if !t.fold {
+		return key
+	}
+
+	var (
+		b    strings.Builder
+		last int // how much of key was copied to b
+	)
+	for i := 0; i < len(key); {
+		r, n := rune(key[i]), 1
+		if r >= utf8.RuneSelf {
+			r, n = utf8.DecodeRuneInString(key[i:])
+		}
+		if lr := foldRune(r); lr != r {
+			if last == 0 {
+				b.Grow(len(key))
+			}
+			b.WriteString(key[last:i])
+			b.WriteRune(lr)
+			last = i + n
+		}
+		i += n
+	}
+
+	if last == 0 {
+		return key
+	}
+	b.WriteString(key[last:])
+	return b.String()
+}
+
+// SetFold is like Set, but also reports if key only differs in case from an existing key.
+// In trees created with WithStrictFold, the existing value is returned and the tree isn't modified.
+func (t *Tree) SetFold(key string, value interface{}) (old interface{}, found, collided bool) {
+	if t.fold {
+		if l := t.getLeaf(key); l != nil && l.Key != key {
+			collided = true
+		}
+	}
+	old, found = t.Set(key, value)
+	return
+}
+
+// set does the actual insertion and returns the node holding key,
+// if replace is false, the value of an existing key isn't modified.
+func (t *Tree) set(key string, value interface{}, replace bool) (*node, interface{}, bool) {
+	return t.setAt(&t.root, key, key, value, replace)
+}
+
+// setAt is like set, but starts at n, where search is the rest of the key under n.
+func (t *Tree) setAt(n *node, key, search string, value interface{}, replace bool) (*node, interface{}, bool) {
 	var (
 		parent *node
-		n      = &t.root
 		lcp    = longestPrefixFn(t.fold)
-		search = key
 		r      rune
 	)
 
@@ -191,16 +739,15 @@ func (t *Tree) Set(key string, value interface{}) (interface{}, bool) {
 		if len(search) == 0 {
 			if n.isLeafInTheWind() {
 				old := n.Leaf.Value
-				n.Leaf.Value = value
-				return old, true
+				if replace {
+					n.Leaf.Value = value
+				}
+				return n, old, true
 			}
 
-			n.Leaf = &leafNode{
-				Key:   key,
-				Value: value,
-			}
+			n.Leaf = t.newLeaf(key, value)
 			t.size++
-			return t.zero, false
+			return n, t.zero, false
 		}
 
 		// Look for the edge
@@ -211,19 +758,13 @@ func (t *Tree) Set(key string, value interface{}) (interface{}, bool) {
 		// No edge, create one
 		if n == nil {
 
-			e := edge{
+			n = t.newNode(search, t.newLeaf(key, value))
+			parent.addEdge(edge{
 				Label: r,
-				Node: &node{
-					Leaf: &leafNode{
-						Key:   key,
-						Value: value,
-					},
-					Prefix: search,
-				},
-			}
-			parent.addEdge(e, t.fold)
+				Node:  n,
+			}, t.fold)
 			t.size++
-			return t.zero, false
+			return n, t.zero, false
 		}
 
 		// Determine longest prefix of the search key on match
@@ -233,11 +774,15 @@ func (t *Tree) Set(key string, value interface{}) (interface{}, bool) {
 			continue
 		}
 
+		// LongestPrefix compares bytes, so keys differing inside a multibyte rune
+		// would split it, snap it back to the start of the rune.
+		for commonPrefix > 0 && !utf8.RuneStart(n.Prefix[commonPrefix]) {
+			commonPrefix--
+		}
+
 		// Split the node
 		t.size++
-		child := &node{
-			Prefix: search[:commonPrefix],
-		}
+		child := t.newNode(search[:commonPrefix], nil)
 		parent.updateEdge(r, child, t.fold)
 
 		// Restore the existing node
@@ -248,111 +793,178 @@ func (t *Tree) Set(key string, value interface{}) (interface{}, bool) {
 		n.Prefix = n.Prefix[commonPrefix:]
 
 		// Create a new leaf node
-		leaf := &leafNode{
-			Key:   key,
-			Value: value,
-		}
+		leaf := t.newLeaf(key, value)
 
 		// If the new key is a subset, add to to this node
 		search = search[commonPrefix:]
 		if len(search) == 0 {
 			child.Leaf = leaf
-			return t.zero, false
+			return child, t.zero, false
 		}
 
 		r = nextRune(search)
 		// Create a new edge for the node
+		n = t.newNode(search, leaf)
 		child.addEdge(edge{
 			Label: r,
-			Node: &node{
-				Leaf:   leaf,
-				Prefix: search,
-			},
+			Node:  n,
 		}, t.fold)
-		return t.zero, false
+		return n, t.zero, false
 	}
 }
 
 // Delete is used to delete a key, returning the previous
 // value and if it was deleted.
 func (t *Tree) Delete(s string) (interface{}, bool) {
-	var (
-		parent *node
-		label  rune
-		n      = &t.root
-		search = s
-		hp     = hasPrefixFn(t.fold)
-	)
+	old, found, _ := t.DeleteInfo(s)
+	return old, found
+}
+
+// DeleteInfo is like Delete, but also reports if deleting the key merged nodes,
+// which changes the prefixes of the surviving nodes.
+func (t *Tree) DeleteInfo(s string) (old interface{}, found, merged bool) {
+	if t.metrics != nil {
+		atomic.AddUint64(&t.metrics.Deletes, 1)
+	}
+
+	parent, n, label, _, found := t.find(s)
+	if !found {
+		return t.zero, false, false
+	}
+	old, merged = t.deleteAt(parent, n, label)
+	return old, true, merged
+}
 
+// find looks up key, returning the node holding it, its parent and the label of the edge leading to it.
+// If key isn't in the tree, n is the deepest node on its path and search is the rest of key under n,
+// so it can be inserted using setAt(n, key, search, ...).
+func (t *Tree) find(key string) (parent, n *node, label rune, search string, found bool) {
+	n, search = &t.root, key
+	hp := hasPrefixFn(t.fold)
 	for {
 		// Check for key exhaution
 		if len(search) == 0 {
-			if !n.isLeafInTheWind() {
-				break
-			}
-			goto DELETE
+			return parent, n, label, search, n.isLeafInTheWind()
 		}
 
 		// Look for an edge
-		parent = n
-		label = nextRune(search)
-		n = n.getEdge(label, t.fold)
-		if n == nil {
-			break
+		r := nextRune(search)
+		child := n.getEdge(r, t.fold)
+		if child == nil || !hp(search, child.Prefix) {
+			return parent, n, label, search, false
 		}
 
 		// Consume the search prefix
-		if hp(search, n.Prefix) {
-			search = search[len(n.Prefix):]
-		} else {
-			break
-		}
+		parent, n, label = n, child, r
+		search = search[len(child.Prefix):]
 	}
-	return t.zero, false
+}
 
-DELETE:
+// deleteAt deletes the leaf of n, where parent and label are returned by find.
+func (t *Tree) deleteAt(parent, n *node, label rune) (old interface{}, merged bool) {
 	// Delete the leaf
 	leaf := n.Leaf
 	n.Leaf = nil
 	t.size--
+	t.unindex(leaf.Key, leaf.Value)
+	t.unstamp(leaf.Key)
 
-	// Check if we should delete this node from the parent
+	// Check if we should delete this node from the parent,
+	// otherwise check if we should merge this node
 	if parent != nil && len(n.Edges) == 0 {
 		parent.delEdge(label, t.fold)
-	}
-
-	// Check if we should merge this node
-	if n != &t.root && len(n.Edges) == 1 {
-		n.mergeChild()
+		t.freeNode(n)
+	} else if n != &t.root && len(n.Edges) == 1 {
+		t.merge(n)
+		merged = true
 	}
 
 	// Check if we should merge the parent's other child
 	if parent != nil && parent != &t.root && len(parent.Edges) == 1 && !parent.isLeafInTheWind() {
-		parent.mergeChild()
+		t.merge(parent)
+		merged = true
+	}
+
+	if t.delKeyLen(len(leaf.Key)) {
+		t.recomputeKeyLens()
 	}
 
-	return leaf.Value, true
+	old = leaf.Value
+	t.freeLeaf(leaf)
+	return old, merged
+}
+
+// DeleteMany deletes all the given keys and returns how many of them existed and were removed.
+func (t *Tree) DeleteMany(keys []string) (count int) {
+	for _, k := range keys {
+		if _, found := t.Delete(k); found {
+			count++
+		}
+	}
+	return
 }
 
 // DeletePrefix is used to delete the subtree under a prefix
 // Returns how many nodes were deleted.
 // Use this to delete large subtrees efficiently.
 func (t *Tree) DeletePrefix(s string) int {
-	return t.deletePrefix(nil, &t.root, s)
+	return t.deletePrefix(nil, &t.root, s, nil)
+}
+
+// DeletePrefixFn is like DeletePrefix, but calls fn for every deleted entry, before it's removed from the tree.
+func (t *Tree) DeletePrefixFn(prefix string, fn func(key string, v interface{})) int {
+	return t.deletePrefix(nil, &t.root, prefix, func(k string, v interface{}) bool {
+		fn(k, v)
+		return false
+	})
+}
+
+// DeletePrefixKeys is like DeletePrefix, but returns the deleted keys.
+func (t *Tree) DeletePrefixKeys(s string) (keys []string) {
+	t.deletePrefix(nil, &t.root, s, func(k string, _ interface{}) bool {
+		keys = append(keys, k)
+		return false
+	})
+	return
+}
+
+// DrainPrefix is like DeletePrefix, but returns the deleted entries.
+func (t *Tree) DrainPrefix(s string) map[string]interface{} {
+	out := make(map[string]interface{}, t.CountPrefix(s))
+	t.deletePrefix(nil, &t.root, s, func(k string, v interface{}) bool {
+		out[k] = v
+		return false
+	})
+	return out
 }
 
-// delete does a recursive deletion
-func (t *Tree) deletePrefix(parent, n *node, prefix string) int {
+// delete does a recursive deletion, fn (if not nil) is called on every deleted leaf
+func (t *Tree) deletePrefix(parent, n *node, prefix string, fn WalkFn) int {
 	// Check for key exhaustion
 	hp := hasPrefixFn(t.fold)
 	if len(prefix) == 0 {
 		// Remove the leaf node
 		subTreeSize := 0
+		lensChanged := false
 		// recursively walk from all edges of the node to be deleted
-		recursiveWalk(n, func(s string, v interface{}) bool {
+		walkNode(n, func(s string, v interface{}) bool {
 			subTreeSize++
+			t.unindex(s, v)
+			t.unstamp(s)
+			lensChanged = t.delKeyLen(len(s)) || lensChanged
+			if fn != nil {
+				fn(s, v)
+			}
 			return false
 		})
+		if t.pool != nil {
+			for _, e := range n.Edges {
+				t.freeSubtree(e.Node)
+			}
+			if n.isLeafInTheWind() {
+				t.freeLeaf(n.Leaf)
+			}
+		}
 		if n.isLeafInTheWind() {
 			n.Leaf = nil
 		}
@@ -362,13 +974,17 @@ func (t *Tree) deletePrefix(parent, n *node, prefix string) int {
 			r := nextRune(n.Prefix)
 			// delete dangling edge
 			parent.delEdge(r, t.fold)
+			t.freeNode(n)
 		}
 
 		// Check if we should merge the parent's other child
 		if parent != nil && parent != &t.root && len(parent.Edges) == 1 && !parent.isLeafInTheWind() {
-			parent.mergeChild()
+			t.merge(parent)
 		}
 		t.size -= subTreeSize
+		if lensChanged {
+			t.recomputeKeyLens()
+		}
 		return subTreeSize
 	}
 
@@ -379,13 +995,14 @@ func (t *Tree) deletePrefix(parent, n *node, prefix string) int {
 		return 0
 	}
 
-	// Consume the search prefix
-	if len(child.Prefix) > len(prefix) {
-		prefix = prefix[len(prefix):]
+	// Consume the search prefix, if the child's prefix is longer, the check above
+	// means it starts with all of the search prefix, so the whole subtree matches.
+	if len(child.Prefix) >= len(prefix) {
+		prefix = ""
 	} else {
 		prefix = prefix[len(child.Prefix):]
 	}
-	return t.deletePrefix(n, child, prefix)
+	return t.deletePrefix(n, child, prefix, fn)
 }
 
 func (n *node) mergeChild() {
@@ -399,53 +1016,106 @@ func (n *node) mergeChild() {
 // Get is used to lookup a specific key, returning
 // the value and if it was found.
 func (t *Tree) Get(s string) (interface{}, bool) {
-	n := &t.root
-	hp := hasPrefixFn(t.fold)
-	search := s
-	for {
-		// Check for key exhaution
-		if len(search) == 0 {
-			if n.isLeafInTheWind() {
-				return n.Leaf.Value, true
-			}
-			break
+	if t.metrics == nil {
+		return t.get(s)
+	}
+
+	v, ok := t.get(s)
+	t.countGet(ok)
+	return v, ok
+}
+
+func (t *Tree) countGet(hit bool) {
+	atomic.AddUint64(&t.metrics.Gets, 1)
+	if hit {
+		atomic.AddUint64(&t.metrics.Hits, 1)
+	} else {
+		atomic.AddUint64(&t.metrics.Misses, 1)
+	}
+}
+
+// GetExact is like Get, but the key has to match the stored key exactly, even in case-insensitive trees.
+func (t *Tree) GetExact(key string) (interface{}, bool) {
+	if l := t.getLeaf(key); l != nil && l.Key == key {
+		return l.Value, true
+	}
+	return t.zero, false
+}
+
+// GetBytes is like Get, but takes the key as a byte slice, without converting it to a string.
+func (t *Tree) GetBytes(key []byte) (interface{}, bool) {
+	l := t.getLeafBytes(key)
+	if t.metrics != nil {
+		t.countGet(l != nil)
+	}
+	if l == nil {
+		return t.zero, false
+	}
+	return l.Value, true
+}
+
+// SetBytes is like Set, but takes the key as a byte slice, it's only converted to a string if it's a new key
+// or its casing changed.
+func (t *Tree) SetBytes(key []byte, value interface{}) (interface{}, bool) {
+	if l := t.getLeafBytes(key); l != nil && l.Key == string(key) {
+		return t.Set(l.Key, value)
+	}
+	return t.Set(string(key), value)
+}
+
+// DeleteBytes is like Delete, but takes the key as a byte slice, without converting it to a string.
+func (t *Tree) DeleteBytes(key []byte) (interface{}, bool) {
+	if l := t.getLeafBytes(key); l != nil {
+		return t.Delete(l.Key)
+	}
+	if t.metrics != nil {
+		atomic.AddUint64(&t.metrics.Deletes, 1)
+	}
+	return t.zero, false
+}
+
+// getLeafBytes is getLeaf for byte slices.
+func (t *Tree) getLeafBytes(search []byte) *leafNode {
+	n := &t.root
+	for {
+		// Check for key exhaution
+		if len(search) == 0 {
+			return n.Leaf
 		}
 
 		// Look for an edge
-		r := nextRune(search)
-		n = n.getEdge(r, t.fold)
-		if n == nil {
-			break
+		r := rune(search[0])
+		if r >= utf8.RuneSelf {
+			r, _ = utf8.DecodeRune(search)
+		}
+		if n = n.getEdge(r, t.fold); n == nil {
+			return nil
 		}
 
 		// Consume the search prefix
-		if hp(search, n.Prefix) {
-			search = search[len(n.Prefix):]
-		} else {
-			break
+		if !hasPrefixBytes(search, n.Prefix, t.fold) {
+			return nil
 		}
+		search = search[len(n.Prefix):]
+	}
+}
+
+func (t *Tree) get(s string) (interface{}, bool) {
+	if l := t.getLeaf(s); l != nil {
+		return l.Value, true
 	}
 	return t.zero, false
 }
 
-// LongestPrefix is like Get, but instead of an
-// exact match, it will return the longest prefix match.
-func (t *Tree) LongestPrefix(s string) (string, interface{}, bool) {
-	var (
-		last   *leafNode
-		n      = &t.root
-		search = s
-		hp     = hasPrefixFn(t.fold)
-	)
+// getLeaf returns the leaf of key s or nil.
+func (t *Tree) getLeaf(s string) *leafNode {
+	n := &t.root
+	hp := hasPrefixFn(t.fold)
+	search := s
 	for {
-		// Look for a leaf node
-		if n.isLeafInTheWind() {
-			last = n.Leaf
-		}
-
 		// Check for key exhaution
 		if len(search) == 0 {
-			break
+			return n.Leaf
 		}
 
 		// Look for an edge
@@ -462,94 +1132,165 @@ func (t *Tree) LongestPrefix(s string) (string, interface{}, bool) {
 			break
 		}
 	}
-	if last != nil {
-		return last.Key, last.Value, true
+	return nil
+}
+
+// KeyLenRange returns the lengths (in bytes) of the shortest and longest keys in the tree.
+// The range is tracked on Set and Delete, however deleting the last shortest or longest key
+// has to walk the whole tree to recompute it, which is O(n).
+func (t *Tree) KeyLenRange() (min, max int) {
+	return t.minLen, t.maxLen
+}
+
+func (t *Tree) addKeyLen(l int) {
+	switch {
+	case t.minN == 0 || l < t.minLen:
+		t.minLen, t.minN = l, 1
+	case l == t.minLen:
+		t.minN++
+	}
+
+	switch {
+	case t.maxN == 0 || l > t.maxLen:
+		t.maxLen, t.maxN = l, 1
+	case l == t.maxLen:
+		t.maxN++
 	}
-	return "", t.zero, false
 }
 
-// Minimum is used to return the minimum value in the tree.
-func (t *Tree) Minimum() (string, interface{}, bool) {
-	n := &t.root
-	for {
-		if n.isLeafInTheWind() {
-			return n.Leaf.Key, n.Leaf.Value, true
+// delKeyLen returns true if the range has to be recomputed,
+// which happens when the last shortest or longest key is deleted.
+func (t *Tree) delKeyLen(l int) bool {
+	if l == t.minLen {
+		t.minN--
+	}
+	if l == t.maxLen {
+		t.maxN--
+	}
+	return t.minN == 0 || t.maxN == 0
+}
+
+// recomputeKeyLens walks the whole tree, so it's O(n).
+func (t *Tree) recomputeKeyLens() {
+	t.minLen, t.minN, t.maxLen, t.maxN = 0, 0, 0, 0
+	walkNode(&t.root, func(k string, _ interface{}) bool {
+		t.addKeyLen(len(k))
+		return false
+	})
+}
+
+// KeyForValue returns the key holding v.
+// If the tree was created using WithValueIndex, it's a map lookup,
+// otherwise it walks the tree and returns the first key where eq returns true.
+// Indexed trees also walk the tree on a miss if a value was ever set for more than one key,
+// since the index only holds one of them, eq may be nil to only use the index.
+func (t *Tree) KeyForValue(v interface{}, eq func(a, b interface{}) bool) (key string, found bool) {
+	if t.vidx != nil {
+		if key, found = t.vidx[v]; found || !t.vidxDups || eq == nil {
+			return
 		}
-		if len(n.Edges) > 0 {
-			n = n.Edges[0].Node
-		} else {
-			break
+	}
+
+	t.Walk(func(k string, ov interface{}) bool {
+		if eq(v, ov) {
+			key, found = k, true
 		}
+		return found
+	})
+	return
+}
+
+// AgeOf returns how long ago key was added, the tree must be created using WithTimestamps.
+func (t *Tree) AgeOf(key string) (time.Duration, bool) {
+	if t.times == nil {
+		return 0, false
 	}
-	return "", t.zero, false
+	l := t.getLeaf(key)
+	if l == nil {
+		return 0, false
+	}
+	ts, ok := t.times[l.Key]
+	return t.clock().Sub(ts), ok
 }
 
-// Maximum is used to return the maximum value in the tree.
-func (t *Tree) Maximum() (string, interface{}, bool) {
-	n := &t.root
-	for {
-		if num := len(n.Edges); num > 0 {
-			n = n.Edges[num-1].Node
-			continue
-		}
-		if n.isLeafInTheWind() {
-			return n.Leaf.Key, n.Leaf.Value, true
+// OlderThan returns the keys that were added more than d ago, in order,
+// the tree must be created using WithTimestamps.
+func (t *Tree) OlderThan(d time.Duration) (keys []string) {
+	if t.times == nil {
+		return nil
+	}
+	now := t.clock()
+	t.Walk(func(k string, _ interface{}) bool {
+		if ts, ok := t.times[k]; ok && now.Sub(ts) > d {
+			keys = append(keys, k)
 		}
-		break
+		return false
+	})
+	return
+}
+
+func (t *Tree) stamp(key string) {
+	if t.times != nil {
+		t.times[key] = t.clock()
 	}
-	return "", t.zero, false
 }
 
-// Walk is used to walk the tree.
-func (t *Tree) Walk(fn WalkFn) bool {
-	return recursiveWalk(&t.root, fn)
+// restamp moves the timestamp of from to to, when the casing of a key changes.
+func (t *Tree) restamp(from, to string) {
+	if ts, ok := t.times[from]; ok {
+		delete(t.times, from)
+		t.times[to] = ts
+	}
 }
 
-// WalkPrefix is used to walk the tree under a prefix.
-func (t *Tree) WalkPrefix(prefix string, fn WalkFn) bool {
-	n := &t.root
-	hp := hasPrefixFn(t.fold)
-	search := prefix
-	for {
-		// Check for key exhaution
-		if len(search) == 0 {
-			return recursiveWalk(n, fn)
-		}
+func (t *Tree) unstamp(key string) {
+	if t.times != nil {
+		delete(t.times, key)
+	}
+}
 
-		// Look for an edge
-		r := nextRune(search)
-		n = n.getEdge(r, t.fold)
-		if n == nil {
-			break
-		}
+// index points v to key in the value index.
+func (t *Tree) index(key string, v interface{}) {
+	if t.vidx == nil {
+		return
+	}
+	if k, ok := t.vidx[v]; ok && k != key {
+		t.vidxDups = true
+	}
+	t.vidx[v] = key
+}
 
-		// Consume the search prefix
-		if hp(search, n.Prefix) {
-			search = search[len(n.Prefix):]
-		} else if hp(n.Prefix, search) {
-			// Child may be under our search prefix
-			return recursiveWalk(n, fn)
-		} else {
-			break
-		}
+// unindex removes v from the value index if it's still pointing to key.
+func (t *Tree) unindex(key string, v interface{}) {
+	if t.vidx == nil {
+		return
 	}
+	if k, ok := t.vidx[v]; ok && k == key {
+		delete(t.vidx, v)
+	}
+}
 
-	return false
+// LongestPrefix is like Get, but instead of an
+// exact match, it will return the longest prefix match.
+func (t *Tree) LongestPrefix(s string) (string, interface{}, bool) {
+	k, _, v, ok := t.LongestPrefixDiverge(s)
+	return k, v, ok
 }
 
-// WalkNearestPath is like WalkPath but will start at the longest common prefix.
-func (t *Tree) WalkNearestPath(path string, fn WalkFn) bool {
+// LongestPrefixDiverge is like LongestPrefix, but it also returns the byte offset in s
+// where the descent could no longer follow an edge, or len(s) if s was fully consumed.
+// For example, if the tree has "/api/v1" and "/api/v1/abc", "/api/v1/xyz" matches "/api/v1" and diverges at 8.
+func (t *Tree) LongestPrefixDiverge(s string) (matched string, divergedAt int, v interface{}, found bool) {
 	var (
-		last   *node
+		last   *leafNode
 		n      = &t.root
-		search = path
+		search = s
 		hp     = hasPrefixFn(t.fold)
 	)
-
 	for {
 		// Look for a leaf node
 		if n.isLeafInTheWind() {
-			last = n
+			last = n.Leaf
 		}
 
 		// Check for key exhaution
@@ -558,38 +1299,38 @@ func (t *Tree) WalkNearestPath(path string, fn WalkFn) bool {
 		}
 
 		// Look for an edge
-		n = n.getEdge(nextRune(search), t.fold)
-
+		r := nextRune(search)
+		n = n.getEdge(r, t.fold)
 		if n == nil {
 			break
 		}
-		last = n
+
 		// Consume the search prefix
 		if hp(search, n.Prefix) {
 			search = search[len(n.Prefix):]
 		} else {
+			search = search[longestPrefixFn(t.fold)(search, n.Prefix):]
 			break
 		}
 	}
 
+	divergedAt = len(s) - len(search)
 	if last != nil {
-		return recursiveWalk(last, fn)
+		return last.Key, divergedAt, last.Value, true
 	}
-
-	return false
+	return "", divergedAt, t.zero, false
 }
 
-// WalkPath is used to walk the tree, but only visiting nodes
-// from the root down to a given leaf. Where WalkPrefix walks
-// all the entries *under* the given prefix, this walks the
-// entries *above* the given prefix.
-func (t *Tree) WalkPath(path string, fn WalkFn) bool {
-	n := &t.root
-	hp := hasPrefixFn(t.fold)
-	search := path
+// AnyPrefixOf returns true if any key in the tree is a prefix of s,
+// it stops at the first (shortest) matching key.
+func (t *Tree) AnyPrefixOf(s string) bool {
+	var (
+		n      = &t.root
+		search = s
+		hp     = hasPrefixFn(t.fold)
+	)
 	for {
-		// Visit the leaf values if any
-		if n.Leaf != nil && fn(n.Leaf.Key, n.Leaf.Value) {
+		if n.isLeafInTheWind() {
 			return true
 		}
 
@@ -599,78 +1340,2278 @@ func (t *Tree) WalkPath(path string, fn WalkFn) bool {
 		}
 
 		// Look for an edge
-		r := nextRune(search)
-		n = n.getEdge(r, t.fold)
-		if n == nil {
+		if n = n.getEdge(nextRune(search), t.fold); n == nil {
 			return false
 		}
 
 		// Consume the search prefix
-		if hp(search, n.Prefix) {
-			search = search[len(n.Prefix):]
-		} else {
-			break
+		if !hp(search, n.Prefix) {
+			return false
 		}
+		search = search[len(n.Prefix):]
 	}
-	return false
 }
 
-func (t *Tree) MergeMap(m map[string]interface{}) *Tree {
-	for k, v := range m {
-		t.Set(k, v)
+// Minimum is used to return the minimum value in the tree.
+func (t *Tree) Minimum() (string, interface{}, bool) {
+	if l := minLeaf(&t.root); l != nil {
+		return l.Key, l.Value, true
 	}
-	return t
+	return "", t.zero, false
 }
 
-func (t *Tree) Merge(ot *Tree) *Tree {
-	ot.Walk(func(k string, v interface{}) bool {
-		t.Set(k, v)
-		return false
-	})
-	return t
+// Maximum is used to return the maximum value in the tree.
+func (t *Tree) Maximum() (string, interface{}, bool) {
+	if l := maxLeaf(&t.root); l != nil {
+		return l.Key, l.Value, true
+	}
+	return "", t.zero, false
 }
 
-// ToMap is used to walk the tree and convert it into a map.
-func (t *Tree) ToMap() map[string]interface{} {
-	out := make(map[string]interface{}, t.size)
-	t.Walk(func(k string, v interface{}) bool {
-		out[k] = v
-		return false
-	})
-	return out
+// MinimumPrefix is like Minimum, but only considers the keys under prefix.
+func (t *Tree) MinimumPrefix(prefix string) (string, interface{}, bool) {
+	if n, _ := t.prefixNode(prefix); n != nil {
+		if l := minLeaf(n); l != nil {
+			return l.Key, l.Value, true
+		}
+	}
+	return "", t.zero, false
 }
 
-func (t *Tree) DumpTo(w io.Writer, asJSON bool) error {
-	if asJSON {
-		enc := json.NewEncoder(w)
-		enc.SetIndent("", "\t")
-		return enc.Encode(t.root.Edges)
+// MaximumPrefix is like Maximum, but only considers the keys under prefix.
+func (t *Tree) MaximumPrefix(prefix string) (string, interface{}, bool) {
+	if n, _ := t.prefixNode(prefix); n != nil {
+		if l := maxLeaf(n); l != nil {
+			return l.Key, l.Value, true
+		}
 	}
-	return t.root.dump(w, "")
+	return "", t.zero, false
 }
 
-func (t *Tree) Dump(asJSON bool) string {
-	var buf strings.Builder
-	t.DumpTo(&buf, asJSON)
-	return buf.String()
-}
+// Predecessor returns the largest key that is less than key, key doesn't have to exist in the tree.
+func (t *Tree) Predecessor(key string) (string, interface{}, bool) {
+	var (
+		n      = &t.root
+		search = key
+		hp     = hasPrefixFn(t.fold)
+		cmp    = compareFn(t.fold)
 
-// recursiveWalk is used to do a pre-order walk of a node
-// recursively. Returns true if the walk should be aborted
-func recursiveWalk(n *node, fn WalkFn) bool {
-	if n == nil {
-		return false
-	}
-	// Visit the leaf values if any
-	if n.Leaf != nil && fn(n.Leaf.Key, n.Leaf.Value) {
-		return true
-	}
+		// the best candidate so far, either its max leaf or its own leaf
+		cand    *node
+		candMax bool
+	)
 
-	// Recurse on the children
-	for _, e := range n.Edges {
-		if recursiveWalk(e.Node, fn) {
-			return true
+	for len(search) > 0 {
+		// Every key under the previous edge is smaller than key, and larger than n's own key.
+		idx := n.edgeIndex(nextRune(search), t.fold)
+		if idx > 0 {
+			cand, candMax = n.Edges[idx-1].Node, true
+		} else if n.Leaf != nil {
+			cand, candMax = n, false
+		}
+
+		if idx == len(n.Edges) || n.Edges[idx].Label != foldLabel(nextRune(search), t.fold) {
+			break
+		}
+
+		c := n.Edges[idx].Node
+		if hp(search, c.Prefix) {
+			search, n = search[len(c.Prefix):], c
+			continue
 		}
+
+		// All the keys under c are either smaller or larger than key
+		if cmp(search, c.Prefix) > 0 {
+			cand, candMax = c, true
+		}
+		break
 	}
-	return false
+
+	var l *leafNode
+	if cand != nil && candMax {
+		l = maxLeaf(cand)
+	} else if cand != nil {
+		l = cand.Leaf
+	}
+	if l == nil {
+		return "", t.zero, false
+	}
+	return l.Key, l.Value, true
+}
+
+// Successor returns the smallest key that is greater than key, key doesn't have to exist in the tree.
+func (t *Tree) Successor(key string) (string, interface{}, bool) {
+	var (
+		n      = &t.root
+		search = key
+		hp     = hasPrefixFn(t.fold)
+		cmp    = compareFn(t.fold)
+
+		// the smallest subtree so far with all its keys larger than key
+		cand *node
+	)
+
+	for {
+		if len(search) == 0 {
+			// n's own key is key, so its children are the next ones
+			if len(n.Edges) > 0 {
+				cand = n.Edges[0].Node
+			}
+			break
+		}
+
+		idx := n.edgeIndex(nextRune(search), t.fold)
+		if idx == len(n.Edges) || n.Edges[idx].Label != foldLabel(nextRune(search), t.fold) {
+			if idx < len(n.Edges) {
+				cand = n.Edges[idx].Node
+			}
+			break
+		}
+		if idx+1 < len(n.Edges) {
+			cand = n.Edges[idx+1].Node
+		}
+
+		c := n.Edges[idx].Node
+		if hp(search, c.Prefix) {
+			search, n = search[len(c.Prefix):], c
+			continue
+		}
+
+		// All the keys under c are either smaller or larger than key
+		if cmp(search, c.Prefix) < 0 {
+			cand = c
+		}
+		break
+	}
+
+	if cand == nil {
+		return "", t.zero, false
+	}
+	l := minLeaf(cand)
+	return l.Key, l.Value, true
+}
+
+func minLeaf(n *node) *leafNode {
+	for {
+		if n.isLeafInTheWind() {
+			return n.Leaf
+		}
+		if len(n.Edges) > 0 {
+			n = n.Edges[0].Node
+		} else {
+			return nil
+		}
+	}
+}
+
+func maxLeaf(n *node) *leafNode {
+	for {
+		if num := len(n.Edges); num > 0 {
+			n = n.Edges[num-1].Node
+			continue
+		}
+		return n.Leaf
+	}
+}
+
+// Entry is a key and its value.
+type Entry struct {
+	Key   string
+	Value interface{}
+}
+
+// SmallestN returns up to n entries with the smallest keys, in ascending order.
+func (t *Tree) SmallestN(n int) []Entry {
+	if n > t.size {
+		n = t.size
+	}
+	if n <= 0 {
+		return nil
+	}
+	out := make([]Entry, 0, n)
+	walkNode(&t.root, func(k string, v interface{}) bool {
+		out = append(out, Entry{k, v})
+		return len(out) == n
+	})
+	return out
+}
+
+// LargestN returns up to n entries with the largest keys, in descending order.
+func (t *Tree) LargestN(n int) []Entry {
+	if n > t.size {
+		n = t.size
+	}
+	if n <= 0 {
+		return nil
+	}
+	out := make([]Entry, 0, n)
+	reverseWalk(&t.root, func(k string, v interface{}) bool {
+		out = append(out, Entry{k, v})
+		return len(out) == n
+	})
+	return out
+}
+
+// Walk is used to walk the tree.
+func (t *Tree) Walk(fn WalkFn) bool {
+	return walkNode(&t.root, fn)
+}
+
+// WalkDepth is like Walk, but also passes the depth of each key to fn, which is the number of nodes
+// from the root to the node holding the key, the empty key has a depth of 0.
+// Depths reflect the current shape of the tree, so they can change when other keys are added or deleted.
+func (t *Tree) WalkDepth(fn func(key string, v interface{}, depth int) bool) bool {
+	type item struct {
+		n     *node
+		depth int
+	}
+
+	stack := []item{{&t.root, 0}}
+	for len(stack) > 0 {
+		it := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if l := it.n.Leaf; l != nil && fn(l.Key, l.Value, it.depth) {
+			return true
+		}
+
+		// Push the children in reverse, so they're popped in order
+		for i := len(it.n.Edges) - 1; i >= 0; i-- {
+			stack = append(stack, item{it.n.Edges[i].Node, it.depth + 1})
+		}
+	}
+	return false
+}
+
+// WalkErrPrune walks the tree in order, if fn returns prune, the keys under the current key are skipped,
+// and if it returns an error, the walk is aborted and the error is returned.
+func (t *Tree) WalkErrPrune(fn func(key string, v interface{}) (prune bool, err error)) error {
+	stack := []*node{&t.root}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if n.Leaf != nil {
+			prune, err := fn(n.Leaf.Key, n.Leaf.Value)
+			if err != nil {
+				return err
+			}
+			if prune {
+				continue
+			}
+		}
+
+		// Push the children in reverse, so they're popped in order
+		for i := len(n.Edges) - 1; i >= 0; i-- {
+			stack = append(stack, n.Edges[i].Node)
+		}
+	}
+	return nil
+}
+
+// Matcher is used by WalkMatch to select keys.
+type Matcher interface {
+	// MatchPrefix returns false if no key starting with prefix can match, so the subtree can be skipped.
+	MatchPrefix(prefix string) (mightMatch bool)
+
+	// Match returns true if key matches.
+	Match(key string) bool
+}
+
+// WalkMatch walks the keys matched by m in order, skipping the subtrees m.MatchPrefix rejects.
+func (t *Tree) WalkMatch(m Matcher, fn WalkFn) bool {
+	type item struct {
+		n    *node
+		path string
+	}
+
+	stack := []item{{&t.root, ""}}
+	for len(stack) > 0 {
+		it := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		n := it.n
+		if !m.MatchPrefix(it.path) {
+			continue
+		}
+
+		if n.Leaf != nil && m.Match(n.Leaf.Key) && fn(n.Leaf.Key, n.Leaf.Value) {
+			return true
+		}
+
+		// Push the children in reverse, so they're popped in order
+		for i := len(n.Edges) - 1; i >= 0; i-- {
+			c := n.Edges[i].Node
+			stack = append(stack, item{c, it.path + c.Prefix})
+		}
+	}
+	return false
+}
+
+// WalkGlob walks the keys matching pattern in order, where '*' matches any run of characters, including none,
+// and '?' matches a single character, every other character matches itself.
+// Subtrees that can't match the pattern are skipped, so a literal prefix before the first wildcard
+// limits the walk to the keys under it.
+func (t *Tree) WalkGlob(pattern string, fn WalkFn) bool {
+	return t.WalkMatch(globPattern{pattern: pattern, fold: t.fold}, fn)
+}
+
+// KeyError wraps an error returned for a specific key.
+type KeyError struct {
+	Key string
+	Err error
+}
+
+func (e *KeyError) Error() string {
+	return fmt.Sprintf("radix: %q: %v", e.Key, e.Err)
+}
+
+func (e *KeyError) Unwrap() error {
+	return e.Err
+}
+
+// WalkApply calls fn for every key in order, unlike WalkErrPrune, it doesn't stop on errors,
+// it returns all of them in key order, each wrapped in a *KeyError.
+func (t *Tree) WalkApply(fn func(key string, v interface{}) error) (errs []error) {
+	walkNode(&t.root, func(k string, v interface{}) bool {
+		if err := fn(k, v); err != nil {
+			errs = append(errs, &KeyError{Key: k, Err: err})
+		}
+		return false
+	})
+	return
+}
+
+// WalkRuns walks the tree in order, grouping consecutive keys with equal values (per eq)
+// and calling fn once per run with the first and last keys, the run's first value and its length.
+func (t *Tree) WalkRuns(eq func(a, b interface{}) bool, fn func(startKey, endKey string, v interface{}, count int) bool) bool {
+	var (
+		start, end string
+		val        interface{}
+		count      int
+	)
+
+	if t.Walk(func(k string, v interface{}) bool {
+		if count > 0 && eq(val, v) {
+			end = k
+			count++
+			return false
+		}
+		if count > 0 && fn(start, end, val, count) {
+			return true
+		}
+		start, end, val, count = k, k, v, 1
+		return false
+	}) {
+		return true
+	}
+
+	return count > 0 && fn(start, end, val, count)
+}
+
+// LeafKeys walks the keys that aren't a prefix of any other key,
+// i.e. the "files" rather than the "directories".
+func (t *Tree) LeafKeys(fn WalkFn) bool {
+	stack := []*node{&t.root}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if len(n.Edges) == 0 {
+			if n.isLeafInTheWind() && fn(n.Leaf.Key, n.Leaf.Value) {
+				return true
+			}
+			continue
+		}
+
+		for i := len(n.Edges) - 1; i >= 0; i-- {
+			stack = append(stack, n.Edges[i].Node)
+		}
+	}
+	return false
+}
+
+// WalkBuf walks the tree, reconstructing the keys from the tree's structure into a single reused buffer,
+// which avoids allocating a key per leaf.
+// fn must not retain or modify key, it is only valid until fn returns.
+// In case-insensitive trees, the casing of key may differ from the stored key.
+func (t *Tree) WalkBuf(fn func(key []byte, v interface{}) bool) bool {
+	var (
+		buf   = make([]byte, 0, t.maxLen)
+		stack = []bufFrame{{&t.root, 0}}
+	)
+
+	for len(stack) > 0 {
+		f := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		buf = append(buf[:f.ln], f.n.Prefix...)
+		if f.n.isLeafInTheWind() && fn(buf, f.n.Leaf.Value) {
+			return true
+		}
+
+		for i := len(f.n.Edges) - 1; i >= 0; i-- {
+			stack = append(stack, bufFrame{f.n.Edges[i].Node, len(buf)})
+		}
+	}
+	return false
+}
+
+type bufFrame struct {
+	n  *node
+	ln int
+}
+
+// WalkValues is like Walk, but only visits the entries where pred returns true.
+func (t *Tree) WalkValues(pred func(interface{}) bool, fn WalkFn) bool {
+	return walkNode(&t.root, filterFn(pred, fn))
+}
+
+// WalkPrefixValues is like WalkPrefix, but only visits the entries where pred returns true.
+func (t *Tree) WalkPrefixValues(prefix string, pred func(interface{}) bool, fn WalkFn) bool {
+	return t.WalkPrefix(prefix, filterFn(pred, fn))
+}
+
+func filterFn(pred func(interface{}) bool, fn WalkFn) WalkFn {
+	return func(k string, v interface{}) bool {
+		return pred(v) && fn(k, v)
+	}
+}
+
+// WalkPrefix is used to walk the tree under a prefix.
+func (t *Tree) WalkPrefix(prefix string, fn WalkFn) bool {
+	if n, _ := t.prefixNode(prefix); n != nil {
+		return walkNode(n, fn)
+	}
+	return false
+}
+
+// WalkN is like Walk, but stops after n keys, it returns true only if fn aborted the walk.
+func (t *Tree) WalkN(n int, fn WalkFn) bool {
+	return t.WalkPrefixN("", n, fn)
+}
+
+// WalkPrefixN is like WalkPrefix, but stops after n keys, it returns true only if fn aborted the walk.
+func (t *Tree) WalkPrefixN(prefix string, n int, fn WalkFn) (aborted bool) {
+	if n <= 0 {
+		return
+	}
+	t.WalkPrefix(prefix, func(k string, v interface{}) bool {
+		if aborted = fn(k, v); aborted {
+			return true
+		}
+		n--
+		return n == 0
+	})
+	return
+}
+
+// WalkParallel walks the subtrees under the root's edges using up to workers goroutines,
+// a workers value <= 0 uses runtime.GOMAXPROCS(0).
+// If the root has a single edge, the subtrees are taken from the first node with more than one edge,
+// the keys along the way are visited first by the calling goroutine.
+// fn must be safe to call concurrently, and keys are only visited in order within the same subtree.
+// If fn returns true, all the workers stop as soon as possible and WalkParallel returns true.
+func (t *Tree) WalkParallel(workers int, fn WalkFn) bool {
+	n := &t.root
+	for {
+		if n.Leaf != nil && fn(n.Leaf.Key, n.Leaf.Value) {
+			return true
+		}
+		if len(n.Edges) != 1 {
+			break
+		}
+		n = n.Edges[0].Node
+	}
+
+	edges := n.Edges
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers > len(edges) {
+		workers = len(edges)
+	}
+	if workers <= 1 {
+		for _, e := range edges {
+			if walkNode(e.Node, fn) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var (
+		wg      sync.WaitGroup
+		next    int32 = -1
+		aborted uint32
+	)
+	walk := func(k string, v interface{}) bool {
+		if atomic.LoadUint32(&aborted) == 1 {
+			return true
+		}
+		if fn(k, v) {
+			atomic.StoreUint32(&aborted, 1)
+			return true
+		}
+		return false
+	}
+
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(atomic.AddInt32(&next, 1))
+				if i >= len(edges) || walkNode(edges[i].Node, walk) {
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return atomic.LoadUint32(&aborted) == 1
+}
+
+// Complete returns up to limit keys under prefix in order, a limit <= 0 returns all of them.
+func (t *Tree) Complete(prefix string, limit int) (keys []string) {
+	t.walkLimit(prefix, limit, func(k string, _ interface{}) bool {
+		keys = append(keys, k)
+		return false
+	})
+	return
+}
+
+// CompleteWithValues is like Complete, but returns the values as well.
+func (t *Tree) CompleteWithValues(prefix string, limit int) (out []Entry) {
+	t.walkLimit(prefix, limit, func(k string, v interface{}) bool {
+		out = append(out, Entry{Key: k, Value: v})
+		return false
+	})
+	return
+}
+
+func (t *Tree) walkLimit(prefix string, limit int, fn WalkFn) {
+	if limit <= 0 {
+		t.WalkPrefix(prefix, fn)
+	} else {
+		t.WalkPrefixN(prefix, limit, fn)
+	}
+}
+
+// Fuzzy returns the keys within maxDist Levenshtein distance (in runes) of query, in order.
+// The distance row is shared by all the keys under a node and subtrees that can't match are skipped.
+func (t *Tree) Fuzzy(query string, maxDist int) (keys []string) {
+	if maxDist < 0 {
+		return
+	}
+
+	q := []rune(query)
+	if t.fold {
+		for i, r := range q {
+			q[i] = foldRune(r)
+		}
+	}
+
+	type item struct {
+		n   *node
+		row []int
+	}
+
+	row := make([]int, len(q)+1)
+	for i := range row {
+		row[i] = i
+	}
+
+	stack := []item{{&t.root, row}}
+	for len(stack) > 0 {
+		it := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		row, min := it.row, 0
+		for _, r := range it.n.Prefix {
+			if t.fold {
+				r = foldRune(r)
+			}
+
+			next := make([]int, len(row))
+			next[0], min = row[0]+1, row[0]+1
+			for i := 1; i < len(next); i++ {
+				cost := 1
+				if q[i-1] == r {
+					cost = 0
+				}
+				next[i] = minInt(next[i-1]+1, row[i]+1, row[i-1]+cost)
+				if next[i] < min {
+					min = next[i]
+				}
+			}
+			if row = next; min > maxDist {
+				break
+			}
+		}
+		if min > maxDist {
+			continue
+		}
+
+		if it.n.Leaf != nil && row[len(row)-1] <= maxDist {
+			keys = append(keys, it.n.Leaf.Key)
+		}
+
+		// Push the children in reverse, so they're popped in order
+		for i := len(it.n.Edges) - 1; i >= 0; i-- {
+			stack = append(stack, item{it.n.Edges[i].Node, row})
+		}
+	}
+	return
+}
+
+func minInt(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// PrefixInfo reports if any keys exist under prefix, if prefix itself is a key and
+// how many keys live under it, not counting prefix itself.
+func (t *Tree) PrefixInfo(prefix string) (exists bool, isKey bool, descendants int) {
+	n, exact := t.prefixNode(prefix)
+	if n == nil {
+		return
+	}
+	isKey = exact && n.isLeafInTheWind()
+	walkNode(n, func(string, interface{}) bool {
+		descendants++
+		return false
+	})
+	if isKey {
+		descendants--
+	}
+	// the root is always there, even if the tree is empty
+	return isKey || descendants > 0, isKey, descendants
+}
+
+// prefixNode returns the node holding the subtree of all the keys under prefix,
+// exact is set if the node's path matches prefix exactly.
+func (t *Tree) prefixNode(prefix string) (n *node, exact bool) {
+	n = &t.root
+	hp := hasPrefixFn(t.fold)
+	search := prefix
+	for {
+		// Check for key exhaution
+		if len(search) == 0 {
+			return n, true
+		}
+
+		// Look for an edge
+		r := nextRune(search)
+		n = n.getEdge(r, t.fold)
+		if n == nil {
+			break
+		}
+
+		// Consume the search prefix
+		if hp(search, n.Prefix) {
+			search = search[len(n.Prefix):]
+		} else if hp(n.Prefix, search) {
+			// Child may be under our search prefix
+			return n, false
+		} else {
+			break
+		}
+	}
+
+	return nil, false
+}
+
+// WalkNearestPath is like WalkPath but will start at the longest common prefix.
+func (t *Tree) WalkNearestPath(path string, fn WalkFn) bool {
+	var (
+		last   *node
+		n      = &t.root
+		search = path
+		hp     = hasPrefixFn(t.fold)
+	)
+
+	for {
+		// Look for a leaf node
+		if n.isLeafInTheWind() {
+			last = n
+		}
+
+		// Check for key exhaution
+		if len(search) == 0 {
+			break
+		}
+
+		// Look for an edge
+		n = n.getEdge(nextRune(search), t.fold)
+
+		if n == nil {
+			break
+		}
+		last = n
+		// Consume the search prefix
+		if hp(search, n.Prefix) {
+			search = search[len(n.Prefix):]
+		} else {
+			break
+		}
+	}
+
+	if last != nil {
+		return walkNode(last, fn)
+	}
+
+	return false
+}
+
+// WalkPath is used to walk the tree, but only visiting nodes
+// from the root down to a given leaf. Where WalkPrefix walks
+// all the entries *under* the given prefix, this walks the
+// entries *above* the given prefix.
+func (t *Tree) WalkPath(path string, fn WalkFn) bool {
+	return t.walkPath(path, false, fn)
+}
+
+// WalkPathSkipRoot is like WalkPath, but doesn't visit the empty key if it exists,
+// which is useful when it's used as a catch-all.
+func (t *Tree) WalkPathSkipRoot(path string, fn WalkFn) bool {
+	return t.walkPath(path, true, fn)
+}
+
+// PrefixChain returns all the keys that are prefixes of s, and their values, from the shortest to the longest.
+func (t *Tree) PrefixChain(s string) (out []Entry) {
+	t.walkPath(s, false, func(k string, v interface{}) bool {
+		out = append(out, Entry{k, v})
+		return false
+	})
+	return
+}
+
+func (t *Tree) walkPath(path string, skipRoot bool, fn WalkFn) bool {
+	n := &t.root
+	hp := hasPrefixFn(t.fold)
+	search := path
+	for {
+		// Visit the leaf values if any
+		if n.Leaf != nil && !(skipRoot && n == &t.root) && fn(n.Leaf.Key, n.Leaf.Value) {
+			return true
+		}
+
+		// Check for key exhaution
+		if len(search) == 0 {
+			return false
+		}
+
+		// Look for an edge
+		r := nextRune(search)
+		n = n.getEdge(r, t.fold)
+		if n == nil {
+			return false
+		}
+
+		// Consume the search prefix
+		if hp(search, n.Prefix) {
+			search = search[len(n.Prefix):]
+		} else {
+			break
+		}
+	}
+	return false
+}
+
+func (t *Tree) MergeMap(m map[string]interface{}) *Tree {
+	for k, v := range m {
+		t.Set(k, v)
+	}
+	return t
+}
+
+// MergeMapSorted is like MergeMap, but it sorts the keys first and inserts them in order with SetSorted,
+// which is faster for large maps.
+func (t *Tree) MergeMapSorted(m map[string]interface{}) *Tree {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	if t.fold {
+		sort.Slice(keys, func(i, j int) bool { return compareFold(keys[i], keys[j]) < 0 })
+	} else {
+		sort.Strings(keys)
+	}
+
+	values := make([]interface{}, len(keys))
+	for i, k := range keys {
+		values[i] = m[k]
+	}
+	// can't fail, the keys are sorted and the lengths match
+	_ = t.SetSorted(keys, values)
+	return t
+}
+
+func (t *Tree) Merge(ot *Tree) *Tree {
+	ot.Walk(func(k string, v interface{}) bool {
+		t.Set(k, v)
+		return false
+	})
+	return t
+}
+
+// Apply applies a set of changes to the tree, a nil value deletes the key, otherwise it gets set.
+// Returns the number of set keys and the number of keys that were actually deleted.
+func (t *Tree) Apply(changes map[string]*interface{}) (upserted, deleted int) {
+	for k, v := range changes {
+		if v != nil {
+			t.Set(k, *v)
+			upserted++
+		} else if _, ok := t.Delete(k); ok {
+			deleted++
+		}
+	}
+	return
+}
+
+// WalkDelta walks t and base simultaneously in order, calling fn with the operations needed
+// to turn base into t:
+//
+//	'+' the key was added, v is the new value.
+//	'-' the key was removed, v is the old value.
+//	'~' the value changed (per reflect.DeepEqual), v is the new value.
+//
+// Both trees should have the same case-sensitivity.
+func (t *Tree) WalkDelta(base *Tree, fn func(op byte, key string, v interface{}) bool) bool {
+	return t.join(base, func(nl, ol *leafNode) bool {
+		switch {
+		case nl == nil:
+			return fn('-', ol.Key, ol.Value)
+		case ol == nil:
+			return fn('+', nl.Key, nl.Value)
+		case !reflect.DeepEqual(ol.Value, nl.Value):
+			return fn('~', nl.Key, nl.Value)
+		}
+		return false
+	})
+}
+
+// Reconcile computes the changes needed to turn t into desired, calling onAdd for keys only in desired,
+// onRemove for keys only in t and onChange for keys with different values (per reflect.DeepEqual),
+// with the desired value, or the current one for removals. Any of the callbacks can be nil.
+// Neither tree is modified, it's *NOT* safe to modify them inside the callbacks.
+func (t *Tree) Reconcile(desired *Tree, onAdd, onRemove, onChange func(key string, v interface{})) (adds, removes, changes int) {
+	desired.WalkDelta(t, func(op byte, key string, v interface{}) bool {
+		fn := onChange
+		switch op {
+		case '+':
+			adds++
+			fn = onAdd
+		case '-':
+			removes++
+			fn = onRemove
+		default:
+			changes++
+		}
+		if fn != nil {
+			fn(key, v)
+		}
+		return false
+	})
+	return
+}
+
+// OverlapStats returns the number of keys that only exist in t, only exist in ot and exist in both.
+// Both trees should have the same case-sensitivity.
+func (t *Tree) OverlapStats(ot *Tree) (onlyA, onlyB, both int) {
+	t.join(ot, func(a, b *leafNode) bool {
+		switch {
+		case b == nil:
+			onlyA++
+		case a == nil:
+			onlyB++
+		default:
+			both++
+		}
+		return false
+	})
+	return
+}
+
+// join walks the leaves of t and ot simultaneously in order, calling fn with the leaves of each key,
+// either of them is nil if the key only exists in one of the trees.
+func (t *Tree) join(ot *Tree, fn func(a, b *leafNode) bool) bool {
+	var (
+		cmp    = compareFn(t.fold)
+		ai, bi = newLeafIter(&t.root), newLeafIter(&ot.root)
+		a, b   = ai.next(), bi.next()
+	)
+
+	for a != nil || b != nil {
+		var c int
+		switch {
+		case a == nil:
+			c = 1
+		case b == nil:
+			c = -1
+		default:
+			c = cmp(a.Key, b.Key)
+		}
+
+		switch {
+		case c < 0:
+			if fn(a, nil) {
+				return true
+			}
+			a = ai.next()
+		case c > 0:
+			if fn(nil, b) {
+				return true
+			}
+			b = bi.next()
+		default:
+			if fn(a, b) {
+				return true
+			}
+			a, b = ai.next(), bi.next()
+		}
+	}
+
+	return false
+}
+
+// RenameKeys rebuilds the tree with every key replaced by the result of fn,
+// entries where keep is false are dropped.
+// If multiple keys are renamed to the same key, the last one in the tree's order wins.
+// Returns the number of entries that changed keys.
+func (t *Tree) RenameKeys(fn func(old string) (new string, keep bool)) (changed int) {
+	var (
+		keys = make([]string, 0, t.size)
+		vals = make([]interface{}, 0, t.size)
+	)
+
+	t.Walk(func(k string, v interface{}) bool {
+		nk, keep := fn(k)
+		if !keep {
+			return false
+		}
+		if nk != k {
+			changed++
+		}
+		keys, vals = append(keys, nk), append(vals, v)
+		return false
+	})
+
+	t.reset()
+	for i, k := range keys {
+		t.Set(k, vals[i])
+	}
+	return
+}
+
+// Rebuild reconstructs the tree from its own sorted contents using SetSorted, dropping any structural cruft
+// left over from many splits and merges and trimming edge slices.
+func (t *Tree) Rebuild() {
+	var (
+		keys = make([]string, 0, t.size)
+		vals = make([]interface{}, 0, t.size)
+	)
+
+	t.Walk(func(k string, v interface{}) bool {
+		keys, vals = append(keys, k), append(vals, v)
+		return false
+	})
+
+	// the keys don't change, so keep their timestamps and don't count them as sets
+	times, metrics := t.times, t.metrics
+	t.reset()
+	t.times, t.metrics = nil, nil
+	t.SetSorted(keys, vals) // can't fail, Walk returns the keys in order
+	t.times, t.metrics = times, metrics
+
+	walkNodes(&t.root, func(n *node) {
+		if len(n.Edges) < cap(n.Edges) {
+			n.Edges = append([]edge(nil), n.Edges...)
+		}
+	})
+}
+
+// ApproxSizeBytes returns the approximate memory used by the nodes, edges, prefixes and keys of the tree,
+// it doesn't include the value index, timestamps or any memory referenced by the values.
+func (t *Tree) ApproxSizeBytes() (sz int) {
+	var (
+		nodeSize = int(unsafe.Sizeof(node{}))
+		edgeSize = int(unsafe.Sizeof(edge{}))
+		leafSize = int(unsafe.Sizeof(leafNode{}))
+	)
+
+	walkNodes(&t.root, func(n *node) {
+		sz += nodeSize + cap(n.Edges)*edgeSize + len(n.Prefix)
+		if n.Leaf != nil {
+			sz += leafSize + len(n.Leaf.Key)
+		}
+	})
+	return
+}
+
+// walkNodes calls fn for n and every node under it, in order.
+func walkNodes(n *node, fn func(n *node)) {
+	sp := getWalkStack()
+	stack := append((*sp)[:0], n)
+	used := len(stack)
+	for len(stack) > 0 {
+		n = stack[len(stack)-1].(*node)
+		stack = stack[:len(stack)-1]
+
+		fn(n)
+		for i := len(n.Edges) - 1; i >= 0; i-- {
+			stack = append(stack, n.Edges[i].Node)
+		}
+		if len(stack) > used {
+			used = len(stack)
+		}
+	}
+	putWalkStack(sp, stack, used)
+}
+
+// Clear removes all the entries, keeping the tree's options, so it can be reused.
+func (t *Tree) Clear() {
+	t.reset()
+}
+
+// reset removes all the entries, keeping the tree's options.
+func (t *Tree) reset() {
+	t.root, t.size = node{}, 0
+	t.minLen, t.minN, t.maxLen, t.maxN = 0, 0, 0, 0
+	t.slab, t.freeLeaves = nil, nil
+	t.nodes = nil
+	if t.vidx != nil {
+		t.vidx, t.vidxDups = map[interface{}]string{}, false
+	}
+	if t.times != nil {
+		t.times = map[string]time.Time{}
+	}
+}
+
+// Partitions returns up to n-1 keys that split the tree into n ranges of (roughly) equal size,
+// the i-th range being [split[i-1], split[i]), with the first and last ranges being open.
+// It doesn't walk the tree, it splits the largest subtrees, starting from the root, until there are at least
+// 8n of them, counting the keys of small subtrees and estimating the size of larger ones by sampling random
+// paths down each one, so its cost depends on n rather than the size of the tree.
+// Trees with less than 16n keys are split into exactly equal ranges.
+func (t *Tree) Partitions(n int) []string {
+	if n > t.size {
+		n = t.size
+	}
+	if n <= 1 {
+		return nil
+	}
+
+	type part struct {
+		n     *node
+		leaf  bool // only n's own leaf, not the nodes under it
+		w     float64
+		exact bool // w is the number of keys, not an estimate
+	}
+
+	var (
+		rnd   = rand.New(rand.NewSource(1))
+		parts = []part{{&t.root, false, float64(t.size), true}}
+		limit = float64(t.size) / float64(partitionsOversample*n)
+	)
+
+	for split := true; split; {
+		split = false
+		next := make([]part, 0, 2*len(parts))
+		for _, p := range parts {
+			if p.leaf || len(p.n.Edges) == 0 || p.w <= limit {
+				next = append(next, p)
+				continue
+			}
+
+			// replace p with its own leaf and its children, scaling the estimates to p's size
+			split = true
+			rest, start := p.w, len(next)
+			if p.n.isLeafInTheWind() {
+				next = append(next, part{p.n, true, 1, true})
+				rest--
+			}
+
+			var sum float64
+			for _, e := range p.n.Edges {
+				c := part{n: e.Node}
+				if c.w, c.exact = estimateKeys(e.Node, rnd); c.exact {
+					rest -= c.w
+				} else {
+					sum += c.w
+				}
+				next = append(next, c)
+			}
+
+			for i := start; i < len(next); i++ {
+				if c := &next[i]; !c.exact {
+					// the subtree has more keys than estimateKeys counts
+					if c.w = c.w * rest / sum; c.w <= partitionsExact {
+						c.w = partitionsExact + 1
+					}
+				}
+			}
+		}
+		parts = next
+	}
+
+	var total float64
+	for _, p := range parts {
+		total += p.w
+	}
+
+	// pick the boundary closest to each i/n of the total, leaving enough parts for the remaining splits
+	var (
+		splits = make([]string, 0, n-1)
+		cum    float64
+		b      int
+	)
+	for i := 1; i < n && b < len(parts)-1; i++ {
+		cum += parts[b].w
+		b++
+		for target := total * float64(i) / float64(n); b < len(parts)-n+i && cum+parts[b].w/2 < target; b++ {
+			cum += parts[b].w
+		}
+
+		if p := parts[b]; p.leaf {
+			splits = append(splits, p.n.Leaf.Key)
+		} else {
+			splits = append(splits, minLeaf(p.n).Key)
+		}
+	}
+	return splits
+}
+
+const (
+	// partitionsOversample is how many parts per partition Partitions splits the tree into.
+	partitionsOversample = 8
+
+	// partitionsExact is the number of keys under which Partitions counts the keys of a subtree.
+	partitionsExact = 64
+)
+
+// estimateKeys returns the number of keys under n if there are at most partitionsExact of them,
+// otherwise it estimates it by following random paths down from n and multiplying the number of edges
+// along the way (Knuth's estimator).
+func estimateKeys(n *node, rnd *rand.Rand) (_ float64, exact bool) {
+	const probes = 8
+
+	count := 0
+	if !walkNode(n, func(string, interface{}) bool {
+		count++
+		return count > partitionsExact
+	}) {
+		return float64(count), true
+	}
+
+	var sum float64
+	for i := 0; i < probes; i++ {
+		w := 1.0
+		for c := n; ; c = c.Edges[rnd.Intn(len(c.Edges))].Node {
+			if c.isLeafInTheWind() {
+				sum += w
+			}
+			if len(c.Edges) == 0 {
+				break
+			}
+			w *= float64(len(c.Edges))
+		}
+	}
+	return sum / probes, false
+}
+
+// Clone returns a deep copy of t with the same options, the values themselves are copied as is.
+func (t *Tree) Clone() *Tree {
+	nt := t.emptyCopy()
+	nt.root = *nt.copyNode(&t.root)
+	nt.finishCopy(t)
+	return nt
+}
+
+// Subtree returns a new tree with the same options as t holding the keys under prefix,
+// it doesn't share any nodes with t. It returns nil and false if there are no keys under prefix.
+func (t *Tree) Subtree(prefix string) (*Tree, bool) {
+	return t.subtree(prefix, false)
+}
+
+// SubtreeStripped is like Subtree, but prefix is removed from the keys of the new tree.
+func (t *Tree) SubtreeStripped(prefix string) (*Tree, bool) {
+	return t.subtree(prefix, true)
+}
+
+func (t *Tree) subtree(prefix string, strip bool) (*Tree, bool) {
+	n, _ := t.prefixNode(prefix)
+	cnt := countNode(n)
+	if cnt == 0 {
+		return nil, false
+	}
+
+	keys, values := make([]string, 0, cnt), make([]interface{}, 0, cnt)
+	walkNode(n, func(k string, v interface{}) bool {
+		if strip {
+			k = k[len(prefix):]
+		}
+		keys, values = append(keys, k), append(values, v)
+		return false
+	})
+
+	nt := t.emptyCopy()
+	// can't fail, the walk is in order and removing the same prefix keeps it that way
+	_ = nt.SetSorted(keys, values)
+	return nt, true
+}
+
+// Filter returns a new tree with the same options as t holding the entries pred returns true for.
+func (t *Tree) Filter(pred func(key string, v interface{}) bool) *Tree {
+	var (
+		keys   []string
+		values []interface{}
+	)
+	walkNode(&t.root, func(k string, v interface{}) bool {
+		if pred(k, v) {
+			keys, values = append(keys, k), append(values, v)
+		}
+		return false
+	})
+
+	nt := t.emptyCopy()
+	// can't fail, the walk is in order
+	_ = nt.SetSorted(keys, values)
+	return nt
+}
+
+// Split returns two new trees with the same options as t, left holds the keys that are less than key
+// and right holds the keys that are greater than or equal to key, t isn't modified.
+// Subtrees that are entirely on one side of key are copied without searching them.
+func (t *Tree) Split(key string) (left, right *Tree) {
+	left, right = t.emptyCopy(), t.emptyCopy()
+	l, r := t.splitNode(left, right, &t.root, key, true)
+	left.root, right.root = *l, *r
+	left.finishCopy(t)
+	right.finishCopy(t)
+	return
+}
+
+func (t *Tree) splitNode(lt, rt *Tree, n *node, search string, isRoot bool) (l, r *node) {
+	l, r = lt.newNode(n.Prefix, nil), rt.newNode(n.Prefix, nil)
+	if n.Leaf != nil {
+		if len(search) > 0 {
+			l.Leaf = lt.newLeaf(n.Leaf.Key, n.Leaf.Value)
+		} else {
+			r.Leaf = rt.newLeaf(n.Leaf.Key, n.Leaf.Value)
+		}
+	}
+
+	if len(search) == 0 {
+		for _, e := range n.Edges {
+			r.Edges = append(r.Edges, edge{Label: e.Label, Node: rt.copyNode(e.Node)})
+		}
+		return compactSplit(l, isRoot), compactSplit(r, isRoot)
+	}
+
+	var (
+		label = foldLabel(nextRune(search), t.fold)
+		hp    = hasPrefixFn(t.fold)
+		cmp   = compareFn(t.fold)
+	)
+	for _, e := range n.Edges {
+		c := e.Node
+		switch {
+		case e.Label < label:
+			l.Edges = append(l.Edges, edge{Label: e.Label, Node: lt.copyNode(c)})
+		case e.Label > label:
+			r.Edges = append(r.Edges, edge{Label: e.Label, Node: rt.copyNode(c)})
+		case hp(search, c.Prefix):
+			cl, cr := t.splitNode(lt, rt, c, search[len(c.Prefix):], false)
+			if cl != nil {
+				l.Edges = append(l.Edges, edge{Label: e.Label, Node: cl})
+			}
+			if cr != nil {
+				r.Edges = append(r.Edges, edge{Label: e.Label, Node: cr})
+			}
+		case cmp(search, c.Prefix) < 0:
+			r.Edges = append(r.Edges, edge{Label: e.Label, Node: rt.copyNode(c)})
+		default:
+			l.Edges = append(l.Edges, edge{Label: e.Label, Node: lt.copyNode(c)})
+		}
+	}
+	return compactSplit(l, isRoot), compactSplit(r, isRoot)
+}
+
+// compactSplit removes empty nodes and merges nodes with a single child, except for the root.
+func compactSplit(n *node, isRoot bool) *node {
+	if isRoot || n.Leaf != nil {
+		return n
+	}
+	switch len(n.Edges) {
+	case 0:
+		return nil
+	case 1:
+		n.mergeChild()
+	}
+	return n
+}
+
+// emptyCopy returns an empty tree with the same options as t.
+func (t *Tree) emptyCopy() *Tree {
+	nt := &Tree{
+		fold:       t.fold,
+		strictFold: t.strictFold,
+		slabSize:   t.slabSize,
+		nodeArena:  t.nodeArena,
+		pool:       t.pool,
+	}
+	if t.vidx != nil {
+		nt.vidx = map[interface{}]string{}
+	}
+	if t.metrics != nil {
+		nt.metrics = &TreeMetrics{}
+	}
+	if t.times != nil {
+		nt.times, nt.clock = map[string]time.Time{}, t.clock
+	}
+	return nt
+}
+
+// copyNode returns a deep copy of n, allocated by t.
+func (t *Tree) copyNode(n *node) *node {
+	// shallowCopy copies n, its edges still point to the nodes of n and are replaced by copies below
+	shallowCopy := func(n *node) *node {
+		var l *leafNode
+		if n.Leaf != nil {
+			l = t.newLeaf(n.Leaf.Key, n.Leaf.Value)
+		}
+		nn := t.newNode(n.Prefix, l)
+		if len(n.Edges) > 0 {
+			nn.Edges = make([]edge, len(n.Edges))
+			copy(nn.Edges, n.Edges)
+		}
+		return nn
+	}
+
+	root := shallowCopy(n)
+	sp := getWalkStack()
+	stack := append((*sp)[:0], root)
+	used := len(stack)
+	for len(stack) > 0 {
+		nn := stack[len(stack)-1].(*node)
+		stack = stack[:len(stack)-1]
+
+		for i := range nn.Edges {
+			nn.Edges[i].Node = shallowCopy(nn.Edges[i].Node)
+			stack = append(stack, nn.Edges[i].Node)
+		}
+		if len(stack) > used {
+			used = len(stack)
+		}
+	}
+	putWalkStack(sp, stack, used)
+	return root
+}
+
+// finishCopy updates the size, key lengths, the value index and timestamps after the nodes were copied from src.
+func (t *Tree) finishCopy(src *Tree) {
+	t.size = countNode(&t.root)
+	t.recomputeKeyLens()
+	if t.vidx == nil && t.times == nil {
+		return
+	}
+	walkNode(&t.root, func(k string, v interface{}) bool {
+		if t.vidx != nil {
+			t.index(k, v)
+		}
+		if ts, ok := src.times[k]; ok {
+			t.times[k] = ts
+		}
+		return false
+	})
+}
+
+// MergeFunc is like Merge, but calls fn to resolve the value of keys that exist in both trees.
+func (t *Tree) MergeFunc(ot *Tree, fn func(key string, old, new interface{}) interface{}) *Tree {
+	ot.Walk(func(k string, v interface{}) bool {
+		if old, ok := t.Get(k); ok {
+			v = fn(k, old, v)
+		}
+		t.Set(k, v)
+		return false
+	})
+	return t
+}
+
+// Prune deletes all the entries where isEmpty returns true and returns how many were deleted.
+func (t *Tree) Prune(isEmpty func(interface{}) bool) int {
+	var keys []string
+	t.Walk(func(k string, v interface{}) bool {
+		if isEmpty(v) {
+			keys = append(keys, k)
+		}
+		return false
+	})
+
+	for _, k := range keys {
+		t.Delete(k)
+	}
+	return len(keys)
+}
+
+// ForEachSafe calls fn for every key in the tree, unlike Walk, the keys are collected first so
+// the tree is safe to modify inside fn.
+// If keep is false the entry is deleted, otherwise if changed is true the value is replaced with newVal.
+func (t *Tree) ForEachSafe(fn func(key string, v interface{}) (newVal interface{}, keep bool, changed bool)) {
+	keys := make([]string, 0, t.size)
+	t.Walk(func(k string, _ interface{}) bool {
+		keys = append(keys, k)
+		return false
+	})
+
+	for _, k := range keys {
+		l := t.getLeaf(k)
+		if l == nil {
+			continue
+		}
+		nv, keep, changed := fn(l.Key, l.Value)
+		if !keep {
+			t.Delete(k)
+		} else if changed {
+			t.Set(k, nv)
+		}
+	}
+}
+
+// ToMap is used to walk the tree and convert it into a map.
+func (t *Tree) ToMap() map[string]interface{} {
+	out := make(map[string]interface{}, t.size)
+	t.Walk(func(k string, v interface{}) bool {
+		out[k] = v
+		return false
+	})
+	return out
+}
+
+// Keys returns all the keys in the tree, in order.
+func (t *Tree) Keys() []string {
+	out := make([]string, 0, t.size)
+	t.Walk(func(k string, _ interface{}) bool {
+		out = append(out, k)
+		return false
+	})
+	return out
+}
+
+// Values returns all the values in the tree, sorted by their keys.
+func (t *Tree) Values() []interface{} {
+	out := make([]interface{}, 0, t.size)
+	t.Walk(func(_ string, v interface{}) bool {
+		out = append(out, v)
+		return false
+	})
+	return out
+}
+
+// ToMapPrefix is like ToMap, but only includes the keys under prefix.
+func (t *Tree) ToMapPrefix(prefix string) map[string]interface{} {
+	n, _ := t.prefixNode(prefix)
+	if n == nil {
+		return map[string]interface{}{}
+	}
+	out := make(map[string]interface{}, countNode(n))
+	walkNode(n, func(k string, v interface{}) bool {
+		out[k] = v
+		return false
+	})
+	return out
+}
+
+// ValuesPrefix returns the values under prefix, sorted by their keys.
+func (t *Tree) ValuesPrefix(prefix string) []interface{} {
+	n, _ := t.prefixNode(prefix)
+	if n == nil {
+		return nil
+	}
+	var out []interface{}
+	walkNode(n, func(_ string, v interface{}) bool {
+		out = append(out, v)
+		return false
+	})
+	return out
+}
+
+// CountPrefix returns the number of keys under prefix.
+func (t *Tree) CountPrefix(prefix string) int {
+	n, _ := t.prefixNode(prefix)
+	return countNode(n)
+}
+
+// CountPrefixes returns the number of keys under each of prefixes,
+// prefixes that lead to the same subtree are only counted once.
+func (t *Tree) CountPrefixes(prefixes []string) []int {
+	var (
+		out    = make([]int, len(prefixes))
+		counts = make(map[*node]int, len(prefixes))
+	)
+	for i, prefix := range prefixes {
+		n, _ := t.prefixNode(prefix)
+		if n == nil {
+			continue
+		}
+		c, ok := counts[n]
+		if !ok {
+			c = countNode(n)
+			counts[n] = c
+		}
+		out[i] = c
+	}
+	return out
+}
+
+// countNode returns the number of leaves under n.
+func countNode(n *node) (count int) {
+	walkNode(n, func(string, interface{}) bool {
+		count++
+		return false
+	})
+	return
+}
+
+func (t *Tree) DumpTo(w io.Writer, asJSON bool) error {
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "\t")
+		return enc.Encode(&t.root)
+	}
+	return t.root.dump(w, "")
+}
+
+// WriteTo implements io.WriterTo, it writes the same JSON as DumpTo(w, true),
+// and returns the number of bytes written and the first error.
+func (t *Tree) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	err := t.DumpTo(cw, true)
+	return cw.n, err
+}
+
+// LoadJSON reads a tree written by DumpTo(w, true), the dump doesn't include whether the tree was case-insensitive,
+// so it has to be passed again. Older dumps, which only hold the edges of the root and so lose the empty key,
+// are read as well.
+// If the tree has timestamps (see WithTimestamps), all the keys are stamped with the current time.
+func LoadJSON(r io.Reader, caseInsensitive bool, opts ...Option) (*Tree, error) {
+	var raw json.RawMessage
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	t := New(caseInsensitive, opts...)
+	var err error
+	if raw = bytes.TrimSpace(raw); len(raw) > 0 && raw[0] == '[' {
+		err = json.Unmarshal(raw, &t.root.Edges)
+	} else {
+		err = json.Unmarshal(raw, &t.root)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if t.root.Prefix != "" {
+		return nil, fmt.Errorf("radix: root has a prefix %q", t.root.Prefix)
+	}
+
+	t.size = countNode(&t.root)
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+	t.reindex()
+	return t, nil
+}
+
+func (t *Tree) Dump(asJSON bool) string {
+	var buf strings.Builder
+	t.DumpTo(&buf, asJSON)
+	return buf.String()
+}
+
+// String implements fmt.Stringer, it returns Dump(false).
+// It's meant for debugging, the output includes every node, so it can be huge for large trees.
+func (t *Tree) String() string {
+	return t.Dump(false)
+}
+
+// DumpDOT writes the tree as a Graphviz digraph, with a node per tree node labeled by its prefix,
+// nodes holding a key are drawn with a double border and their key and value.
+func (t *Tree) DumpDOT(w io.Writer) error {
+	type item struct {
+		n  *node
+		id int
+	}
+
+	var (
+		bw    = bufio.NewWriter(w)
+		stack = []item{{&t.root, 0}}
+		ids   = 1
+	)
+
+	bw.WriteString("digraph radix {\n\tnode [shape=box];\n")
+	for len(stack) > 0 {
+		it := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		n := it.n
+		if n.isLeafInTheWind() {
+			label := fmt.Sprintf("%s\n%s = %v", n.Prefix, n.Leaf.Key, n.Leaf.Value)
+			fmt.Fprintf(bw, "\tn%d [label=%s, peripheries=2];\n", it.id, dotQuote(label))
+		} else {
+			fmt.Fprintf(bw, "\tn%d [label=%s];\n", it.id, dotQuote(n.Prefix))
+		}
+
+		for i, e := range n.Edges {
+			fmt.Fprintf(bw, "\tn%d -> n%d [label=%s];\n", it.id, ids+i, dotQuote(string(e.Label)))
+		}
+		// Push the children in reverse, so they're popped in order
+		for i := len(n.Edges) - 1; i >= 0; i-- {
+			stack = append(stack, item{n.Edges[i].Node, ids + i})
+		}
+		ids += len(n.Edges)
+	}
+	bw.WriteString("}\n")
+	return bw.Flush()
+}
+
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`)
+
+// dotQuote returns s as a quoted DOT string.
+func dotQuote(s string) string {
+	return `"` + dotEscaper.Replace(s) + `"`
+}
+
+// MarshalJSONFunc encodes the tree as a JSON object in key order, using encodeValue to encode the values.
+func (t *Tree) MarshalJSONFunc(encodeValue func(interface{}) (json.RawMessage, error)) (_ []byte, err error) {
+	buf := bytes.NewBuffer(make([]byte, 0, 64*t.size+2))
+	buf.WriteByte('{')
+	t.Walk(func(k string, v interface{}) bool {
+		var key, val []byte
+		if key, err = json.Marshal(k); err != nil {
+			return true
+		}
+		if val, err = encodeValue(v); err != nil {
+			return true
+		}
+		if len(val) == 0 {
+			val = []byte("null")
+		}
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(val)
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// UnmarshalJSONFunc sets the entries of a JSON object, using decodeValue to decode the values.
+// The entries are set in the order they appear in, so for repeated keys (or case-variants in
+// case-insensitive trees) the last one wins. Existing keys that aren't in the object are kept.
+func (t *Tree) UnmarshalJSONFunc(data []byte, decodeValue func(json.RawMessage) (interface{}, error)) error {
+	// check the whole document first, so syntax errors don't leave the tree half updated
+	if err := json.Unmarshal(data, new(json.RawMessage)); err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok == nil {
+		return nil
+	}
+	if tok != json.Delim('{') {
+		return fmt.Errorf("radix: expected a JSON object, got %v", tok)
+	}
+
+	for dec.More() {
+		if tok, err = dec.Token(); err != nil {
+			return err
+		}
+		k := tok.(string)
+
+		var raw json.RawMessage
+		if err = dec.Decode(&raw); err != nil {
+			return err
+		}
+		v, err := decodeValue(raw)
+		if err != nil {
+			return fmt.Errorf("radix: key %q: %w", k, err)
+		}
+		t.Set(k, v)
+	}
+	return nil
+}
+
+// gobTree is the gob representation of a tree, the keys are in order.
+type gobTree struct {
+	Fold   bool
+	Keys   []string
+	Values []interface{}
+}
+
+// GobEncode implements gob.GobEncoder, it encodes the keys, values and whether the tree is case-insensitive.
+// Concrete types stored in interface values must be registered with gob.Register by the caller.
+func (t *Tree) GobEncode() ([]byte, error) {
+	gt := gobTree{
+		Fold:   t.fold,
+		Keys:   make([]string, 0, t.size),
+		Values: make([]interface{}, 0, t.size),
+	}
+	walkNode(&t.root, func(k string, v interface{}) bool {
+		gt.Keys, gt.Values = append(gt.Keys, k), append(gt.Values, v)
+		return false
+	})
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&gt); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// GobDecode implements gob.GobDecoder, it replaces the entries of the tree with the decoded ones,
+// other options of the tree are kept.
+func (t *Tree) GobDecode(data []byte) error {
+	var gt gobTree
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&gt); err != nil {
+		return err
+	}
+
+	// decode into a new tree, so t is left untouched on errors
+	nt := t.emptyCopy()
+	nt.fold = gt.Fold
+	if err := nt.SetSorted(gt.Keys, gt.Values); err != nil {
+		return err
+	}
+	nt.metrics = t.metrics
+	*t = *nt
+	return nil
+}
+
+// StructuralHealth returns a score in [0, 1] of how much of the keys' bytes are shared through common prefixes,
+// computed as 1 - (bytes stored in node prefixes / total key bytes).
+// Hierarchical keys (paths, routes) score high, while random high-entropy keys (UUIDs, hashes) branch once
+// near the root and store the rest of the key in a single node, scoring close to 0, in which case a map is
+// likely a better fit.
+// An empty tree has a score of 1.
+func (t *Tree) StructuralHealth() float64 {
+	var stored, total int
+	walkNodes(&t.root, func(n *node) {
+		stored += len(n.Prefix)
+		if n.Leaf != nil {
+			total += len(n.Leaf.Key)
+		}
+	})
+	if total == 0 {
+		return 1
+	}
+	return 1 - float64(stored)/float64(total)
+}
+
+// LeafDepthHistogram returns the number of keys at each node depth, the empty key is at depth 0.
+func (t *Tree) LeafDepthHistogram() map[int]int {
+	h := map[int]int{}
+	sp := getWalkStack()
+	stack := append((*sp)[:0], &t.root)
+	used, depth := len(stack), -1
+	for len(stack) > 0 {
+		v := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		n, ok := v.(*node)
+		if !ok {
+			// a nodeExit, the end of a subtree
+			depth--
+			continue
+		}
+
+		if depth++; n.Leaf != nil {
+			h[depth]++
+		}
+		stack = append(stack, nodeExit{n})
+		for i := len(n.Edges) - 1; i >= 0; i-- {
+			stack = append(stack, n.Edges[i].Node)
+		}
+		if len(stack) > used {
+			used = len(stack)
+		}
+	}
+	putWalkStack(sp, stack, used)
+	return h
+}
+
+// TreeStats holds the structural stats of a tree returned by Stats.
+type TreeStats struct {
+	Nodes  int // including the root
+	Leaves int
+
+	// AvgFanout and MaxFanout are computed over the nodes with edges.
+	AvgFanout float64
+	MaxFanout int
+
+	Height int
+}
+
+// Stats returns the structural stats of the tree, a height close to the number of keys
+// means the tree degraded into a list.
+func (t *Tree) Stats() (st TreeStats) {
+	type item struct {
+		n     *node
+		depth int
+	}
+
+	var (
+		stack = []item{{&t.root, 0}}
+		inner int
+	)
+	for len(stack) > 0 {
+		it := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		st.Nodes++
+		if it.n.Leaf != nil {
+			st.Leaves++
+			if it.depth > st.Height {
+				st.Height = it.depth
+			}
+		}
+
+		if ne := len(it.n.Edges); ne > 0 {
+			inner++
+			st.AvgFanout += float64(ne)
+			if ne > st.MaxFanout {
+				st.MaxFanout = ne
+			}
+		}
+		for _, e := range it.n.Edges {
+			stack = append(stack, item{e.Node, it.depth + 1})
+		}
+	}
+
+	if inner > 0 {
+		st.AvgFanout /= float64(inner)
+	}
+	return
+}
+
+// Height returns the maximum node depth of any key, the empty key is at depth 0.
+func (t *Tree) Height() int {
+	return t.Stats().Height
+}
+
+// MarshalPrefix writes the entries under prefix to w as JSON lines, one {"key", "value"} object per line.
+// Use LoadPrefix to load them back.
+func (t *Tree) MarshalPrefix(w io.Writer, prefix string) (err error) {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	t.WalkPrefix(prefix, func(k string, v interface{}) bool {
+		err = enc.Encode(&leafNode{Key: k, Value: v})
+		return err != nil
+	})
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// LoadPrefix sets the entries written by MarshalPrefix, replacing the from prefix of every key with to,
+// which allows grafting them under a different prefix, from and to can be empty to load the keys as is.
+// from is matched using the tree's case sensitivity. Returns the number of loaded entries.
+func (t *Tree) LoadPrefix(r io.Reader, from, to string) (n int, err error) {
+	hp := hasPrefixFn(t.fold)
+	dec := json.NewDecoder(r)
+	for {
+		var l leafNode
+		if err = dec.Decode(&l); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return
+		}
+		// case-insensitive matches have the same length, so slicing the key is safe
+		if !hp(l.Key, from) {
+			return n, fmt.Errorf("radix: key %q doesn't have the prefix %q", l.Key, from)
+		}
+		t.Set(to+l.Key[len(from):], l.Value)
+		n++
+	}
+}
+
+// Validate checks the tree's internal invariants and returns the first violation found, if any.
+func (t *Tree) Validate() error {
+	leaves, err := t.validate()
+	if err == nil && leaves != t.size {
+		err = fmt.Errorf("radix: size is %d, found %d keys", t.size, leaves)
+	}
+	return err
+}
+
+func (t *Tree) validate() (leaves int, err error) {
+	sp := getWalkStack()
+	stack := append((*sp)[:0], &t.root)
+	used := len(stack)
+	defer func() { putWalkStack(sp, stack, used) }()
+
+	var path string
+	for len(stack) > 0 {
+		v := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		n, ok := v.(*node)
+		if !ok {
+			// the end of a subtree
+			path = path[:len(path)-len(v.(nodeExit).n.Prefix)]
+			continue
+		}
+
+		path += n.Prefix
+		if n.isLeafInTheWind() {
+			leaves++
+			if !t.keyEq(n.Leaf.Key, path) {
+				return 0, fmt.Errorf("radix: key %q stored under %q", n.Leaf.Key, path)
+			}
+		}
+
+		if n != &t.root {
+			if n.Prefix == "" {
+				return 0, fmt.Errorf("radix: empty prefix under %q", path)
+			}
+			if !n.isLeafInTheWind() && len(n.Edges) < 2 {
+				return 0, fmt.Errorf("radix: dangling node %q with %d edges", path, len(n.Edges))
+			}
+		}
+
+		for i, e := range n.Edges {
+			if e.Node == nil {
+				return 0, fmt.Errorf("radix: nil edge %q under %q", e.Label, path)
+			}
+			if i > 0 && n.Edges[i-1].Label >= e.Label {
+				return 0, fmt.Errorf("radix: unsorted edges under %q", path)
+			}
+			if e.Node.Prefix != "" && e.Label != t.edgeLabel(e.Node.Prefix) {
+				return 0, fmt.Errorf("radix: edge %q points to %q under %q", e.Label, e.Node.Prefix, path)
+			}
+		}
+
+		stack = append(stack, nodeExit{n})
+		for i := len(n.Edges) - 1; i >= 0; i-- {
+			stack = append(stack, n.Edges[i].Node)
+		}
+		if len(stack) > used {
+			used = len(stack)
+		}
+	}
+
+	return leaves, nil
+}
+
+// Repair fixes the invariant violations it can (see Validate) and returns how many fixes were made.
+// Nil and empty nodes are removed, single-edge nodes merged, edges are relabeled and re-sorted,
+// keys stored under the wrong path or under edges that collide with a sibling after relabeling are re-inserted,
+// and the size, key lengths, value index and timestamps are recomputed.
+// It's a no-op on a valid tree.
+func (t *Tree) Repair() (fixes int) {
+	var orphans []*leafNode
+	fixes, leaves := t.repair(&orphans)
+
+	size := t.size
+	t.size = leaves
+	for _, l := range orphans {
+		t.setAt(&t.root, l.Key, l.Key, l.Value, false)
+	}
+	if t.size != size {
+		fixes++
+	}
+	if fixes > 0 {
+		t.reindex()
+	}
+	return
+}
+
+// repair fixes the tree bottom-up, the leaves that have to be re-inserted are appended to orphans.
+func (t *Tree) repair(orphans *[]*leafNode) (fixes, leaves int) {
+	sp := getWalkStack()
+	stack := append((*sp)[:0], &t.root)
+	used := len(stack)
+
+	var path string
+	for len(stack) > 0 {
+		v := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		switch n := v.(type) {
+		case *node:
+			path += n.Prefix
+			if n.isLeafInTheWind() {
+				if t.keyEq(n.Leaf.Key, path) {
+					leaves++
+				} else {
+					*orphans = append(*orphans, n.Leaf)
+					n.Leaf = nil
+					fixes++
+				}
+			}
+
+			// fix the edges once the children are fixed
+			stack = append(stack, nodeExit{n})
+			for i := len(n.Edges) - 1; i >= 0; i-- {
+				if c := n.Edges[i].Node; c != nil {
+					stack = append(stack, c)
+				}
+			}
+			if len(stack) > used {
+				used = len(stack)
+			}
+
+		case nodeExit:
+			path = path[:len(path)-len(n.n.Prefix)]
+			ef, orphaned := t.repairEdges(n.n, orphans)
+			fixes, leaves = fixes+ef, leaves-orphaned
+		}
+	}
+	putWalkStack(sp, stack, used)
+	return
+}
+
+// repairEdges fixes the edges of n after its children were fixed, the leaves under edges that collide
+// with a sibling are appended to orphans, and their number is returned.
+func (t *Tree) repairEdges(n *node, orphans *[]*leafNode) (fixes, orphaned int) {
+	edges := n.Edges[:0]
+	for _, e := range n.Edges {
+		c := e.Node
+		if c == nil {
+			fixes++
+			continue
+		}
+
+		if !c.isLeafInTheWind() {
+			switch len(c.Edges) {
+			case 0:
+				fixes++
+				continue
+			case 1:
+				c.mergeChild()
+				fixes++
+			}
+		}
+
+		if c.Prefix != "" {
+			if l := t.edgeLabel(c.Prefix); l != e.Label {
+				e.Label = l
+				fixes++
+			}
+		}
+		edges = append(edges, e)
+	}
+
+	less := func(i, j int) bool { return edges[i].Label < edges[j].Label }
+	if !sort.SliceIsSorted(edges, less) {
+		sort.SliceStable(edges, less)
+		fixes++
+	}
+
+	// Relabeled edges can collide, keep the first one and re-insert the keys under the others
+	uniq := edges[:0]
+	for _, e := range edges {
+		if len(uniq) == 0 || uniq[len(uniq)-1].Label != e.Label {
+			uniq = append(uniq, e)
+			continue
+		}
+		walkNodes(e.Node, func(c *node) {
+			if c.Leaf != nil {
+				*orphans = append(*orphans, c.Leaf)
+				orphaned++
+			}
+		})
+		fixes++
+	}
+
+	for i := len(uniq); i < len(n.Edges); i++ {
+		n.Edges[i] = edge{}
+	}
+	n.Edges = uniq
+	return
+}
+
+// reindex recomputes the key lengths, the value index and timestamps after the nodes were modified directly,
+// keys without a timestamp are stamped with the current time.
+func (t *Tree) reindex() {
+	t.recomputeKeyLens()
+	if t.vidx == nil && t.times == nil {
+		return
+	}
+
+	times := t.times
+	if times != nil {
+		t.times = make(map[string]time.Time, t.size)
+	}
+	if t.vidx != nil {
+		t.vidx, t.vidxDups = map[interface{}]string{}, false
+	}
+	walkNode(&t.root, func(k string, v interface{}) bool {
+		if t.vidx != nil {
+			t.index(k, v)
+		}
+		if times != nil {
+			if ts, ok := times[k]; ok {
+				t.times[k] = ts
+			} else {
+				t.times[k] = t.clock()
+			}
+		}
+		return false
+	})
+}
+
+func (t *Tree) edgeLabel(prefix string) rune {
+	r := nextRune(prefix)
+	if t.fold {
+		r = foldRune(r)
+	}
+	return r
+}
+
+func (t *Tree) keyEq(a, b string) bool {
+	if t.fold {
+		return StringsEqualFold(a, b)
+	}
+	return a == b
+}
+
+// leafIter is a pull-based pre-order iterator over the leaves under a node.
+type leafIter struct {
+	stack []*node
+}
+
+func newLeafIter(n *node) *leafIter {
+	return &leafIter{stack: []*node{n}}
+}
+
+// next returns the next leaf or nil when the iterator is exhausted.
+func (it *leafIter) next() *leafNode {
+	for len(it.stack) > 0 {
+		n := it.stack[len(it.stack)-1]
+		it.stack = it.stack[:len(it.stack)-1]
+
+		for i := len(n.Edges) - 1; i >= 0; i-- {
+			it.stack = append(it.stack, n.Edges[i].Node)
+		}
+
+		if n.isLeafInTheWind() {
+			return n.Leaf
+		}
+	}
+	return nil
+}
+
+// reverseWalk is like walkNode, but visits the keys in descending order.
+func reverseWalk(n *node, fn WalkFn) bool {
+	type frame struct {
+		n    *node
+		next int // the edges left to visit
+	}
+
+	stack := []frame{{n, len(n.Edges)}}
+	for len(stack) > 0 {
+		f := &stack[len(stack)-1]
+		if f.next > 0 {
+			f.next--
+			c := f.n.Edges[f.next].Node
+			stack = append(stack, frame{c, len(c.Edges)})
+			continue
+		}
+
+		// All the children were visited, the leaf comes last
+		stack = stack[:len(stack)-1]
+		if f.n.Leaf != nil && fn(f.n.Leaf.Key, f.n.Leaf.Value) {
+			return true
+		}
+	}
+	return false
+}
+
+// walkStacks holds reusable stacks for the iterative walks, nodes are stored as interface{}
+// since the pool is shared between all the tree types.
+var walkStacks = sync.Pool{
+	New: func() interface{} {
+		s := make([]interface{}, 0, 32)
+		return &s
+	},
+}
+
+// getWalkStack returns an empty stack from walkStacks, it's returned using putWalkStack.
+func getWalkStack() *[]interface{} {
+	return walkStacks.Get().(*[]interface{})
+}
+
+// putWalkStack returns stack to walkStacks, used is the largest length it had.
+// Huge stacks are dropped and only the used part is cleared, so the pool doesn't hold on to any nodes.
+func putWalkStack(sp *[]interface{}, stack []interface{}, used int) {
+	if cap(stack) > maxPooledStack {
+		return
+	}
+	stack = stack[:used]
+	for i := range stack {
+		stack[i] = nil
+	}
+	*sp = stack[:0]
+	walkStacks.Put(sp)
+}
+
+// maxPooledStack is the largest stack capacity returned to walkStacks.
+const maxPooledStack = 1024
+
+// nodeExit is pushed on a walk stack after a node's children, to mark the end of its subtree,
+// it's pointer-shaped, so storing it in an interface{} doesn't allocate.
+type nodeExit struct {
+	n *node
+}
+
+// walkNode is used to do a pre-order walk of a node
+// iteratively. Returns true if the walk should be aborted
+func walkNode(n *node, fn WalkFn) (aborted bool) {
+	if n == nil {
+		return false
+	}
+
+	sp := getWalkStack()
+	stack := append((*sp)[:0], n)
+	used := len(stack)
+	for len(stack) > 0 {
+		n = stack[len(stack)-1].(*node)
+		stack = stack[:len(stack)-1]
+
+		// Visit the leaf values if any
+		if n.Leaf != nil && fn(n.Leaf.Key, n.Leaf.Value) {
+			aborted = true
+			break
+		}
+
+		// Push the children in reverse, so they're popped in order
+		for i := len(n.Edges) - 1; i >= 0; i-- {
+			stack = append(stack, n.Edges[i].Node)
+		}
+		if len(stack) > used {
+			used = len(stack)
+		}
+	}
+	putWalkStack(sp, stack, used)
+	return
 }
@@ -4,15 +4,24 @@
 package radix
 
 import (
+	"bytes"
 	crand "crypto/rand"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"path"
 	"reflect"
+	"regexp"
 	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
-func ExampleRadix() {
+func Example() {
 	var t Tree[int]
 	t.fold = true
 	// or thread-safe version
@@ -127,6 +136,54 @@ func TestDelete(t *testing.T) {
 	}
 }
 
+func TestMultibyteSplit(t *testing.T) {
+	for _, fold := range []bool{false, true} {
+		r := New[interface{}](fold)
+		keys := []string{"aé1", "aé2", "aè1", "aè", "a€", "a₭", "aé"}
+		for i, k := range keys {
+			r.Set(k, i)
+		}
+		if err := r.Validate(); err != nil {
+			t.Fatalf("fold=%v: %v", fold, err)
+		}
+		if r.Len() != len(keys) {
+			t.Fatalf("fold=%v: bad len: %v %v", fold, r.Len(), len(keys))
+		}
+		for i, k := range keys {
+			if v, ok := r.Get(k); !ok || v != i {
+				t.Fatalf("fold=%v: bad value for %q: %v %v", fold, k, v, ok)
+			}
+		}
+	}
+}
+
+func TestEdgeOrder(t *testing.T) {
+	for _, fold := range []bool{false, true} {
+		r := New[interface{}](fold)
+		// ascending, descending and shuffled edges
+		for i := 0; i < 26; i++ {
+			r.Set("a"+string(rune('a'+i)), i)
+			r.Set("b"+string(rune('z'-i)), i)
+			r.Set("c"+string(rune('a'+i*7%26)), i)
+		}
+		if err := r.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range r.root.Edges {
+			n := e.Node
+			if len(n.Edges) != 26 || !sort.SliceIsSorted(n.Edges, func(i, j int) bool { return n.Edges[i].Label < n.Edges[j].Label }) {
+				t.Fatalf("expected 26 sorted edges under %q, got %d", n.Prefix, len(n.Edges))
+			}
+		}
+		for i := 0; i < 26; i++ {
+			k := "c" + string(rune('a'+i*7%26))
+			if v, ok := r.Get(k); !ok || v != i {
+				t.Fatalf("Get(%q): expected %d, got %v", k, i, v)
+			}
+		}
+	}
+}
+
 func TestDeletePrefix(t *testing.T) {
 	type exp struct {
 		inp        []string
@@ -141,6 +198,20 @@ func TestDeletePrefix(t *testing.T) {
 		{[]string{"", "A", "AB", "ABC", "R", "S"}, "", []string{}, 6},
 		{[]string{"", "A", "AB", "ABC", "R", "S"}, "S", []string{"", "A", "AB", "ABC", "R"}, 1},
 		{[]string{"", "A", "AB", "ABC", "R", "S"}, "SS", []string{"", "A", "AB", "ABC", "R", "S"}, 0},
+
+		// partial overlaps with the node prefixes
+		{[]string{"abcd", "abce"}, "abc", []string{}, 2},
+		{[]string{"abcd", "abce"}, "ab", []string{}, 2},
+		{[]string{"abcd", "abce"}, "abcx", []string{"abcd", "abce"}, 0},
+		{[]string{"abcd", "abce"}, "abx", []string{"abcd", "abce"}, 0},
+		{[]string{"abcd", "abce"}, "abcdx", []string{"abcd", "abce"}, 0},
+		{[]string{"abcd", "abce"}, "abcd", []string{"abce"}, 1},
+		{[]string{"abcd", "abce", "x"}, "abce", []string{"abcd", "x"}, 1},
+		{[]string{"abcdef", "x"}, "abcd", []string{"x"}, 1},
+		{[]string{"abcdef", "x"}, "abcx", []string{"abcdef", "x"}, 0},
+		{[]string{"abcdef", "x"}, "abcdefg", []string{"abcdef", "x"}, 0},
+		{[]string{"a", "abcd", "abce"}, "abd", []string{"a", "abcd", "abce"}, 0},
+		{[]string{"a", "abcd", "abce"}, "abcd", []string{"a", "abce"}, 1},
 	}
 
 	for _, test := range cases {
@@ -149,22 +220,3004 @@ func TestDeletePrefix(t *testing.T) {
 			r.Set(ss, true)
 		}
 
-		deleted := r.DeletePrefix(test.prefix)
-		if deleted != test.numDeleted {
-			t.Fatalf("Bad delete, expected %v to be deleted but got %v", test.numDeleted, deleted)
+		deleted := r.DeletePrefix(test.prefix)
+		if deleted != test.numDeleted {
+			t.Fatalf("Bad delete, expected %v to be deleted but got %v", test.numDeleted, deleted)
+		}
+
+		out := []string{}
+		fn := func(s string, v interface{}) bool {
+			out = append(out, s)
+			return false
+		}
+		r.Walk(fn)
+
+		if !reflect.DeepEqual(out, test.out) {
+			t.Fatalf("mis-match: %v %v", out, test.out)
+		}
+		if err := r.Validate(); err != nil {
+			t.Fatalf("DeletePrefix(%q) on %q: %v", test.prefix, test.inp, err)
+		}
+	}
+}
+
+func TestDrainPrefix(t *testing.T) {
+	r := New[interface{}](false)
+	for _, k := range []string{"", "A", "AB", "ABC", "R", "S"} {
+		r.Set(k, k)
+	}
+
+	keys := r.DeletePrefixKeys("AB")
+	sort.Strings(keys)
+	if !reflect.DeepEqual(keys, []string{"AB", "ABC"}) {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+
+	m := r.DrainPrefix("")
+	if len(m) != 4 || r.Len() != 0 {
+		t.Fatalf("unexpected drain: %v (%d)", m, r.Len())
+	}
+	for k, v := range m {
+		if k != v {
+			t.Fatalf("value mis-match: %v %v", k, v)
+		}
+	}
+}
+
+func TestDeletePrefixFn(t *testing.T) {
+	r := New[interface{}](false)
+	for _, k := range []string{"", "A", "AB", "ABC", "R", "S"} {
+		r.Set(k, k)
+	}
+
+	var keys []string
+	n := r.DeletePrefixFn("A", func(k string, v interface{}) {
+		if v != k {
+			t.Fatalf("value mis-match: %v %v", k, v)
+		}
+		// the entry is still in the tree
+		if _, ok := r.Get(k); !ok {
+			t.Fatalf("%q was removed before fn was called", k)
+		}
+		keys = append(keys, k)
+	})
+	if exp := []string{"A", "AB", "ABC"}; n != 3 || !reflect.DeepEqual(keys, exp) {
+		t.Fatalf("expected %q, got %q (%d)", exp, keys, n)
+	}
+	if exp := []string{"", "R", "S"}; !reflect.DeepEqual(r.Keys(), exp) {
+		t.Fatalf("expected %q, got %q", exp, r.Keys())
+	}
+	if n = r.DeletePrefixFn("X", func(string, interface{}) { t.Fatal("unexpected call") }); n != 0 {
+		t.Fatalf("expected 0, got %d", n)
+	}
+}
+
+func TestSafeDrainPrefix(t *testing.T) {
+	const writers, perWriter = 4, 1000
+	var (
+		lt   = New[int](false).Safe()
+		seen = map[string]int{}
+		wg   sync.WaitGroup
+		done = make(chan struct{})
+	)
+
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				lt.Set(fmt.Sprintf("ns/%d/%d", w, i), i)
+			}
+		}(w)
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		for {
+			for k := range lt.DrainPrefix("ns/") {
+				seen[k]++
+			}
+			select {
+			case <-done:
+				return
+			default:
+			}
+		}
+	}()
+
+	wg.Wait()
+	close(done)
+	<-drained
+
+	for _, k := range lt.DeletePrefixKeys("ns/") {
+		seen[k]++
+	}
+
+	if len(seen) != writers*perWriter {
+		t.Fatalf("expected %d keys, got %d", writers*perWriter, len(seen))
+	}
+	for k, n := range seen {
+		if n != 1 {
+			t.Fatalf("%q returned %d times", k, n)
+		}
+	}
+	if lt.Len() != 0 {
+		t.Fatalf("bad length: %v", lt.Len())
+	}
+}
+
+func TestKeyForValue(t *testing.T) {
+	eq := func(a, b interface{}) bool { return a == b }
+	for _, indexed := range []bool{false, true} {
+		var opts []Option
+		if indexed {
+			opts = append(opts, WithValueIndex())
+		}
+		r := New[interface{}](false, opts...)
+		for i, k := range []string{"foo", "foobar", "foo/bar", "zip"} {
+			r.Set(k, i)
+		}
+
+		if k, ok := r.KeyForValue(2, eq); !ok || k != "foo/bar" {
+			t.Fatalf("indexed=%v: bad key: %q %v", indexed, k, ok)
+		}
+
+		r.Set("foo/bar", 10)
+		if _, ok := r.KeyForValue(2, eq); ok {
+			t.Fatalf("indexed=%v: found stale value", indexed)
+		}
+		if k, ok := r.KeyForValue(10, eq); !ok || k != "foo/bar" {
+			t.Fatalf("indexed=%v: bad key: %q %v", indexed, k, ok)
+		}
+
+		r.Delete("zip")
+		r.DeletePrefix("foob")
+		for _, v := range []interface{}{1, 3} {
+			if k, ok := r.KeyForValue(v, eq); ok {
+				t.Fatalf("indexed=%v: found deleted key %q", indexed, k)
+			}
+		}
+		if indexed && len(r.vidx) != r.Len() {
+			t.Fatalf("bad index size: %v %v", len(r.vidx), r.Len())
+		}
+	}
+}
+
+func TestKeyForValueDuplicates(t *testing.T) {
+	eq := func(a, b interface{}) bool { return a == b }
+	for _, indexed := range []bool{false, true} {
+		var opts []Option
+		if indexed {
+			opts = append(opts, WithValueIndex())
+		}
+		r := New[interface{}](false, opts...)
+		r.Set("a", 1)
+		r.Set("b", 1)
+		r.Set("c", 2)
+
+		if k, ok := r.KeyForValue(1, eq); !ok || (k != "a" && k != "b") {
+			t.Fatalf("indexed=%v: bad key: %q %v", indexed, k, ok)
+		}
+
+		r.Delete("b")
+		if k, ok := r.KeyForValue(1, eq); !ok || k != "a" {
+			t.Fatalf("indexed=%v: expected a, got %q %v", indexed, k, ok)
+		}
+
+		r.Set("a", 3)
+		if k, ok := r.KeyForValue(1, eq); ok {
+			t.Fatalf("indexed=%v: found stale key %q", indexed, k)
+		}
+		if k, ok := r.KeyForValue(2, eq); !ok || k != "c" {
+			t.Fatalf("indexed=%v: expected c, got %q %v", indexed, k, ok)
+		}
+
+		if indexed {
+			r.Clear()
+			r.Set("x", 1)
+			r.Set("y", 2)
+			r.Delete("y")
+			if r.vidxDups {
+				t.Fatal("expected unique values after Clear")
+			}
+			if _, ok := r.KeyForValue(2, nil); ok {
+				t.Fatal("found a deleted value")
+			}
+		}
+	}
+}
+
+func TestWalkRuns(t *testing.T) {
+	r := New[interface{}](false)
+	for k, v := range map[string]int{"a": 1, "b": 1, "c": 1, "d": 2, "e": 3, "f": 3} {
+		r.Set(k, v)
+	}
+
+	type run struct {
+		start, end string
+		v          interface{}
+		count      int
+	}
+	var runs []run
+	eq := func(a, b interface{}) bool { return a == b }
+	r.WalkRuns(eq, func(start, end string, v interface{}, count int) bool {
+		runs = append(runs, run{start, end, v, count})
+		return false
+	})
+
+	exp := []run{{"a", "c", 1, 3}, {"d", "d", 2, 1}, {"e", "f", 3, 2}}
+	if !reflect.DeepEqual(runs, exp) {
+		t.Fatalf("mis-match: %v %v", runs, exp)
+	}
+
+	runs = runs[:0]
+	if !r.WalkRuns(eq, func(start, end string, v interface{}, count int) bool {
+		runs = append(runs, run{start, end, v, count})
+		return len(runs) == 2
+	}) || len(runs) != 2 {
+		t.Fatalf("walk wasn't aborted: %v", runs)
+	}
+}
+
+func TestPrefixInfo(t *testing.T) {
+	r := New[interface{}](false)
+	for _, k := range []string{"foo", "foo/bar", "foo/baz", "zip/a", "zip/b"} {
+		r.Set(k, nil)
+	}
+
+	type exp struct {
+		inp         string
+		exists      bool
+		isKey       bool
+		descendants int
+	}
+	cases := []exp{
+		{"", true, false, 5},
+		{"foo", true, true, 2},
+		{"foo/", true, false, 2},
+		{"foo/ba", true, false, 2},
+		{"foo/bar", true, true, 0},
+		{"zip", true, false, 2},
+		{"zip/", true, false, 2},
+		{"zap", false, false, 0},
+		{"foo/bar/baz", false, false, 0},
+	}
+	for _, test := range cases {
+		exists, isKey, descendants := r.PrefixInfo(test.inp)
+		if got := (exp{test.inp, exists, isKey, descendants}); got != test {
+			t.Fatalf("mis-match: %+v %+v", got, test)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	r := New[interface{}](false)
+	for _, k := range []string{"a", "b", "c"} {
+		r.Set(k, k)
+	}
+
+	var x, y interface{} = "x", "y"
+	up, del := r.Apply(map[string]*interface{}{
+		"a": nil,
+		"b": &x,
+		"d": &y,
+		"z": nil,
+	})
+	if up != 2 || del != 1 {
+		t.Fatalf("bad counts: %v %v", up, del)
+	}
+
+	exp := map[string]interface{}{"b": "x", "c": "c", "d": "y"}
+	if m := r.ToMap(); !reflect.DeepEqual(m, exp) {
+		t.Fatalf("mis-match: %v %v", m, exp)
+	}
+}
+
+func TestRepair(t *testing.T) {
+	r := New[interface{}](true)
+	keys := []string{"", "a", "ab", "abc", "abd", "b", "ba", "bb", "c", "Ä", "äb"}
+	for _, k := range keys {
+		r.Set(k, k)
+	}
+	for i := 0; i < 500; i++ {
+		k := generateUUID()
+		r.Set(k, i)
+		if i%3 == 0 {
+			r.Delete(k)
+		}
+	}
+	if err := r.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if n := r.Repair(); n != 0 {
+		t.Fatalf("expected no fixes on a valid tree, got %d", n)
+	}
+
+	// corrupt the tree
+	e := r.root.Edges
+	e[0], e[1] = e[1], e[0]
+	e[2].Label++
+	dead := e[3].Node
+	dead.Leaf, dead.Edges = nil, nil
+	r.size += 3
+
+	if err := r.Validate(); err == nil {
+		t.Fatal("expected an invalid tree")
+	}
+	if n := r.Repair(); n == 0 {
+		t.Fatal("expected fixes")
+	}
+	if err := r.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range keys {
+		if v, ok := r.Get(k); ok && v != k {
+			t.Fatalf("value mis-match: %v %v", v, k)
+		}
+	}
+	if n := r.Repair(); n != 0 {
+		t.Fatalf("expected no fixes on a repaired tree, got %d", n)
+	}
+}
+
+func TestRepairCollisions(t *testing.T) {
+	now := time.Unix(1000, 0)
+	clock := func() time.Time { return now }
+
+	r := New[interface{}](false, WithValueIndex(), WithTimestamps(clock))
+	for i, k := range []string{"ab", "cd", "x/1", "x/2"} {
+		r.Set(k, i)
+	}
+	now = now.Add(time.Minute)
+
+	// relabeling cd's edge collides with ab's
+	n := r.root.Edges[1].Node
+	n.Prefix, n.Leaf.Key = "ad", "ad"
+	// x/3 is stored under x/1
+	l := r.root.Edges[2].Node.Edges[0].Node.Leaf
+	l.Key = "x/3"
+
+	if err := r.Validate(); err == nil {
+		t.Fatal("expected an invalid tree")
+	}
+	if n := r.Repair(); n == 0 {
+		t.Fatal("expected fixes")
+	}
+	if err := r.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := map[string]interface{}{"ab": 0, "ad": 1, "x/3": 2, "x/2": 3}
+	if !reflect.DeepEqual(r.ToMap(), exp) {
+		t.Fatalf("expected %v, got %v", exp, r.ToMap())
+	}
+	for k, v := range exp {
+		if got, ok := r.KeyForValue(v, nil); !ok || got != k {
+			t.Fatalf("KeyForValue(%v): expected %q, got %q", v, k, got)
+		}
+	}
+	for k, age := range map[string]time.Duration{"ab": time.Minute, "ad": 0, "x/3": 0, "x/2": time.Minute} {
+		if got, ok := r.AgeOf(k); !ok || got != age {
+			t.Fatalf("AgeOf(%q): expected %v, got %v (%v)", k, age, got, ok)
+		}
+	}
+	if _, ok := r.AgeOf("cd"); ok {
+		t.Fatal("expected cd's timestamp to be removed")
+	}
+	if n := r.Repair(); n != 0 {
+		t.Fatalf("expected no fixes on a repaired tree, got %d", n)
+	}
+}
+
+func TestWalkHelpers(t *testing.T) {
+	r := New[interface{}](false)
+	for _, k := range []string{"a", "b", "c", "d"} {
+		r.Set(k, k)
+	}
+
+	var keys []string
+	if !r.Walk(Limit(2, CollectKeys[interface{}](&keys))) {
+		t.Fatal("expected the walk to abort")
+	}
+	if !reflect.DeepEqual(keys, []string{"a", "b"}) {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+
+	keys = keys[:0]
+	if r.Walk(Limit(10, CollectKeys[interface{}](&keys))) || len(keys) != 4 {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+
+	m := map[string]interface{}{}
+	r.WalkPrefix("c", CollectInto(m))
+	if !reflect.DeepEqual(m, map[string]interface{}{"c": "c"}) {
+		t.Fatalf("unexpected map: %v", m)
+	}
+}
+
+func TestKeyLenRange(t *testing.T) {
+	r := New[interface{}](false)
+	check := func(min, max int) {
+		t.Helper()
+		if a, b := r.KeyLenRange(); a != min || b != max {
+			t.Fatalf("expected (%d, %d), got (%d, %d)", min, max, a, b)
+		}
+	}
+
+	check(0, 0)
+	for _, k := range []string{"abc", "a", "abcdef", "xyz", "x", "abcdefgh"} {
+		r.Set(k, nil)
+	}
+	r.Set("abc", 1)
+	check(1, 8)
+
+	r.Delete("a")
+	check(1, 8)
+	r.Delete("x")
+	check(3, 8)
+	r.Delete("abcdefgh")
+	check(3, 6)
+	r.DeletePrefix("abc")
+	check(3, 3)
+	r.Delete("xyz")
+	check(0, 0)
+}
+
+func TestWalkDelta(t *testing.T) {
+	for _, fold := range []bool{false, true} {
+		base, r := New[interface{}](fold), New[interface{}](fold)
+		base.MergeMap(map[string]interface{}{"a": 1, "ab": 2, "abc": 3, "b": 4, "c/d": 5})
+		r.MergeMap(map[string]interface{}{"a": 1, "ab": 20, "abd": 3, "b": 4, "c": 6, "c/d/e": 7})
+
+		var (
+			ops   []string
+			delta = New[interface{}](fold)
+		)
+		r.WalkDelta(base, func(op byte, k string, v interface{}) bool {
+			ops = append(ops, fmt.Sprintf("%c%s=%v", op, k, v))
+			if op == '-' {
+				v = nil
+			}
+			delta.Set(k, v)
+			return false
+		})
+
+		exp := []string{"~ab=20", "-abc=3", "+abd=3", "+c=6", "-c/d=5", "+c/d/e=7"}
+		if !reflect.DeepEqual(ops, exp) {
+			t.Fatalf("fold=%v: mis-match: %v %v", fold, ops, exp)
+		}
+
+		// applying the delta to base should result in r
+		delta.Walk(func(k string, v interface{}) bool {
+			if v == nil {
+				base.Delete(k)
+			} else {
+				base.Set(k, v)
+			}
+			return false
+		})
+		if !reflect.DeepEqual(base.ToMap(), r.ToMap()) {
+			t.Fatalf("fold=%v: mis-match: %v %v", fold, base.ToMap(), r.ToMap())
+		}
+	}
+}
+
+func TestScope(t *testing.T) {
+	r := New[interface{}](false)
+	r.Set("svc/a/x", 1)
+	r.Set("svc/b/y", 2)
+
+	st := r.Scope("svc/").Scope("a/")
+	if st.Prefix() != "svc/a/" {
+		t.Fatalf("bad prefix: %q", st.Prefix())
+	}
+	if v, ok := st.Get("x"); !ok || v != 1 {
+		t.Fatalf("bad value: %v %v", v, ok)
+	}
+
+	st.Set("z", 3)
+	if v, ok := r.Get("svc/a/z"); !ok || v != 3 {
+		t.Fatalf("bad value: %v %v", v, ok)
+	}
+
+	r.Set("svc/a/w", 4)
+	var keys []string
+	st.Walk(CollectKeys[interface{}](&keys))
+	if !reflect.DeepEqual(keys, []string{"w", "x", "z"}) {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+
+	if _, ok := st.Delete("x"); !ok {
+		t.Fatal("expected x to be deleted")
+	}
+	if _, ok := r.Get("svc/a/x"); ok || r.Len() != 3 {
+		t.Fatal("expected svc/a/x to be deleted")
+	}
+}
+
+func TestValueSlab(t *testing.T) {
+	r := New[interface{}](false, WithValueSlab(4))
+	for i := 0; i < 10; i++ {
+		r.Set(fmt.Sprint(i), i)
+	}
+	if len(r.slab) != 2 {
+		t.Fatalf("expected the last slab to hold 2 leaves, got %d", len(r.slab))
+	}
+
+	for i := 0; i < 10; i += 2 {
+		if v, ok := r.Delete(fmt.Sprint(i)); !ok || v != i {
+			t.Fatalf("bad value: %v %v", v, ok)
+		}
+	}
+	if len(r.freeLeaves) != 5 {
+		t.Fatalf("expected 5 free leaves, got %d", len(r.freeLeaves))
+	}
+
+	for i := 10; i < 15; i++ {
+		r.Set(fmt.Sprint(i), i)
+	}
+	if len(r.freeLeaves) != 0 || len(r.slab) != 2 {
+		t.Fatalf("expected the free leaves to be reused: %d %d", len(r.freeLeaves), len(r.slab))
+	}
+
+	for i := 1; i < 15; i++ {
+		if v, ok := r.Get(fmt.Sprint(i)); (i < 10 && i%2 == 0) == ok || ok && v != i {
+			t.Fatalf("bad value for %d: %v %v", i, v, ok)
+		}
+	}
+}
+
+func TestLeafKeys(t *testing.T) {
+	r := New[interface{}](false)
+	for _, k := range []string{"a", "ab", "abc", "b/x", "b/y", "b"} {
+		r.Set(k, nil)
+	}
+
+	var keys []string
+	r.LeafKeys(CollectKeys[interface{}](&keys))
+	if !reflect.DeepEqual(keys, []string{"abc", "b/x", "b/y"}) {
+		t.Fatalf("unexpected keys: %v", keys)
+	}
+}
+
+func TestRenameKeys(t *testing.T) {
+	r := New[interface{}](false)
+	r.MergeMap(map[string]interface{}{"v1/a": 1, "v1/b": 2, "v2/a": 3, "tmp/x": 4})
+
+	n := r.RenameKeys(func(k string) (string, bool) {
+		if strings.HasPrefix(k, "v1/") {
+			return "v3/" + k[3:], true
+		}
+		return k, !strings.HasPrefix(k, "tmp/")
+	})
+	exp := map[string]interface{}{"v3/a": 1, "v3/b": 2, "v2/a": 3}
+	if m := r.ToMap(); n != 2 || !reflect.DeepEqual(m, exp) {
+		t.Fatalf("mis-match (%d): %v %v", n, m, exp)
+	}
+
+	// normalize separators, v3\a collides with v3/a and wins since it's walked last
+	r.Set(`v3\a`, 5)
+	n = r.RenameKeys(func(k string) (string, bool) {
+		return strings.ReplaceAll(k, `\`, "/"), true
+	})
+	exp = map[string]interface{}{"v3/a": 5, "v3/b": 2, "v2/a": 3}
+	if m := r.ToMap(); n != 1 || r.Len() != 3 || !reflect.DeepEqual(m, exp) {
+		t.Fatalf("mis-match (%d): %v %v", n, m, exp)
+	}
+}
+
+func TestPartitions(t *testing.T) {
+	uuids, skewed := New[interface{}](false), New[interface{}](false)
+	for i := 0; i < 1000; i++ {
+		uuids.Set(generateUUID(), i)
+		if i%10 == 0 {
+			skewed.Set(generateUUID(), i)
+		} else {
+			skewed.Set(fmt.Sprintf("/a/%d", i), i)
+		}
+	}
+
+	for _, r := range []*Tree[interface{}]{uuids, skewed} {
+		keys := r.Keys()
+		for _, n := range []int{0, 1, 2, 3, 7, 10, 100, 1000, 2000} {
+			splits := r.Partitions(n)
+			exp := n - 1
+			if n > len(keys) {
+				exp = len(keys) - 1
+			} else if n <= 1 {
+				exp = 0
+			}
+			if len(splits) != exp {
+				t.Fatalf("n=%d: expected %d splits, got %d", n, exp, len(splits))
+			}
+
+			counts := make([]int, len(splits)+1)
+			for _, k := range keys {
+				counts[sort.Search(len(splits), func(i int) bool { return splits[i] > k })]++
+			}
+
+			// trees with less than 16n keys are split exactly, the sizes of larger ones are estimated
+			lo, hi := len(keys)/len(counts), len(keys)/len(counts)+1
+			if len(keys) >= 16*n {
+				lo, hi = lo/2, hi*3/2
+			}
+			for i, c := range counts {
+				if c < lo || c > hi {
+					t.Fatalf("n=%d: partition %d has %d keys: %v", n, i, c, counts)
+				}
+			}
+		}
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	r := New[interface{}](false)
+	r.Set("a", 1)
+
+	for _, v := range []ReadOnlyView[interface{}]{r.ReadOnly(), r.Safe().ReadOnly()} {
+		typ := reflect.TypeOf(v)
+		for _, m := range []string{"Set", "Delete", "DeletePrefix", "Merge", "MergeMap", "Update"} {
+			if _, ok := typ.MethodByName(m); ok {
+				t.Fatalf("read-only view has %s", m)
+			}
+		}
+
+		if val, ok := v.Get("a"); !ok || val != 1 || v.Len() != 1 {
+			t.Fatalf("bad value: %v %v", val, ok)
+		}
+	}
+
+	// changes to the tree are visible through the view
+	v := r.ReadOnly()
+	r.Set("b", 2)
+	if k, _, _ := v.Maximum(); k != "b" {
+		t.Fatalf("expected b, got %q", k)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	if m := New[interface{}](false).Metrics(); m != (TreeMetrics{}) {
+		t.Fatalf("expected zero metrics: %+v", m)
+	}
+
+	lt := NewSafe[interface{}](false, WithMetrics())
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			k := fmt.Sprint(i)
+			lt.Set(k, i)
+			lt.Set(k, i+1)
+			lt.Get(k)
+			lt.Get(k + "x")
+			lt.Get(k + "y")
+			lt.Delete(k)
+		}(i)
+	}
+	wg.Wait()
+
+	exp := TreeMetrics{Sets: 8, Gets: 12, Deletes: 4, Hits: 4, Misses: 8}
+	if m := lt.Metrics(); m != exp {
+		t.Fatalf("mis-match: %+v %+v", m, exp)
+	}
+
+	r := New[interface{}](false, WithMetrics())
+	r.GetOrSet("a", 1)
+	r.GetOrSet("a", 2)
+	r.SetIfAbsent("b", 3)
+	if exp, m := (TreeMetrics{Sets: 2, Gets: 3, Hits: 1, Misses: 2}), r.Metrics(); m != exp {
+		t.Fatalf("GetOrSet: expected %+v, got %+v", exp, m)
+	}
+}
+
+func TestWalkBuf(t *testing.T) {
+	r := New[interface{}](false)
+	for i := 0; i < 1000; i++ {
+		r.Set(generateUUID(), i)
+	}
+	r.Set("", -1)
+
+	var keys, exp []string
+	r.WalkBuf(func(k []byte, v interface{}) bool {
+		keys = append(keys, string(k))
+		return false
+	})
+	r.Walk(CollectKeys[interface{}](&exp))
+	if !reflect.DeepEqual(keys, exp) {
+		t.Fatal("mis-match")
+	}
+}
+
+func TestOverlapStats(t *testing.T) {
+	build := func(keys ...string) *Tree[interface{}] {
+		r := New[interface{}](false)
+		for _, k := range keys {
+			r.Set(k, nil)
+		}
+		return r
+	}
+
+	cases := []struct {
+		a, b               *Tree[interface{}]
+		onlyA, onlyB, both int
+	}{
+		{build("a", "b"), build("c", "d", "e"), 2, 3, 0},
+		{build("a", "ab", "b", "c"), build("ab", "b", "ba"), 2, 1, 2},
+		{build("a", "b", "c"), build("a", "b", "c"), 0, 0, 3},
+		{build(), build("a"), 0, 1, 0},
+	}
+	for i, c := range cases {
+		a, b, both := c.a.OverlapStats(c.b)
+		if a != c.onlyA || b != c.onlyB || both != c.both {
+			t.Fatalf("%d: expected (%d, %d, %d), got (%d, %d, %d)", i, c.onlyA, c.onlyB, c.both, a, b, both)
+		}
+	}
+}
+
+func TestStrictFold(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		var opts []Option
+		if strict {
+			opts = append(opts, WithStrictFold())
+		}
+		r := New[interface{}](true, opts...)
+		r.Set("Foo", 1)
+
+		if old, found, collided := r.SetFold("Foo", 2); old != 1 || !found || collided {
+			t.Fatalf("strict=%v: unexpected result: %v %v %v", strict, old, found, collided)
+		}
+		if old, found, collided := r.SetFold("bar", 3); old != nil || found || collided {
+			t.Fatalf("strict=%v: unexpected result: %v %v %v", strict, old, found, collided)
+		}
+
+		old, found, collided := r.SetFold("foo", 4)
+		if old != 2 || !found || !collided {
+			t.Fatalf("strict=%v: unexpected result: %v %v %v", strict, old, found, collided)
+		}
+
+		exp := 4
+		if strict {
+			exp = 2
+		}
+		if v, _ := r.Get("FOO"); v != exp {
+			t.Fatalf("strict=%v: expected %v, got %v", strict, exp, v)
+		}
+		if r.Len() != 2 {
+			t.Fatalf("strict=%v: bad len: %v", strict, r.Len())
+		}
+	}
+}
+
+func TestPrune(t *testing.T) {
+	r := New[interface{}](false)
+	r.MergeMap(map[string]interface{}{"a": 0, "ab": 1, "abc": 0, "abd": 2, "b": 0, "c": 3})
+
+	if n := r.Prune(func(v interface{}) bool { return v == 0 }); n != 3 {
+		t.Fatalf("expected 3 pruned entries, got %d", n)
+	}
+	exp := map[string]interface{}{"ab": 1, "abd": 2, "c": 3}
+	if m := r.ToMap(); !reflect.DeepEqual(m, exp) {
+		t.Fatalf("mis-match: %v %v", m, exp)
+	}
+	if err := r.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestForEachSafe(t *testing.T) {
+	r := New[interface{}](false)
+	r.MergeMap(map[string]interface{}{"a": 1, "ab": 2, "abc": 3, "abd": 4, "b": 5, "c": 6})
+
+	var seen []string
+	r.ForEachSafe(func(k string, v interface{}) (interface{}, bool, bool) {
+		seen = append(seen, k)
+		switch k {
+		case "ab", "b":
+			return nil, false, false
+		case "abc", "c":
+			return "x:" + k, true, true
+		}
+		if k == "a" {
+			// modifying the tree inside fn is allowed
+			r.Delete("abd")
+		}
+		return nil, true, false
+	})
+
+	if exp := []string{"a", "ab", "abc", "b", "c"}; !reflect.DeepEqual(seen, exp) {
+		t.Fatalf("mis-match: %v %v", seen, exp)
+	}
+	exp := map[string]interface{}{"a": 1, "abc": "x:abc", "c": "x:c"}
+	if m := r.ToMap(); !reflect.DeepEqual(m, exp) {
+		t.Fatalf("mis-match: %v %v", m, exp)
+	}
+	if err := r.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSmallestLargestN(t *testing.T) {
+	r := New[interface{}](false)
+	keys := []string{"", "a", "ab", "abc", "abd", "b", "ba", "c"}
+	for i, k := range keys {
+		r.Set(k, i)
+	}
+
+	for _, n := range []int{0, 1, 3, len(keys), len(keys) + 5} {
+		exp := keys
+		if n < len(keys) {
+			exp = keys[:n]
+		}
+		var got []string
+		for _, e := range r.SmallestN(n) {
+			if v, _ := r.Get(e.Key); e.Value != v {
+				t.Fatalf("bad value for %q: %v", e.Key, e.Value)
+			}
+			got = append(got, e.Key)
+		}
+		if len(got) != len(exp) || (len(exp) > 0 && !reflect.DeepEqual(got, exp)) {
+			t.Fatalf("SmallestN(%d): expected %v, got %v", n, exp, got)
+		}
+
+		got = got[:0]
+		for _, e := range r.LargestN(n) {
+			got = append(got, e.Key)
+		}
+		exp = nil
+		for i := len(keys) - 1; i >= 0 && len(exp) < n; i-- {
+			exp = append(exp, keys[i])
+		}
+		if len(got) != len(exp) || (len(exp) > 0 && !reflect.DeepEqual(got, exp)) {
+			t.Fatalf("LargestN(%d): expected %v, got %v", n, exp, got)
+		}
+	}
+}
+
+func TestRebuild(t *testing.T) {
+	edgeCaps := func(r *Tree[interface{}]) (n int) {
+		walkNodes(&r.root, func(n2 *node[interface{}]) {
+			n += cap(n2.Edges)
+		})
+		return
+	}
+
+	r := New[interface{}](false, WithValueIndex())
+	for i := 0; i < 2000; i++ {
+		r.Set(fmt.Sprintf("key/%d/%d", i%7, i), i)
+	}
+	for i := 0; i < 2000; i++ {
+		if i%3 != 0 {
+			r.Delete(fmt.Sprintf("key/%d/%d", i%7, i))
+		}
+	}
+	if err := r.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	exp := r.ToMap()
+	before, beforeSize := edgeCaps(r), r.ApproxSizeBytes()
+	minLen, maxLen := r.KeyLenRange()
+
+	r.Rebuild()
+	if err := r.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if m := r.ToMap(); !reflect.DeepEqual(m, exp) {
+		t.Fatal("mis-match after Rebuild")
+	}
+	if after := edgeCaps(r); after >= before {
+		t.Fatalf("expected smaller edges after Rebuild, before: %d, after: %d", before, after)
+	}
+	if after := r.ApproxSizeBytes(); after >= beforeSize {
+		t.Fatalf("expected a smaller tree after Rebuild, before: %d bytes, after: %d bytes", beforeSize, after)
+	}
+	if a, b := r.KeyLenRange(); a != minLen || b != maxLen {
+		t.Fatalf("bad key len range: %d %d", a, b)
+	}
+	if k, ok := r.KeyForValue(999, nil); !ok || k != "key/5/999" {
+		t.Fatalf("bad value index: %q %v", k, ok)
+	}
+}
+
+func TestMinimumMaximumPrefix(t *testing.T) {
+	r := New[interface{}](false)
+	keys := []string{"/api", "/api/users", "/api/users/1", "/api/v2/zones", "/apis", "/www/index", "/www/static/z.css"}
+	for i, k := range keys {
+		r.Set(k, i)
+	}
+
+	cases := []struct {
+		prefix   string
+		min, max string
+	}{
+		{"", "/api", "/www/static/z.css"},
+		{"/api/", "/api/users", "/api/v2/zones"},
+		{"/api", "/api", "/apis"},
+		{"/api/u", "/api/users", "/api/users/1"},
+		{"/www", "/www/index", "/www/static/z.css"},
+		{"/www/static/z.css", "/www/static/z.css", "/www/static/z.css"},
+		{"/x", "", ""},
+		{"/api/users/2", "", ""},
+	}
+	for _, c := range cases {
+		k, v, ok := r.MinimumPrefix(c.prefix)
+		if k != c.min || ok != (c.min != "") {
+			t.Fatalf("MinimumPrefix(%q): expected %q, got %q (%v)", c.prefix, c.min, k, ok)
+		}
+		if ev, _ := r.Get(k); ok && v != ev {
+			t.Fatalf("MinimumPrefix(%q): bad value %v", c.prefix, v)
+		}
+		k, v, ok = r.MaximumPrefix(c.prefix)
+		if k != c.max || ok != (c.max != "") {
+			t.Fatalf("MaximumPrefix(%q): expected %q, got %q (%v)", c.prefix, c.max, k, ok)
+		}
+		if ev, _ := r.Get(k); ok && v != ev {
+			t.Fatalf("MaximumPrefix(%q): bad value %v", c.prefix, v)
+		}
+	}
+}
+
+func TestAtomicTree(t *testing.T) {
+	at := NewAtomic[interface{}](false)
+	keys := []string{"", "a", "ab", "abc", "abd", "b", "bcd", "c"}
+	for i, k := range keys {
+		at.Set(k, i)
+	}
+
+	snap := at.Load()
+	exp := snap.ToMap()
+
+	at.Set("abe", "new")
+	at.Set("ab", "updated")
+	at.Delete("abc")
+	at.Delete("b")
+	at.Delete("missing")
+
+	if m := snap.ToMap(); !reflect.DeepEqual(m, exp) {
+		t.Fatalf("snapshot was modified: %v", m)
+	}
+	if err := snap.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	exp2 := map[string]interface{}{"": 0, "a": 1, "ab": "updated", "abd": 4, "abe": "new", "bcd": 6, "c": 7}
+	if m := at.Load().ToMap(); !reflect.DeepEqual(m, exp2) {
+		t.Fatalf("mis-match: %v %v", m, exp2)
+	}
+	if err := at.Load().Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	var zero AtomicTree[interface{}]
+	if _, ok := zero.Get("x"); ok || zero.Len() != 0 {
+		t.Fatal("expected an empty tree")
+	}
+	zero.Set("x", 1)
+	if v, ok := zero.Get("x"); !ok || v != 1 {
+		t.Fatalf("bad value: %v %v", v, ok)
+	}
+}
+
+func TestAtomicTransaction(t *testing.T) {
+	at := NewAtomic[interface{}](false)
+	at.Set("a", 1)
+	at.Set("b", 2)
+
+	before := at.Load()
+	errFail := errors.New("fail")
+	err := at.Transaction(func(t *Tree[interface{}]) error {
+		t.Set("c", 3)
+		t.Delete("a")
+		return errFail
+	})
+	if err != errFail || at.Load() != before {
+		t.Fatalf("expected the failed transaction to be discarded: %v", err)
+	}
+
+	err = at.Transaction(func(tt *Tree[interface{}]) error {
+		tt.Set("c", 3)
+		tt.Delete("a")
+		if v, _ := at.Get("a"); v != 1 || at.Len() != 2 {
+			t.Fatal("readers shouldn't see partial changes")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := map[string]interface{}{"b": 2, "c": 3}; !reflect.DeepEqual(at.Load().ToMap(), exp) {
+		t.Fatalf("expected %v, got %v", exp, at.Load().ToMap())
+	}
+	if !reflect.DeepEqual(before.ToMap(), map[string]interface{}{"a": 1, "b": 2}) {
+		t.Fatal("the previous version was modified")
+	}
+
+	var zero AtomicTree[interface{}]
+	if err := zero.Transaction(func(t *Tree[interface{}]) error { t.Set("x", 1); return nil }); err != nil || zero.Len() != 1 {
+		t.Fatalf("expected the zero value to be usable: %v %d", err, zero.Len())
+	}
+}
+
+func TestSharedNodeCount(t *testing.T) {
+	at := NewAtomic[interface{}](false)
+	for i := 0; i < 1000; i++ {
+		at.Set(fmt.Sprintf("/api/%d/%d", i%10, i), i)
+	}
+
+	a := at.Load()
+	total := -1 // the root is never shared
+	walkNodes(&a.root, func(*node[interface{}]) { total++ })
+	if n := a.SharedNodeCount(a); n != total+1 {
+		t.Fatalf("expected %d shared nodes, got %d", total+1, n)
+	}
+
+	key := "/api/3/503"
+	pathLen := 0
+	for n, search := &a.root, key; len(search) > 0; pathLen++ {
+		n = n.getEdge(nextRune(search), false)
+		search = search[len(n.Prefix):]
+	}
+
+	at.Set(key, "updated")
+	b := at.Load()
+	if n := a.SharedNodeCount(b); n != total-pathLen {
+		t.Fatalf("expected %d shared nodes, got %d (path: %d)", total-pathLen, n, pathLen)
+	}
+	if n := b.SharedNodeCount(a); n != total-pathLen {
+		t.Fatalf("expected %d shared nodes, got %d", total-pathLen, n)
+	}
+}
+
+func TestAtomicTreeConcurrent(t *testing.T) {
+	at := NewAtomic[interface{}](true)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 500; j++ {
+				k := fmt.Sprintf("%d/%d", i, j)
+				at.Set(k, j)
+				if j%2 == 0 {
+					at.Delete(k)
+				}
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 500; j++ {
+				at.WalkPrefix("1/", func(string, interface{}) bool { return false })
+				at.Get(fmt.Sprintf("2/%d", j))
+			}
+		}()
+	}
+	wg.Wait()
+
+	if at.Len() != 4*250 {
+		t.Fatalf("expected %d keys, got %d", 4*250, at.Len())
+	}
+	if err := at.Load().Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSafeCompareAndSwap(t *testing.T) {
+	lt := NewSafe[interface{}](true)
+	eq := func(a, b interface{}) bool { return a == b }
+
+	if lt.CompareAndSwap("counter", nil, 1, eq) {
+		t.Fatal("expected the swap of a missing key to fail")
+	}
+
+	lt.Set("Counter", 0)
+	const workers, incs = 8, 200
+	var (
+		wg       sync.WaitGroup
+		failures int64
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < incs; j++ {
+				for {
+					v, _ := lt.Get("counter")
+					if lt.CompareAndSwap("counter", v, v.(int)+1, eq) {
+						break
+					}
+					atomic.AddInt64(&failures, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if v, _ := lt.Get("counter"); v != workers*incs {
+		t.Fatalf("expected %d, got %v (%d failed swaps)", workers*incs, v, failures)
+	}
+	if keys := lt.Keys(); len(keys) != 1 || keys[0] != "Counter" {
+		t.Fatalf("unexpected keys: %q", keys)
+	}
+	if lt.CompareAndSwap("counter", 0, 1, eq) {
+		t.Fatal("expected the swap with a stale value to fail")
+	}
+}
+
+func TestSafeTransaction(t *testing.T) {
+	lt := NewSafe[interface{}](false)
+	for i := 0; i < 100; i++ {
+		lt.Set(fmt.Sprintf("/%d", i), i)
+	}
+	exp := lt.Snapshot().ToMap()
+
+	errAbort := errors.New("abort")
+	err := lt.Transaction(func(t *Tree[interface{}]) error {
+		t.Set("/new", "new")
+		t.Set("/1", "changed")
+		t.DeletePrefix("/5")
+		return errAbort
+	})
+	if err != errAbort {
+		t.Fatalf("expected errAbort, got %v", err)
+	}
+	if got := lt.Snapshot().ToMap(); !reflect.DeepEqual(got, exp) {
+		t.Fatal("the changes weren't rolled back")
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("expected a panic")
+			}
+		}()
+		lt.Transaction(func(t *Tree[interface{}]) error {
+			t.Delete("/2")
+			panic("oops")
+		})
+	}()
+	if got := lt.Snapshot().ToMap(); !reflect.DeepEqual(got, exp) {
+		t.Fatal("the changes weren't rolled back after a panic")
+	}
+
+	if err = lt.Transaction(func(t *Tree[interface{}]) error {
+		t.Set("/new", "new")
+		t.DeletePrefix("/5")
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := lt.Get("/new"); v != "new" || lt.Len() != 100-11+1 {
+		t.Fatalf("the changes weren't applied: %v %d", v, lt.Len())
+	}
+	if err := lt.Snapshot().Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSafeSnapshot(t *testing.T) {
+	lt := NewSafe[interface{}](false)
+	for i := 0; i < 1000; i++ {
+		lt.Set(fmt.Sprintf("/%d", i), i)
+	}
+
+	snap := lt.Snapshot()
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			lt.Set(fmt.Sprintf("/%d", i), -i)
+			lt.Set(fmt.Sprintf("/new/%d", i), i)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 10; i++ {
+			snap.Walk(func(k string, v interface{}) bool {
+				if sv, _ := snap.Get(k); sv != v {
+					t.Errorf("%s: expected %v, got %v", k, v, sv)
+				}
+				return false
+			})
+		}
+	}()
+	wg.Wait()
+
+	if snap.Len() != 1000 || lt.Len() != 2000 {
+		t.Fatalf("unexpected len: %d %d", snap.Len(), lt.Len())
+	}
+	if v, _ := snap.Get("/5"); v != 5 {
+		t.Fatalf("expected the snapshot to be unchanged, got %v", v)
+	}
+	if err := snap.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSafeWalkAbort(t *testing.T) {
+	lt := NewSafe[interface{}](false)
+	for _, k := range []string{"", "/", "/api", "/api/users"} {
+		lt.Set(k, k)
+	}
+
+	abort := func(string, interface{}) bool { return true }
+	cont := func(string, interface{}) bool { return false }
+	walks := map[string]func(WalkFn[interface{}]) bool{
+		"Walk":            lt.Walk,
+		"WalkPrefix":      func(fn WalkFn[interface{}]) bool { return lt.WalkPrefix("/api", fn) },
+		"WalkPath":        func(fn WalkFn[interface{}]) bool { return lt.WalkPath("/api/users", fn) },
+		"WalkNearestPath": func(fn WalkFn[interface{}]) bool { return lt.WalkNearestPath("/api/users/1", fn) },
+	}
+	for name, walk := range walks {
+		if !walk(abort) {
+			t.Fatalf("%s: expected the abort to be propagated", name)
+		}
+		if walk(cont) {
+			t.Fatalf("%s: unexpected abort", name)
+		}
+	}
+}
+
+func TestWalkPathSkipRoot(t *testing.T) {
+	r := New[interface{}](false)
+	for _, k := range []string{"", "/", "/api", "/api/users", "/www"} {
+		r.Set(k, k)
+	}
+
+	walk := func(fn func(string, WalkFn[interface{}]) bool, path string) (out []string) {
+		fn(path, func(k string, _ interface{}) bool {
+			out = append(out, k)
+			return false
+		})
+		return
+	}
+
+	if got, exp := walk(r.WalkPath, "/api/users/1"), []string{"", "/", "/api", "/api/users"}; !reflect.DeepEqual(got, exp) {
+		t.Fatalf("WalkPath: expected %q, got %q", exp, got)
+	}
+	if got, exp := walk(r.WalkPathSkipRoot, "/api/users/1"), []string{"/", "/api", "/api/users"}; !reflect.DeepEqual(got, exp) {
+		t.Fatalf("WalkPathSkipRoot: expected %q, got %q", exp, got)
+	}
+	if got, exp := walk(r.WalkPath, ""), []string{""}; !reflect.DeepEqual(got, exp) {
+		t.Fatalf("WalkPath: expected %q, got %q", exp, got)
+	}
+	if got := walk(r.WalkPathSkipRoot, ""); len(got) != 0 {
+		t.Fatalf("WalkPathSkipRoot: expected nothing, got %q", got)
+	}
+	if got := walk(r.WalkPathSkipRoot, "x"); len(got) != 0 {
+		t.Fatalf("WalkPathSkipRoot: expected nothing, got %q", got)
+	}
+}
+
+func TestToMapPrefix(t *testing.T) {
+	r := New[interface{}](false)
+	for i := 0; i < 5000; i++ {
+		r.Set(fmt.Sprintf("/ns/%d/%d", i%3, i), i)
+	}
+
+	exp := map[string]interface{}{}
+	r.WalkPrefix("/ns/1/", func(k string, v interface{}) bool {
+		exp[k] = v
+		return false
+	})
+	if m := r.ToMapPrefix("/ns/1/"); !reflect.DeepEqual(m, exp) {
+		t.Fatal("mis-match")
+	}
+	if m := r.ToMapPrefix("/ns/"); len(m) != r.Len() {
+		t.Fatalf("expected %d entries, got %d", r.Len(), len(m))
+	}
+	if m := r.ToMapPrefix("/x"); m == nil || len(m) != 0 {
+		t.Fatalf("expected an empty map, got %v", m)
+	}
+
+	// the map is sized up front, so it should allocate as much as filling a presized map
+	presized := testing.AllocsPerRun(10, func() {
+		m := make(map[string]interface{}, len(exp))
+		for k, v := range exp {
+			m[k] = v
+		}
+	})
+	if allocs := testing.AllocsPerRun(10, func() { r.ToMapPrefix("/ns/1/") }); allocs > presized+4 {
+		t.Fatalf("expected at most %v allocations, got %v", presized+4, allocs)
+	}
+}
+
+func TestMarshalPrefix(t *testing.T) {
+	r := New[interface{}](false)
+	for i := 0; i < 100; i++ {
+		r.Set(fmt.Sprintf("/ns/%d/%d", i%3, i), fmt.Sprintf("v%d", i))
+	}
+	r.Set("/ns/1/", nil)
+
+	var buf bytes.Buffer
+	if err := r.MarshalPrefix(&buf, "/ns/1/"); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.String()
+
+	exp := r.ToMapPrefix("/ns/1/")
+	r2 := New[interface{}](false)
+	if n, err := r2.LoadPrefix(strings.NewReader(data), "", ""); err != nil || n != len(exp) {
+		t.Fatalf("expected %d entries, got %d (%v)", len(exp), n, err)
+	}
+	if m := r2.ToMap(); !reflect.DeepEqual(m, exp) {
+		t.Fatalf("mis-match: %v %v", m, exp)
+	}
+
+	r3 := New[interface{}](false)
+	if _, err := r3.LoadPrefix(strings.NewReader(data), "/ns/1/", "/restored/"); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := r3.Get("/restored/34"); !ok || v != "v34" {
+		t.Fatalf("bad value: %v %v", v, ok)
+	}
+	if r3.Len() != len(exp) || r3.CountPrefix("/restored/") != len(exp) {
+		t.Fatalf("expected %d entries, got %v", len(exp), r3.ToMap())
+	}
+
+	if _, err := r3.LoadPrefix(strings.NewReader(data), "/ns/2/", ""); err == nil {
+		t.Fatal("expected an error")
+	}
+	if _, err := r3.LoadPrefix(strings.NewReader(data[:len(data)-5]), "", ""); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	// case-insensitive trees match from ignoring case
+	fr := New[interface{}](true)
+	fr.Set("/NS/Ü/a", 1)
+	fr.Set("/ns/ü/B", 2)
+	fr.Set("/ns/x", 3)
+	buf.Reset()
+	if err := fr.MarshalPrefix(&buf, "/ns/ü/"); err != nil {
+		t.Fatal(err)
+	}
+	fr2 := New[interface{}](true)
+	if n, err := fr2.LoadPrefix(&buf, "/ns/ü/", "/restored/"); err != nil || n != 2 {
+		t.Fatalf("expected 2 entries, got %d (%v)", n, err)
+	}
+	if exp := map[string]interface{}{"/restored/a": 1.0, "/restored/B": 2.0}; !reflect.DeepEqual(fr2.ToMap(), exp) {
+		t.Fatalf("expected %v, got %v", exp, fr2.ToMap())
+	}
+}
+
+func TestStructuralHealth(t *testing.T) {
+	if h := New[interface{}](false).StructuralHealth(); h != 1 {
+		t.Fatalf("expected 1 for an empty tree, got %v", h)
+	}
+
+	paths, uuids := New[interface{}](false), New[interface{}](false)
+	for i := 0; i < 1000; i++ {
+		paths.Set(fmt.Sprintf("/api/v1/users/%d/%d", i%10, i), i)
+		uuids.Set(generateUUID(), i)
+	}
+
+	hp, hu := paths.StructuralHealth(), uuids.StructuralHealth()
+	if hp < 0.5 || hp > 1 {
+		t.Fatalf("expected a healthy score for hierarchical keys, got %v", hp)
+	}
+	if hu < 0 || hu > 0.2 {
+		t.Fatalf("expected a low score for random keys, got %v", hu)
+	}
+}
+
+func TestDeleteMany(t *testing.T) {
+	for _, fold := range []bool{false, true} {
+		r := New[interface{}](fold)
+		for _, k := range []string{"a", "ab", "abc", "b", "foo", "foobar"} {
+			r.Set(k, k)
+		}
+
+		keys := []string{"ab", "missing", "foo", "ab", "abcd", "b", ""}
+		if fold {
+			keys[2] = "FOO"
+		}
+		if n := r.DeleteMany(keys); n != 3 {
+			t.Fatalf("fold=%v: expected 3 deletions, got %d", fold, n)
+		}
+		if err := r.Validate(); err != nil {
+			t.Fatal(err)
+		}
+
+		exp := map[string]interface{}{"a": "a", "abc": "abc", "foobar": "foobar"}
+		if got := r.ToMap(); !reflect.DeepEqual(got, exp) {
+			t.Fatalf("fold=%v: expected %v, got %v", fold, exp, got)
+		}
+
+		if n := r.DeleteMany(nil); n != 0 {
+			t.Fatalf("expected 0 deletions, got %d", n)
+		}
+	}
+
+	st := NewSafe[interface{}](false)
+	st.Set("a", 1)
+	st.Set("b", 2)
+	if n := st.DeleteMany([]string{"a", "c"}); n != 1 || st.Len() != 1 {
+		t.Fatalf("expected 1 deletion, got %d (len %d)", n, st.Len())
+	}
+}
+
+func TestDeleteInfo(t *testing.T) {
+	cases := []struct {
+		key           string
+		found, merged bool
+	}{
+		{"missing", false, false},
+		{"a", true, false},        // leaf under the root
+		{"foobaz", true, true},    // merged with its only child
+		{"foobar", true, true},    // the parent has a single child left
+		{"foo", true, true},       // merged with its only child
+		{"foobazzz", true, false}, // last key under the root edge
+		{"x", true, true},
+		{"xy", true, false},
+		{"b", true, false},
+	}
+
+	for _, fold := range []bool{false, true} {
+		r := New[interface{}](fold)
+		for _, k := range []string{"a", "b", "foo", "foobar", "foobaz", "foobazzz", "x", "xy"} {
+			r.Set(k, k)
+		}
+
+		for _, c := range cases {
+			key := c.key
+			if fold {
+				key = strings.ToUpper(key)
+			}
+			old, found, merged := r.DeleteInfo(key)
+			if found != c.found || merged != c.merged || (found && old != c.key) {
+				t.Fatalf("fold=%v: DeleteInfo(%q): expected (%v, %v), got (%v, %v, %v)", fold, key, c.found, c.merged, old, found, merged)
+			}
+			if err := r.Validate(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if r.Len() != 0 {
+			t.Fatalf("expected an empty tree: %v", r.ToMap())
+		}
+	}
+}
+
+func TestSetInfo(t *testing.T) {
+	r := New[interface{}](false)
+	cases := []struct {
+		key              string
+		found, prefixKey bool
+	}{
+		{"a/b", false, false},
+		{"a", false, true}, // splits "a/b"
+		{"a", true, false},
+		{"a/c", false, false},
+		{"a/", false, true}, // lands on the existing "a/" node
+		{"", false, true},   // lands on the root
+		{"b", false, false},
+	}
+	for i, c := range cases {
+		_, found, prefixKey := r.SetInfo(c.key, i)
+		if found != c.found || prefixKey != c.prefixKey {
+			t.Fatalf("SetInfo(%q): expected (%v, %v), got (%v, %v)", c.key, c.found, c.prefixKey, found, prefixKey)
+		}
+	}
+	if err := r.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMergeMapSorted(t *testing.T) {
+	for _, fold := range []bool{false, true} {
+		m := map[string]interface{}{"": 0, "/": 1, "/API": 2, "/api/v1": 3}
+		for i := 0; i < 2000; i++ {
+			m[fmt.Sprintf("/api/%d/%d", i%7, i)] = i
+			m[generateUUID()] = i
+		}
+
+		exp := New[interface{}](fold).MergeMap(m)
+		r := New[interface{}](fold)
+		r.Set("/api/1/1", "existing")
+		r.Set("zzz", "existing")
+		r.MergeMapSorted(m)
+		exp.Set("zzz", "existing")
+
+		if err := r.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		if r.Len() != exp.Len() || !reflect.DeepEqual(r.Keys(), exp.Keys()) {
+			t.Fatalf("fold=%v: mis-match: %d %d", fold, r.Len(), exp.Len())
+		}
+		if !fold && !reflect.DeepEqual(r.ToMap(), exp.ToMap()) {
+			t.Fatalf("mis-match")
+		}
+	}
+}
+
+func TestSetSorted(t *testing.T) {
+	for _, fold := range []bool{false, true} {
+		var keys []string
+		for i := 0; i < 2000; i++ {
+			keys = append(keys, fmt.Sprintf("/api/%d/%d", i%13, i), generateUUID())
+		}
+		keys = append(keys, "", "/", "/api", "/API/1", "/api/1", "/aPi/10", "é", "É/x")
+		sort.Slice(keys, func(i, j int) bool { return compareFn(fold)(keys[i], keys[j]) < 0 })
+
+		values := make([]interface{}, len(keys))
+		exp := New[interface{}](fold)
+		for i, k := range keys {
+			values[i] = i
+			exp.Set(k, i)
+		}
+
+		r := New[interface{}](fold)
+		r.Set("/api/5/5", "existing")
+		r.Set("zzz", "existing")
+		if err := r.SetSorted(keys, values); err != nil {
+			t.Fatal(err)
+		}
+		exp.Set("zzz", "existing")
+		if err := r.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(r.ToMap(), exp.ToMap()) {
+			t.Fatalf("fold=%v: mis-match", fold)
+		}
+	}
+
+	r := New[interface{}](false)
+	if err := r.SetSorted([]string{"a", "c", "b", "d"}, []interface{}{1, 2, 3, 4}); err == nil {
+		t.Fatal("expected an error for unsorted keys")
+	}
+	if exp := map[string]interface{}{"a": 1, "c": 2}; !reflect.DeepEqual(r.ToMap(), exp) {
+		t.Fatalf("mis-match: %v %v", r.ToMap(), exp)
+	}
+	if err := r.SetSorted([]string{"a"}, nil); err == nil {
+		t.Fatal("expected an error for mismatched lengths")
+	}
+}
+
+func TestGetOrSet(t *testing.T) {
+	r := New[interface{}](true)
+	if v, loaded := r.GetOrSet("Foo", 1); loaded || v != 1 {
+		t.Fatalf("expected (1, false), got (%v, %v)", v, loaded)
+	}
+	if v, loaded := r.GetOrSet("foo", 2); !loaded || v != 1 {
+		t.Fatalf("expected (1, true), got (%v, %v)", v, loaded)
+	}
+	if v, loaded := r.GetOrSet("foobar", 3); loaded || v != 3 {
+		t.Fatalf("expected (3, false), got (%v, %v)", v, loaded)
+	}
+	if exp := map[string]interface{}{"Foo": 1, "foobar": 3}; !reflect.DeepEqual(r.ToMap(), exp) {
+		t.Fatalf("mis-match: %v %v", r.ToMap(), exp)
+	}
+	if min, max := r.KeyLenRange(); min != 3 || max != 6 {
+		t.Fatalf("bad key len range: %d %d", min, max)
+	}
+
+	st := NewSafe[interface{}](false)
+	var (
+		wg     sync.WaitGroup
+		stored int32
+	)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if v, loaded := st.GetOrSet(fmt.Sprint(j), i); !loaded {
+					if v != i {
+						t.Errorf("expected %v, got %v", i, v)
+					}
+					atomic.AddInt32(&stored, 1)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if stored != 100 || st.Len() != 100 {
+		t.Fatalf("expected 100 stored keys, got %d (%d)", stored, st.Len())
+	}
+}
+
+func TestNodeArena(t *testing.T) {
+	r, exp := New[interface{}](true, WithNodeArena(16)), New[interface{}](true)
+	for i := 0; i < 1000; i++ {
+		k := fmt.Sprintf("/api/%d/%d", i%7, i)
+		r.Set(k, i)
+		exp.Set(k, i)
+	}
+	for i := 0; i < 1000; i += 3 {
+		k := fmt.Sprintf("/api/%d/%d", i%7, i)
+		r.Delete(k)
+		exp.Delete(k)
+	}
+	if err := r.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(r.ToMap(), exp.ToMap()) {
+		t.Fatal("mis-match")
+	}
+	if len(r.nodes) == 0 || cap(r.nodes) != 16 {
+		t.Fatalf("expected nodes to be allocated from the arena: %d/%d", len(r.nodes), cap(r.nodes))
+	}
+}
+
+func TestNodePool(t *testing.T) {
+	r, exp := New[interface{}](true, WithNodePool()), New[interface{}](true)
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 1000; i++ {
+			k := fmt.Sprintf("/api/%d/%d", i%7, i)
+			r.Set(k, round*i)
+			exp.Set(k, round*i)
+		}
+		for i := round; i < 1000; i += 3 {
+			k := fmt.Sprintf("/api/%d/%d", i%7, i)
+			r.Delete(k)
+			exp.Delete(k)
+		}
+		pre := fmt.Sprintf("/api/%d/", round)
+		if n, en := r.DeletePrefix(pre), exp.DeletePrefix(pre); n != en {
+			t.Fatalf("DeletePrefix(%q): expected %d, got %d", pre, en, n)
+		}
+		if err := r.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(r.ToMap(), exp.ToMap()) {
+			t.Fatalf("mis-match in round %d", round)
+		}
+	}
+
+	r.Clear()
+	r.Set("x", 1)
+	r.Set("xy", 2)
+	n := r.root.Edges[0].Node
+	c, l := n.Edges[0].Node, n.Leaf
+	r.freeSubtree(n)
+	if n.Leaf != nil || n.Prefix != "" || n.Edges != nil || c.Leaf != nil || l.Key != "" || l.Value != nil {
+		t.Fatalf("expected recycled nodes to be zeroed: %+v %+v %+v", n, c, l)
+	}
+}
+
+func TestSetIfAbsent(t *testing.T) {
+	r := NewSafe[interface{}](false)
+	for i, k := range []string{"a", "b", "a", "ab", "b"} {
+		actual, set := r.SetIfAbsent(k, i)
+		if exp := i < 2 || k == "ab"; set != exp {
+			t.Fatalf("SetIfAbsent(%q): expected %v, got %v", k, exp, set)
+		}
+		if v, _ := r.Get(k); actual != v {
+			t.Fatalf("SetIfAbsent(%q): expected %v, got %v", k, v, actual)
+		}
+	}
+	if exp := map[string]interface{}{"a": 0, "b": 1, "ab": 3}; !reflect.DeepEqual(r.ToMap(), exp) {
+		t.Fatalf("mis-match: %v %v", r.ToMap(), exp)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	r := New[interface{}](false, WithValueIndex())
+	incr := func(old interface{}, found bool) (interface{}, bool) {
+		if !found {
+			return 1, true
+		}
+		return old.(int) + 1, true
+	}
+	for i := 0; i < 3; i++ {
+		r.Update("a", incr)
+	}
+	r.Update("b", incr)
+	if exp := map[string]interface{}{"a": 3, "b": 1}; !reflect.DeepEqual(r.ToMap(), exp) {
+		t.Fatalf("mis-match: %v %v", r.ToMap(), exp)
+	}
+	if k, ok := r.KeyForValue(3, nil); !ok || k != "a" {
+		t.Fatalf("bad value index: %q %v", k, ok)
+	}
+	if _, ok := r.KeyForValue(2, nil); ok {
+		t.Fatal("stale value index")
+	}
+
+	del := func(old interface{}, found bool) (interface{}, bool) {
+		return nil, false
+	}
+	r.Update("a", del)
+	r.Update("missing", del)
+	if exp := map[string]interface{}{"b": 1}; !reflect.DeepEqual(r.ToMap(), exp) {
+		t.Fatalf("mis-match: %v %v", r.ToMap(), exp)
+	}
+
+	st := NewSafe[interface{}](false)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				st.Update(func(t *Tree[interface{}]) { t.Update("n", incr) })
+				st.UpdateKey("k", incr)
+			}
+		}()
+	}
+	wg.Wait()
+	for _, k := range []string{"n", "k"} {
+		if v, _ := st.Get(k); v != 800 {
+			t.Fatalf("%s: expected 800, got %v", k, v)
+		}
+	}
+
+	// inserting and deleting under existing keys, which splits and merges nodes
+	r = New[interface{}](true, WithMetrics())
+	for _, k := range []string{"foo", "foobar", "foobaz", "fox"} {
+		r.Update(k, incr)
+	}
+	r.Update("FOOBA", incr)
+	r.Update("fo", incr)
+	r.Update("foobar", del)
+	r.Update("FOX", del)
+	r.Update("fo", incr)
+	if err := r.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	exp := map[string]interface{}{"fo": 2, "foo": 1, "FOOBA": 1, "foobaz": 1}
+	if !reflect.DeepEqual(r.ToMap(), exp) {
+		t.Fatalf("mis-match: %v %v", r.ToMap(), exp)
+	}
+	if m := r.Metrics(); m.Sets != 7 || m.Deletes != 2 {
+		t.Fatalf("unexpected metrics: %+v", m)
+	}
+}
+
+func TestLeafDepthHistogram(t *testing.T) {
+	r := New[interface{}](false)
+	if h := r.LeafDepthHistogram(); len(h) != 0 {
+		t.Fatalf("expected an empty histogram, got %v", h)
+	}
+
+	// "" is the root, "a" and "b" are at depth 1, "ab" and "ac" at depth 2 and "abc" at depth 3.
+	for _, k := range []string{"", "a", "ab", "abc", "ac", "b"} {
+		r.Set(k, nil)
+	}
+	if h, exp := r.LeafDepthHistogram(), map[int]int{0: 1, 1: 2, 2: 2, 3: 1}; !reflect.DeepEqual(h, exp) {
+		t.Fatalf("expected %v, got %v", exp, h)
+	}
+}
+
+func TestStats(t *testing.T) {
+	r := New[interface{}](false)
+	if st, exp := r.Stats(), (TreeStats{Nodes: 1}); st != exp || r.Height() != 0 {
+		t.Fatalf("expected %+v, got %+v", exp, st)
+	}
+
+	for _, k := range []string{"", "a", "ab", "abc", "ac", "b"} {
+		r.Set(k, nil)
+	}
+	exp := TreeStats{Nodes: 6, Leaves: 6, AvgFanout: 5.0 / 3, MaxFanout: 2, Height: 3}
+	if st := r.Stats(); st != exp || r.Height() != 3 {
+		t.Fatalf("expected %+v, got %+v", exp, st)
+	}
+
+	// every key is a prefix of the next one, the tree is a list
+	r = New[interface{}](false)
+	for i := 1; i <= 10; i++ {
+		r.Set(strings.Repeat("a", i), i)
+	}
+	exp = TreeStats{Nodes: 11, Leaves: 10, AvgFanout: 1, MaxFanout: 1, Height: 10}
+	if st := r.Stats(); st != exp {
+		t.Fatalf("expected %+v, got %+v", exp, st)
+	}
+}
+
+func TestKeysValues(t *testing.T) {
+	r := NewSafe[interface{}](false)
+	if len(r.Keys()) != 0 || len(r.Values()) != 0 {
+		t.Fatal("expected an empty tree")
+	}
+
+	keys := []string{"", "a", "ab", "abc", "b", "ba"}
+	vals := []interface{}{}
+	for i := len(keys) - 1; i >= 0; i-- {
+		r.Set(keys[i], i)
+	}
+	for i := range keys {
+		vals = append(vals, i)
+	}
+	if got := r.Keys(); !reflect.DeepEqual(got, keys) || cap(got) != len(keys) {
+		t.Fatalf("expected %q, got %q", keys, got)
+	}
+	if got := r.Values(); !reflect.DeepEqual(got, vals) || cap(got) != len(vals) {
+		t.Fatalf("expected %v, got %v", vals, got)
+	}
+}
+
+func TestWalkCopy(t *testing.T) {
+	r := NewSafe[interface{}](false)
+	for i := 0; i < 10; i++ {
+		r.Set(fmt.Sprint(i), i)
+	}
+
+	var keys []string
+	aborted := r.WalkCopy(func(k string, v interface{}) bool {
+		keys = append(keys, k)
+		// would deadlock if the lock was still held
+		r.Set("x"+k, v)
+		r.Delete(k)
+		return k == "8"
+	})
+	if !aborted || len(keys) != 9 {
+		t.Fatalf("expected an aborted walk over 9 keys, got %v %v", aborted, keys)
+	}
+	if r.Len() != 10 {
+		t.Fatalf("expected 10 keys, got %v", r.ToMap())
+	}
+	if v, ok := r.Get("x5"); !ok || v != 5 {
+		t.Fatalf("bad value: %v %v", v, ok)
+	}
+}
+
+func TestClear(t *testing.T) {
+	r := NewSafe[interface{}](true, WithValueIndex())
+	for i := 0; i < 100; i++ {
+		r.Set(fmt.Sprintf("Key/%d", i), i)
+	}
+	r.Clear()
+	if r.Len() != 0 || len(r.Keys()) != 0 {
+		t.Fatalf("expected an empty tree, got %v", r.ToMap())
+	}
+	if _, ok := r.KeyForValue(1, nil); ok {
+		t.Fatal("stale value index")
+	}
+	if min, max := r.KeyLenRange(); min != 0 || max != 0 {
+		t.Fatalf("bad key len range: %d %d", min, max)
+	}
+
+	r.Set("Foo", 1)
+	if v, ok := r.Get("fOO"); !ok || v != 1 {
+		t.Fatalf("bad value: %v %v", v, ok)
+	}
+	if k, ok := r.KeyForValue(1, nil); !ok || k != "Foo" {
+		t.Fatalf("bad value index: %q %v", k, ok)
+	}
+	if r.Len() != 1 {
+		t.Fatalf("bad len: %d", r.Len())
+	}
+}
+
+func TestAnyPrefixOf(t *testing.T) {
+	r := New[interface{}](true)
+	if r.AnyPrefixOf("") || r.AnyPrefixOf("x") {
+		t.Fatal("expected no match in an empty tree")
+	}
+
+	for _, k := range []string{"/admin/", "/internal", "/internal/debug", "/api/v1/private/"} {
+		r.Set(k, nil)
+	}
+	cases := map[string]bool{
+		"":                      false,
+		"/":                     false,
+		"/admin":                false,
+		"/admin/":               true,
+		"/ADMIN/users":          true,
+		"/internalx":            true,
+		"/internal/debug/pprof": true,
+		"/api/v1/":              false,
+		"/api/v1/private/keys":  true,
+		"/api/v1/public/keys":   false,
+		"/www":                  false,
+	}
+	for s, exp := range cases {
+		if got := r.AnyPrefixOf(s); got != exp {
+			t.Fatalf("AnyPrefixOf(%q): expected %v", s, exp)
+		}
+	}
+
+	r.Set("", nil)
+	for s := range cases {
+		if !r.AnyPrefixOf(s) {
+			t.Fatalf("AnyPrefixOf(%q): expected the empty key to match", s)
+		}
+	}
+}
+
+func TestMarshalJSONFunc(t *testing.T) {
+	const layout = "2006-01-02"
+	r := New[interface{}](false)
+	r.Set("b", time.Date(2020, 2, 3, 10, 0, 0, 0, time.UTC))
+	r.Set("a", time.Date(2019, 12, 31, 0, 0, 0, 0, time.UTC))
+	r.Set("a\"x", nil)
+
+	data, err := r.MarshalJSONFunc(func(v interface{}) (json.RawMessage, error) {
+		if v == nil {
+			return nil, nil
+		}
+		return json.Marshal(v.(time.Time).Format(layout))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := `{"a":"2019-12-31","a\"x":null,"b":"2020-02-03"}`; string(data) != exp {
+		t.Fatalf("expected %s, got %s", exp, data)
+	}
+
+	r2 := New[interface{}](false)
+	err = r2.UnmarshalJSONFunc(data, func(raw json.RawMessage) (interface{}, error) {
+		var s *string
+		if err := json.Unmarshal(raw, &s); err != nil || s == nil {
+			return nil, err
+		}
+		return time.Parse(layout, *s)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(r2.ToMap(), map[string]interface{}{
+		"a":    time.Date(2019, 12, 31, 0, 0, 0, 0, time.UTC),
+		"a\"x": nil,
+		"b":    time.Date(2020, 2, 3, 0, 0, 0, 0, time.UTC),
+	}) {
+		t.Fatalf("mis-match: %v", r2.ToMap())
+	}
+
+	if data, err = New[interface{}](false).MarshalJSONFunc(nil); err != nil || string(data) != "{}" {
+		t.Fatalf("expected {}, got %s (%v)", data, err)
+	}
+	errBad := errors.New("bad value")
+	if _, err = r.MarshalJSONFunc(func(interface{}) (json.RawMessage, error) { return nil, errBad }); err != errBad {
+		t.Fatalf("expected %v, got %v", errBad, err)
+	}
+	if err = r2.UnmarshalJSONFunc([]byte(`{"x":1}`), func(json.RawMessage) (interface{}, error) { return nil, errBad }); !errors.Is(err, errBad) {
+		t.Fatalf("expected %v, got %v", errBad, err)
+	}
+	if err = r2.UnmarshalJSONFunc([]byte(`["x"]`), nil); err == nil {
+		t.Fatal("expected an error for an array")
+	}
+
+	// case-variants are set in order, so the last one wins
+	decodeInt := func(raw json.RawMessage) (v interface{}, err error) {
+		var i int
+		err = json.Unmarshal(raw, &i)
+		return i, err
+	}
+	for i := 0; i < 20; i++ {
+		r3 := New[interface{}](true)
+		if err = r3.UnmarshalJSONFunc([]byte(`{"foo":1,"FOO":2,"bar":3,"Foo":4}`), decodeInt); err != nil {
+			t.Fatal(err)
+		}
+		if exp := map[string]interface{}{"Foo": 4, "bar": 3}; !reflect.DeepEqual(r3.ToMap(), exp) {
+			t.Fatalf("expected %v, got %v", exp, r3.ToMap())
+		}
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	current := New[interface{}](false).MergeMap(map[string]interface{}{"a": 1, "b": 2, "c": 3, "d": 4})
+	desired := New[interface{}](false).MergeMap(map[string]interface{}{"a": 1, "b": 20, "d": 40, "e": 5, "f": 6})
+
+	var ops []string
+	op := func(name string) func(string, interface{}) {
+		return func(k string, v interface{}) {
+			ops = append(ops, fmt.Sprintf("%s %s=%v", name, k, v))
+		}
+	}
+	adds, removes, changes := current.Reconcile(desired, op("add"), op("remove"), op("change"))
+	if adds != 2 || removes != 1 || changes != 2 {
+		t.Fatalf("expected (2, 1, 2), got (%d, %d, %d)", adds, removes, changes)
+	}
+	exp := []string{"change b=20", "remove c=3", "change d=40", "add e=5", "add f=6"}
+	if !reflect.DeepEqual(ops, exp) {
+		t.Fatalf("expected %q, got %q", exp, ops)
+	}
+
+	if adds, removes, changes = current.Reconcile(current, nil, nil, nil); adds+removes+changes != 0 {
+		t.Fatalf("expected no changes, got (%d, %d, %d)", adds, removes, changes)
+	}
+	if adds, removes, changes = current.Reconcile(New[interface{}](false), nil, nil, nil); removes != 4 || adds+changes != 0 {
+		t.Fatalf("expected 4 removals, got (%d, %d, %d)", adds, removes, changes)
+	}
+}
+
+func TestPredecessorSuccessor(t *testing.T) {
+	r := New[interface{}](false)
+	if _, _, ok := r.Predecessor("a"); ok {
+		t.Fatal("expected no predecessor")
+	}
+	if _, _, ok := r.Successor(""); ok {
+		t.Fatal("expected no successor")
+	}
+
+	keys := []string{"a", "ab", "abc", "abd", "b", "ba", "bcd"}
+	for _, k := range keys {
+		r.Set(k, k)
+	}
+	if _, _, ok := r.Predecessor("a"); ok {
+		t.Fatal("expected no predecessor for the minimum")
+	}
+	if _, _, ok := r.Successor("bcd"); ok {
+		t.Fatal("expected no successor for the maximum")
+	}
+	if k, v, ok := r.Successor("abc"); !ok || k != "abd" || v != "abd" {
+		t.Fatalf("Successor(abc): got (%q, %v, %v)", k, v, ok)
+	}
+	if k, _, ok := r.Predecessor("b"); !ok || k != "abd" {
+		t.Fatalf("Predecessor(b): got (%q, %v)", k, ok)
+	}
+
+	// compare against a linear scan over all the strings of up to 3 chars
+	const chars = "abAB/é"
+	var all []string
+	var gen func(prefix string)
+	gen = func(prefix string) {
+		all = append(all, prefix)
+		if len([]rune(prefix)) == 3 {
+			return
+		}
+		for _, c := range chars {
+			gen(prefix + string(c))
+		}
+	}
+	gen("")
+
+	for _, fold := range []bool{false, true} {
+		r := New[interface{}](fold)
+		for i, k := range all {
+			if i%3 == 0 || i%5 == 0 {
+				r.Set(k, k)
+			}
+		}
+
+		cmp := compareFn(fold)
+		sorted := r.Keys()
+		for _, q := range all {
+			var pred, succ string
+			var hasPred, hasSucc bool
+			for _, k := range sorted {
+				if cmp(k, q) < 0 {
+					pred, hasPred = k, true
+				} else if cmp(k, q) > 0 && !hasSucc {
+					succ, hasSucc = k, true
+				}
+			}
+
+			if k, _, ok := r.Predecessor(q); k != pred || ok != hasPred {
+				t.Fatalf("fold=%v: Predecessor(%q): expected (%q, %v), got (%q, %v)", fold, q, pred, hasPred, k, ok)
+			}
+			if k, _, ok := r.Successor(q); k != succ || ok != hasSucc {
+				t.Fatalf("fold=%v: Successor(%q): expected (%q, %v), got (%q, %v)", fold, q, succ, hasSucc, k, ok)
+			}
+		}
+	}
+}
+
+func TestCursor(t *testing.T) {
+	for _, fold := range []bool{false, true} {
+		r := New[interface{}](fold)
+		for i := 0; i < 100; i++ {
+			r.Set(fmt.Sprintf("ns/%02d", i), i)
+			r.Set(fmt.Sprintf("ns/%02d/x", i), i)
+		}
+		r.Set("", -1)
+
+		var all []string
+		r.Walk(func(k string, _ interface{}) bool {
+			all = append(all, k)
+			return false
+		})
+
+		page := func(c *Cursor[interface{}], limit int) (keys []string) {
+			for ; limit > 0; limit-- {
+				k, v, ok := c.Next()
+				if !ok {
+					break
+				}
+				if exp, _ := r.Get(k); v != exp {
+					t.Fatalf("%q: expected %v, got %v", k, exp, v)
+				}
+				keys = append(keys, k)
+			}
+			return
+		}
+
+		c := r.Cursor()
+		keys := page(c, len(all)/2)
+		keys = append(keys, page(c, len(all))...)
+		if !reflect.DeepEqual(keys, all) {
+			t.Fatalf("fold=%v: expected %v, got %v", fold, all, keys)
+		}
+		if k, _, ok := c.Next(); ok {
+			t.Fatalf("unexpected key after the end: %q", k)
+		}
+
+		keys = page(r.Cursor(), 50)
+		keys = append(keys, page(r.CursorFrom(keys[len(keys)-1]+"\x00"), len(all))...)
+		if !reflect.DeepEqual(keys, all) {
+			t.Fatalf("fold=%v: expected %v, got %v", fold, all, keys)
+		}
+
+		if keys, exp := page(r.CursorFrom("NS/50/"), 3), []string{"ns/50/x", "ns/51", "ns/51/x"}; fold && !reflect.DeepEqual(keys, exp) {
+			t.Fatalf("expected %v, got %v", exp, keys)
+		}
+		if keys := page(r.CursorFrom("nt"), 1); keys != nil {
+			t.Fatalf("expected no keys, got %v", keys)
+		}
+	}
+
+	if _, _, ok := New[interface{}](false).Cursor().Next(); ok {
+		t.Fatal("unexpected key in an empty tree")
+	}
+}
+
+func TestIteratorSeekPrefixGE(t *testing.T) {
+	r := New[interface{}](false)
+	for i := 0; i < 50; i++ {
+		r.Set(fmt.Sprintf("ns/%02d", i), i)
+		r.Set(fmt.Sprintf("ns2/%02d", i), i)
+		r.Set(fmt.Sprintf("nr/%02d", i), i)
+	}
+	r.Set("ns", -1)
+
+	collect := func(it *Iterator[interface{}], limit int) (keys []string) {
+		for it.Next() && len(keys) != limit {
+			if v, _ := r.Get(it.Key()); v != it.Value() {
+				t.Fatalf("bad value for %q: %v", it.Key(), it.Value())
+			}
+			keys = append(keys, it.Key())
+		}
+		return
+	}
+
+	it := r.Iterator()
+	if keys := collect(it, -1); !reflect.DeepEqual(keys, r.Keys()) {
+		t.Fatalf("expected %q, got %q", r.Keys(), keys)
+	}
+
+	// scan in pages of 10, resuming after the last key
+	var keys []string
+	it.SeekPrefix("ns/")
+	for page := collect(it, 10); len(page) > 0; page = collect(it, 10) {
+		keys = append(keys, page...)
+		it.SeekPrefixGE("ns/", page[len(page)-1]+"\x00")
+	}
+	var exp []string
+	r.WalkPrefix("ns/", func(k string, _ interface{}) bool {
+		exp = append(exp, k)
+		return false
+	})
+	if len(exp) != 50 || !reflect.DeepEqual(keys, exp) {
+		t.Fatalf("expected %q, got %q", exp, keys)
+	}
+
+	cases := []struct {
+		prefix, key string
+		limit       int
+		exp         []string
+	}{
+		{"ns/", "ns/47", -1, []string{"ns/47", "ns/48", "ns/49"}},
+		{"ns/", "ns/475", -1, []string{"ns/48", "ns/49"}},
+		{"ns/", "a", -1, exp},
+		{"ns/", "ns/9", -1, nil},
+		{"ns/", "z", -1, nil},
+		{"ns", "ns/48", 4, []string{"ns/48", "ns/49", "ns2/00", "ns2/01"}},
+		{"ns2/", "ns2/", 2, []string{"ns2/00", "ns2/01"}},
+		{"x", "", -1, nil},
+		{"", "nr/48", 3, []string{"nr/48", "nr/49", "ns"}},
+	}
+	for _, c := range cases {
+		it.SeekPrefixGE(c.prefix, c.key)
+		if got := collect(it, c.limit); !reflect.DeepEqual(got, c.exp) {
+			t.Fatalf("SeekPrefixGE(%q, %q): expected %q, got %q", c.prefix, c.key, c.exp, got)
+		}
+	}
+}
+
+func TestWalkRange(t *testing.T) {
+	r := New[interface{}](false)
+	keys := []string{"", "a", "ab", "abc", "abd", "b", "ba", "bcd", "c", "d/e"}
+	for _, k := range keys {
+		r.Set(k, k)
+	}
+
+	walk := func(from, to string) (out []string) {
+		r.WalkRange(from, to, func(k string, v interface{}) bool {
+			if v != k {
+				t.Fatalf("bad value for %q: %v", k, v)
+			}
+			out = append(out, k)
+			return false
+		})
+		return
+	}
+
+	cases := []struct {
+		from, to string
+		exp      []string
+	}{
+		{"", "", keys},
+		{"", "ab", []string{"", "a"}},
+		{"ab", "b", []string{"ab", "abc", "abd"}},
+		{"abc", "abd", []string{"abc"}},
+		{"abca", "bb", []string{"abd", "b", "ba"}},
+		{"b", "", []string{"b", "ba", "bcd", "c", "d/e"}},
+		{"bz", "d", []string{"c"}},
+		{"c", "c", nil},
+		{"d", "a", nil},
+		{"e", "", nil},
+	}
+	for _, c := range cases {
+		if got := walk(c.from, c.to); !reflect.DeepEqual(got, c.exp) {
+			t.Fatalf("WalkRange(%q, %q): expected %q, got %q", c.from, c.to, c.exp, got)
+		}
+	}
+
+	var n int
+	if !r.WalkRange("a", "", func(string, interface{}) bool { n++; return n == 2 }) || n != 2 {
+		t.Fatal("expected the walk to be aborted")
+	}
+}
+
+func TestSubtree(t *testing.T) {
+	for _, fold := range []bool{false, true} {
+		r := New[interface{}](fold)
+		for _, k := range []string{"", "/api", "/api/users", "/api/users/1", "/api/v1", "/apis", "/static/app.js"} {
+			r.Set(k, k)
+		}
+
+		st, ok := r.Subtree("/api/")
+		if exp := []string{"/api/users", "/api/users/1", "/api/v1"}; !ok || !reflect.DeepEqual(st.Keys(), exp) {
+			t.Fatalf("fold=%v: expected %q, got %q", fold, exp, st.Keys())
+		}
+		if _, ok := r.Subtree("/API/"); ok != fold {
+			t.Fatalf("fold=%v: unexpected subtree for /API/", fold)
+		}
+		if err := st.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		if st.SharedNodeCount(r) != 0 {
+			t.Fatal("the subtree shares nodes with the tree")
+		}
+
+		// the subtree is independent and keeps the options
+		st.Set("/api/v2", "v2")
+		st.Delete("/api/v1")
+		if _, ok := r.Get("/api/v1"); !ok || r.Len() != 7 {
+			t.Fatal("the tree was modified")
+		}
+		if v, ok := st.Get("/API/USERS"); ok != fold || (fold && v != "/api/users") {
+			t.Fatalf("fold=%v: unexpected value: %v %v", fold, v, ok)
+		}
+
+		ss, ok := r.SubtreeStripped("/api")
+		if exp := []string{"", "/users", "/users/1", "/v1", "s"}; !ok || !reflect.DeepEqual(ss.Keys(), exp) {
+			t.Fatalf("expected %q, got %q", exp, ss.Keys())
+		}
+		if v, _ := ss.Get("/users/1"); v != "/api/users/1" {
+			t.Fatalf("unexpected value: %v", v)
+		}
+		if err := ss.Validate(); err != nil {
+			t.Fatal(err)
+		}
+
+		if st, ok := r.Subtree("/nope"); ok || st != nil {
+			t.Fatal("expected no subtree")
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	for _, fold := range []bool{false, true} {
+		r := New[interface{}](fold)
+		exp := map[string]interface{}{}
+		for i := 0; i < 1000; i++ {
+			k := fmt.Sprintf("/Items/%d", i)
+			r.Set(k, i)
+			if i >= 100 && i < 200 {
+				exp[k] = i
+			}
+		}
+
+		fr := r.Filter(func(_ string, v interface{}) bool {
+			n := v.(int)
+			return n >= 100 && n < 200
+		})
+		if err := fr.Validate(); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(fr.ToMap(), exp) || r.Len() != 1000 {
+			t.Fatalf("fold=%v: mis-match: %d", fold, fr.Len())
+		}
+		if _, ok := fr.Get("/items/150"); ok != fold {
+			t.Fatalf("fold=%v: the case sensitivity wasn't preserved", fold)
+		}
+	}
+
+	if fr := New[interface{}](false).Filter(func(string, interface{}) bool { return true }); fr.Len() != 0 {
+		t.Fatal("expected an empty tree")
+	}
+}
+
+func TestSplit(t *testing.T) {
+	for _, fold := range []bool{false, true} {
+		r := New[interface{}](fold, WithValueIndex())
+		for i := 0; i < 500; i++ {
+			r.Set(fmt.Sprintf("/api/%d/%d", i%7, i), i)
+		}
+		r.Set("", -1)
+		r.Set("/api/3", -2)
+		all := r.ToMap()
+		cmp := compareFn(fold)
+
+		for _, key := range []string{"", "/", "/api/3", "/API/3/1", "/api/3/", "/api/35", "/api/4/100", "/api/9", "z"} {
+			left, right := r.Split(key)
+			for _, tr := range []*Tree[interface{}]{left, right} {
+				if err := tr.Validate(); err != nil {
+					t.Fatalf("Split(%q): %v", key, err)
+				}
+				if tr.fold != fold || tr.vidx == nil {
+					t.Fatalf("Split(%q): options weren't preserved", key)
+				}
+			}
+
+			left.Walk(func(k string, _ interface{}) bool {
+				if cmp(k, key) >= 0 {
+					t.Fatalf("Split(%q): %q is on the left", key, k)
+				}
+				return false
+			})
+			right.Walk(func(k string, _ interface{}) bool {
+				if cmp(k, key) < 0 {
+					t.Fatalf("Split(%q): %q is on the right", key, k)
+				}
+				return false
+			})
+
+			union := left.ToMap()
+			for k, v := range right.ToMap() {
+				union[k] = v
+			}
+			if left.Len()+right.Len() != len(all) || !reflect.DeepEqual(union, all) {
+				t.Fatalf("Split(%q): the union doesn't match the original tree", key)
+			}
+			if k, ok := right.KeyForValue(-2, nil); cmp(key, "/api/3") <= 0 && (!ok || k != "/api/3") {
+				t.Fatalf("Split(%q): bad value index: %q %v", key, k, ok)
+			}
+		}
+
+		if !reflect.DeepEqual(r.ToMap(), all) {
+			t.Fatal("the original tree was modified")
+		}
+	}
+}
+
+func TestCountPrefix(t *testing.T) {
+	r := New[interface{}](false)
+	var prefixes []string
+	for i := 0; i < 1000; i++ {
+		k := generateUUID()
+		r.Set(k, i)
+		prefixes = append(prefixes, k[:i%6], k[:len(k)-1], k)
+	}
+	prefixes = append(prefixes, "", "zz", "-")
+
+	for _, prefix := range prefixes {
+		var exp int
+		r.WalkPrefix(prefix, func(string, interface{}) bool {
+			exp++
+			return false
+		})
+		if n := r.CountPrefix(prefix); n != exp {
+			t.Fatalf("CountPrefix(%q): expected %d, got %d", prefix, exp, n)
+		}
+	}
+
+	counts := r.CountPrefixes(prefixes)
+	if len(counts) != len(prefixes) {
+		t.Fatalf("bad len: %v %v", len(counts), len(prefixes))
+	}
+	for i, prefix := range prefixes {
+		if n := r.CountPrefix(prefix); counts[i] != n {
+			t.Fatalf("CountPrefixes[%d] (%q): expected %d, got %d", i, prefix, n, counts[i])
+		}
+	}
+}
+
+func TestTimestamps(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+
+	r := New[interface{}](true, WithTimestamps(clock))
+	for i := 0; i < 10; i++ {
+		r.Set(fmt.Sprintf("key/%d", i), i)
+		now = now.Add(time.Minute)
+	}
+	// updates don't change the timestamp, even if the casing changed
+	r.Set("KEY/0", "updated")
+
+	if age, ok := r.AgeOf("key/0"); !ok || age != 10*time.Minute {
+		t.Fatalf("expected 10m, got %v (%v)", age, ok)
+	}
+	if _, ok := r.AgeOf("key/x"); ok {
+		t.Fatal("expected no age for a missing key")
+	}
+
+	exp := []string{"KEY/0", "key/1", "key/2", "key/3"}
+	if keys := r.OlderThan(6 * time.Minute); !reflect.DeepEqual(keys, exp) {
+		t.Fatalf("expected %q, got %q", exp, keys)
+	}
+
+	r.Delete("key/1")
+	r.DeletePrefix("key/2")
+	r.Rebuild()
+	left, _ := r.Split("key/3")
+	if keys := left.OlderThan(6 * time.Minute); !reflect.DeepEqual(keys, exp[:1]) {
+		t.Fatalf("expected %q, got %q", exp[:1], keys)
+	}
+	if keys := r.OlderThan(6 * time.Minute); !reflect.DeepEqual(keys, []string{"KEY/0", "key/3"}) {
+		t.Fatalf("expected [KEY/0 key/3], got %q", keys)
+	}
+	if len(r.times) != r.Len() {
+		t.Fatalf("expected %d timestamps, got %d", r.Len(), len(r.times))
+	}
+
+	r.Set("key/1", 1)
+	if age, ok := r.AgeOf("key/1"); !ok || age != 0 {
+		t.Fatalf("expected 0, got %v (%v)", age, ok)
+	}
+
+	if New[interface{}](false).OlderThan(0) != nil {
+		t.Fatal("expected no keys without WithTimestamps")
+	}
+}
+
+func TestPrefixChain(t *testing.T) {
+	r := New[interface{}](false)
+	for _, k := range []string{"a", "ab", "abc", "abd", "b", "abcde"} {
+		r.Set(k, len(k))
+	}
+
+	cases := map[string][]Entry[interface{}]{
+		"":      nil,
+		"x":     nil,
+		"a":     {{"a", 1}},
+		"abc":   {{"a", 1}, {"ab", 2}, {"abc", 3}},
+		"abcd":  {{"a", 1}, {"ab", 2}, {"abc", 3}},
+		"abcde": {{"a", 1}, {"ab", 2}, {"abc", 3}, {"abcde", 5}},
+		"abx":   {{"a", 1}, {"ab", 2}},
+	}
+	for s, exp := range cases {
+		if got := r.PrefixChain(s); !reflect.DeepEqual(got, exp) {
+			t.Fatalf("PrefixChain(%q): expected %v, got %v", s, exp, got)
+		}
+	}
+}
+
+func TestEmptyTree(t *testing.T) {
+	type tree = Tree[interface{}]
+	isEmpty := func(k string, v interface{}, ok bool) bool { return k == "" && v == nil && !ok }
+	walked := func(walk func(fn WalkFn[interface{}]) bool) bool {
+		called := false
+		aborted := walk(func(string, interface{}) bool {
+			called = true
+			return true
+		})
+		return !called && !aborted
+	}
+
+	cases := []struct {
+		name string
+		fn   func(r *tree) bool
+	}{
+		{"Len", func(r *tree) bool { return r.Len() == 0 }},
+		{"Get", func(r *tree) bool { v, ok := r.Get(""); return isEmpty("", v, ok) }},
+		{"KeyLenRange", func(r *tree) bool { min, max := r.KeyLenRange(); return min == 0 && max == 0 }},
+		{"KeyForValue", func(r *tree) bool {
+			k, ok := r.KeyForValue(nil, func(a, b interface{}) bool { return true })
+			return k == "" && !ok
+		}},
+		{"AgeOf", func(r *tree) bool { _, ok := r.AgeOf(""); return !ok }},
+		{"OlderThan", func(r *tree) bool { return len(r.OlderThan(0)) == 0 }},
+		{"LongestPrefix", func(r *tree) bool { return isEmpty(r.LongestPrefix("foo")) }},
+		{"LongestPrefixDiverge", func(r *tree) bool {
+			k, at, v, ok := r.LongestPrefixDiverge("foo")
+			return isEmpty(k, v, ok) && at == 0
+		}},
+		{"AnyPrefixOf", func(r *tree) bool { return !r.AnyPrefixOf("foo") }},
+		{"Minimum", func(r *tree) bool { return isEmpty(r.Minimum()) }},
+		{"Maximum", func(r *tree) bool { return isEmpty(r.Maximum()) }},
+		{"MinimumPrefix", func(r *tree) bool { return isEmpty(r.MinimumPrefix("")) }},
+		{"MaximumPrefix", func(r *tree) bool { return isEmpty(r.MaximumPrefix("")) }},
+		{"Predecessor", func(r *tree) bool { return isEmpty(r.Predecessor("foo")) }},
+		{"Successor", func(r *tree) bool { return isEmpty(r.Successor("")) }},
+		{"SmallestN", func(r *tree) bool { return len(r.SmallestN(3)) == 0 }},
+		{"LargestN", func(r *tree) bool { return len(r.LargestN(3)) == 0 }},
+		{"Walk", func(r *tree) bool { return walked(r.Walk) }},
+		{"WalkPrefix", func(r *tree) bool {
+			return walked(func(fn WalkFn[interface{}]) bool { return r.WalkPrefix("", fn) })
+		}},
+		{"WalkPath", func(r *tree) bool {
+			return walked(func(fn WalkFn[interface{}]) bool { return r.WalkPath("foo", fn) })
+		}},
+		{"WalkNearestPath", func(r *tree) bool {
+			return walked(func(fn WalkFn[interface{}]) bool { return r.WalkNearestPath("foo", fn) })
+		}},
+		{"WalkRange", func(r *tree) bool {
+			return walked(func(fn WalkFn[interface{}]) bool { return r.WalkRange("", "z", fn) })
+		}},
+		{"WalkErrPrune", func(r *tree) bool {
+			return r.WalkErrPrune(func(string, interface{}) (bool, error) { return false, errors.New("called") }) == nil
+		}},
+		{"LeafKeys", func(r *tree) bool { return walked(r.LeafKeys) }},
+		{"PrefixInfo", func(r *tree) bool {
+			exists, isKey, n := r.PrefixInfo("")
+			return !exists && !isKey && n == 0
+		}},
+		{"PrefixChain", func(r *tree) bool { return len(r.PrefixChain("foo")) == 0 }},
+		{"Partitions", func(r *tree) bool { return len(r.Partitions(4)) == 0 }},
+		{"ToMap", func(r *tree) bool { return len(r.ToMap()) == 0 }},
+		{"ToMapPrefix", func(r *tree) bool { return len(r.ToMapPrefix("")) == 0 }},
+		{"Keys", func(r *tree) bool { return len(r.Keys()) == 0 }},
+		{"Values", func(r *tree) bool { return len(r.Values()) == 0 }},
+		{"ValuesPrefix", func(r *tree) bool { return len(r.ValuesPrefix("")) == 0 }},
+		{"CountPrefix", func(r *tree) bool { return r.CountPrefix("") == 0 }},
+		{"CountPrefixes", func(r *tree) bool { c := r.CountPrefixes([]string{"", "a"}); return c[0] == 0 && c[1] == 0 }},
+		{"StructuralHealth", func(r *tree) bool { h := r.StructuralHealth(); return h == h }},
+		{"LeafDepthHistogram", func(r *tree) bool { return len(r.LeafDepthHistogram()) == 0 }},
+		{"Dump", func(r *tree) bool { r.Dump(false); r.Dump(true); return true }},
+		{"MarshalPrefix", func(r *tree) bool { var buf bytes.Buffer; return r.MarshalPrefix(&buf, "") == nil && buf.Len() == 0 }},
+		{"Validate", func(r *tree) bool { return r.Validate() == nil }},
+		{"Clone", func(r *tree) bool { return r.Clone().Len() == 0 }},
+		{"Split", func(r *tree) bool { a, b := r.Split("m"); return a.Len() == 0 && b.Len() == 0 }},
+		{"Iterator", func(r *tree) bool {
+			it := r.Iterator()
+			if it.Next() {
+				return false
+			}
+			it.SeekPrefix("")
+			return !it.Next()
+		}},
+		{"Delete", func(r *tree) bool { v, ok := r.Delete("foo"); return isEmpty("", v, ok) }},
+		{"DeletePrefix", func(r *tree) bool { return r.DeletePrefix("") == 0 }},
+		{"DrainPrefix", func(r *tree) bool { return len(r.DrainPrefix("")) == 0 }},
+		{"Prune", func(r *tree) bool { return r.Prune(func(interface{}) bool { return true }) == 0 }},
+	}
+
+	for _, fold := range []bool{false, true} {
+		for _, c := range cases {
+			r := New[interface{}](fold)
+			func() {
+				defer func() {
+					if err := recover(); err != nil {
+						t.Errorf("fold=%v: %s panicked: %v", fold, c.name, err)
+					}
+				}()
+				if !c.fn(r) {
+					t.Errorf("fold=%v: %s didn't return a safe default", fold, c.name)
+				}
+			}()
+		}
+	}
+}
+
+func TestLoadJSON(t *testing.T) {
+	for _, fold := range []bool{false, true} {
+		r := New[interface{}](fold)
+		for i := 0; i < 500; i++ {
+			r.Set(fmt.Sprintf("/api/%d/%d", i%7, i), fmt.Sprint(i))
+			r.Set(generateUUID(), "uuid")
+		}
+		r.Set("/API/ü", "ü")
+		r.Set("", "root")
+
+		lr, err := LoadJSON[interface{}](strings.NewReader(r.Dump(true)), fold)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lr.Len() != r.Len() || !reflect.DeepEqual(lr.ToMap(), r.ToMap()) || lr.Dump(true) != r.Dump(true) {
+			t.Fatalf("fold=%v: mis-match", fold)
+		}
+		if v, ok := lr.Get("/api/Ü"); fold && (!ok || v != "ü") {
+			t.Fatalf("unexpected value: %v %v", v, ok)
+		}
+		if v, ok := lr.Get(""); !ok || v != "root" {
+			t.Fatalf("fold=%v: expected the empty key, got %v %v", fold, v, ok)
+		}
+	}
+
+	now := time.Unix(1000, 0)
+	r := New[interface{}](false)
+	r.Set("foo", 1)
+	r.Set("foobar", 2)
+	lr, err := LoadJSON[interface{}](strings.NewReader(r.Dump(true)), false, WithTimestamps(func() time.Time { return now }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"foo", "foobar"} {
+		if age, ok := lr.AgeOf(k); !ok || age != 0 {
+			t.Fatalf("%s: expected to be stamped when loaded, got %v %v", k, age, ok)
+		}
+	}
+
+	// dumps holding only the edges of the root
+	lr, err = LoadJSON[interface{}](strings.NewReader(`[{"node":{"prefix":"foo","leaf":{"key":"foo","value":1}},"label":102}]`), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := lr.Get("foo"); !ok || v != 1.0 || lr.Len() != 1 {
+		t.Fatalf("unexpected value: %v %v", v, ok)
+	}
+
+	if _, err := LoadJSON[interface{}](strings.NewReader(`[{"node":{"prefix":"foo"},"label":102}]`), false); err == nil {
+		t.Fatal("expected an error for a dangling node")
+	}
+	if _, err := LoadJSON[interface{}](strings.NewReader(`{"prefix":"foo","leaf":{"key":"foo"}}`), false); err == nil {
+		t.Fatal("expected an error for a root with a prefix")
+	}
+	if _, err := LoadJSON[interface{}](strings.NewReader(`[{`), false); err == nil {
+		t.Fatal("expected an error for invalid json")
+	}
+}
+
+type limitWriter struct {
+	n   int
+	err error
+}
+
+func (lw *limitWriter) Write(p []byte) (int, error) {
+	if len(p) > lw.n {
+		n := lw.n
+		lw.n = 0
+		return n, lw.err
+	}
+	lw.n -= len(p)
+	return len(p), nil
+}
+
+func TestWriteTo(t *testing.T) {
+	r := NewSafe[interface{}](false)
+	for i := 0; i < 100; i++ {
+		r.Set(fmt.Sprintf("/api/%d/%d", i%7, i), i)
+	}
+
+	var _ io.WriterTo = r
+	var buf bytes.Buffer
+	n, err := r.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(buf.Len()) || buf.String() != r.Dump(true) {
+		t.Fatalf("expected %d bytes of the json dump, got %d", buf.Len(), n)
+	}
+
+	errShort := errors.New("short write")
+	lw := &limitWriter{n: 100, err: errShort}
+	if n, err = r.WriteTo(lw); err != errShort || n != 100 {
+		t.Fatalf("expected 100 bytes and %v, got %d and %v", errShort, n, err)
+	}
+}
+
+func TestString(t *testing.T) {
+	r := NewSafe[interface{}](false)
+	for _, k := range []string{"", "foo", "foobar", "foozip", "ü"} {
+		r.Set(k, k)
+	}
+
+	if s, exp := fmt.Sprintf("%v", r), r.Dump(false); s != exp || !strings.Contains(s, "foozip") {
+		t.Fatalf("expected:\n%s\ngot:\n%s", exp, s)
+	}
+	if s, exp := fmt.Sprint(&r.t), r.t.Dump(false); s != exp {
+		t.Fatalf("expected:\n%s\ngot:\n%s", exp, s)
+	}
+}
+
+func TestDumpDOT(t *testing.T) {
+	r := New[interface{}](false)
+	for _, k := range []string{"", "foo", "foobar", "foozip", `say "hi"`, `back\slash`, "new\nline", "ü"} {
+		r.Set(k, k)
+	}
+
+	var buf bytes.Buffer
+	if err := r.DumpDOT(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	const str = `"(?:\\.|[^"\\])*"`
+	var (
+		nodeRe = regexp.MustCompile(`^\tn(\d+) \[label=` + str + `(, peripheries=2)?\];$`)
+		edgeRe = regexp.MustCompile(`^\tn(\d+) -> n(\d+) \[label=` + str + `\];$`)
+
+		lines          = strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+		nodes          = map[string]bool{}
+		edges          [][]string
+		leaves, nnodes int
+	)
+	if len(lines) < 3 || lines[0] != "digraph radix {" || lines[1] != "\tnode [shape=box];" || lines[len(lines)-1] != "}" {
+		t.Fatalf("bad graph:\n%s", buf.String())
+	}
+	for _, l := range lines[2 : len(lines)-1] {
+		if m := nodeRe.FindStringSubmatch(l); m != nil {
+			nodes[m[1]] = true
+			if m[2] != "" {
+				leaves++
+			}
+			nnodes++
+		} else if m := edgeRe.FindStringSubmatch(l); m != nil {
+			edges = append(edges, m[1:])
+		} else {
+			t.Fatalf("invalid line: %q", l)
+		}
+	}
+
+	if leaves != r.Len() || nnodes != len(nodes) || len(edges) != len(nodes)-1 {
+		t.Fatalf("bad graph: %d leaves, %d nodes, %d edges\n%s", leaves, len(nodes), len(edges), buf.String())
+	}
+	for _, e := range edges {
+		if !nodes[e[0]] || !nodes[e[1]] {
+			t.Fatalf("undeclared node in edge %v", e)
+		}
+	}
+	if !strings.Contains(buf.String(), `say \"hi\" = say \"hi\"`) || !strings.Contains(buf.String(), `back\\slash`) {
+		t.Fatalf("labels weren't escaped:\n%s", buf.String())
+	}
+}
+
+func TestFoldKey(t *testing.T) {
+	cases := []struct {
+		inp, exp string
+	}{
+		{"", ""},
+		{"foo/bar", "foo/bar"},
+		{"Foo/BAR", "foo/bar"},
+		{"/API/v1/Users", "/api/v1/users"},
+		{"ÄPFEL/Äpfel/äpfel", "äpfel/äpfel/äpfel"},
+		{"ΣΊΣΥΦΟΣ", "σίσυφοσ"},
+		{"日本語/ABC", "日本語/abc"},
+		{"bad\xffUTF8", "bad\xffutf8"},
+	}
+
+	rs := New[interface{}](false)
+	for _, c := range cases {
+		if got := rs.FoldKey(c.inp); got != c.inp {
+			t.Fatalf("case-sensitive FoldKey(%q): expected the key as is, got %q", c.inp, got)
+		}
+	}
+
+	r := New[interface{}](true)
+	for _, c := range cases {
+		got := r.FoldKey(c.inp)
+		if got != c.exp {
+			t.Fatalf("FoldKey(%q): expected %q, got %q", c.inp, c.exp, got)
+		}
+
+		r.Set(c.inp, c.inp)
+		if v, ok := r.Get(got); !ok || v != c.inp {
+			t.Fatalf("Get(%q): expected %q, got %v (%v)", got, c.inp, v, ok)
+		}
+	}
+
+	// keys with the same FoldKey are the same entry, different ones aren't
+	keys := []string{"a", "A", "ab", "aB", "Ab", "É", "é", "e", "ǅ", "ǆ", "Ǆ", "straße", "STRASSE"}
+	for _, a := range keys {
+		for _, b := range keys {
+			r := New[interface{}](true)
+			r.Set(a, a)
+			_, ok := r.Get(b)
+			if same := r.FoldKey(a) == r.FoldKey(b); ok != same {
+				t.Fatalf("%q, %q: FoldKey equality is %v, but lookup found=%v", a, b, same, ok)
+			}
+		}
+	}
+}
+
+func TestFoldSpecialCases(t *testing.T) {
+	cases := []struct {
+		a, b string
+		eq   bool
+	}{
+		{"σ", "Σ", true},
+		{"ς", "Σ", true},
+		{"ς", "σ", true},
+		{"ΟΔΥΣΣΕΥΣ", "οδυσσευς", true},
+		{"ǅ", "ǆ", true},
+		{"ǅ", "Ǆ", true},
+		{"ϐ", "β", true},
+		{"İstanbul", "istanbul", false},
+		{"İstanbul", "İSTANBUL", true},
+		{"ısparta", "ISPARTA", false},
+		{"ısparta", "isparta", false},
+		{"\u212a", "k", false}, // Kelvin sign
+		{"\u212a", "K", false},
+		{"ſ", "s", false},
+		{"straße", "STRASSE", false},
+	}
+
+	for _, c := range cases {
+		if eq := StringsEqualFold(c.a, c.b); eq != c.eq {
+			t.Fatalf("StringsEqualFold(%q, %q): expected %v", c.a, c.b, c.eq)
+		}
+		if eq := HasPrefixFold(c.a+"/x", c.b); eq != c.eq {
+			t.Fatalf("HasPrefixFold(%q, %q): expected %v", c.a+"/x", c.b, c.eq)
+		}
+		if eq := compareFold(c.a, c.b) == 0; eq != c.eq {
+			t.Fatalf("compareFold(%q, %q): expected %v", c.a, c.b, c.eq)
+		}
+
+		r := New[interface{}](true)
+		r.Set(c.a, c.a)
+		if _, ok := r.Get(c.b); ok != c.eq {
+			t.Fatalf("Get(%q) after Set(%q): expected %v", c.b, c.a, c.eq)
+		}
+		if eq := r.FoldKey(c.a) == r.FoldKey(c.b); eq != c.eq {
+			t.Fatalf("FoldKey(%q) == FoldKey(%q): expected %v", c.a, c.b, c.eq)
+		}
+
+		r.Set(c.b, c.b)
+		if err := r.Validate(); err != nil {
+			t.Fatalf("%q, %q: %v", c.a, c.b, err)
+		}
+		exp := 2
+		if c.eq {
+			exp = 1
+		}
+		if r.Len() != exp {
+			t.Fatalf("%q, %q: expected %d keys, got %q", c.a, c.b, exp, r.Keys())
+		}
+	}
+}
+
+func TestLongestPrefixDiverge(t *testing.T) {
+	for _, fold := range []bool{false, true} {
+		r := New[interface{}](fold)
+		for _, k := range []string{"/api", "/api/v1", "/api/v1/abc", "/api/v2/users", "/static/"} {
+			r.Set(k, k)
+		}
+
+		cases := []struct {
+			inp     string
+			matched string
+			at      int
+		}{
+			{"", "", 0},
+			{"/x", "", 1},
+			{"/ap", "", 3},
+			{"/api", "/api", 4},
+			{"/api/v1/xyz", "/api/v1", 8},
+			{"/api/v1/abc", "/api/v1/abc", 11},
+			{"/api/v1/abcdef", "/api/v1/abc", 11},
+			{"/api/v2/user", "/api", 12},
+			{"/api/v3", "/api", 6},
+			{"/static/js/app.js", "/static/", 8},
+		}
+		for _, c := range cases {
+			inp := c.inp
+			if fold {
+				inp = strings.ToUpper(inp)
+			}
+			m, at, v, ok := r.LongestPrefixDiverge(inp)
+			if m != c.matched || at != c.at || ok != (c.matched != "") || (ok && v != c.matched) {
+				t.Fatalf("fold=%v: LongestPrefixDiverge(%q): expected (%q, %d), got (%q, %d, %v, %v)", fold, inp, c.matched, c.at, m, at, v, ok)
+			}
+		}
+	}
+}
+
+func TestLastWriterCasing(t *testing.T) {
+	r := New[interface{}](true, WithValueIndex(), WithTimestamps(nil))
+	sets := []string{"FooBar", "foobaz", "FOO", "abc", "fooBAR", "foo/x", "ABC", "Foo", "FOOBAZ", "abc/Äpfel", "ABC/äPFEL"}
+	for i, k := range sets {
+		r.Set(k, i)
+	}
+
+	exp := []string{"ABC", "ABC/äPFEL", "Foo", "foo/x", "fooBAR", "FOOBAZ"}
+	if keys := r.Keys(); !reflect.DeepEqual(keys, exp) {
+		t.Fatalf("expected %q, got %q", exp, keys)
+	}
+	if err := r.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if k, ok := r.KeyForValue(6, nil); !ok || k != "ABC" {
+		t.Fatalf("expected ABC, got %q", k)
+	}
+	if _, ok := r.AgeOf("abc"); !ok || len(r.times) != r.Len() {
+		t.Fatalf("expected %d timestamps, got %d", r.Len(), len(r.times))
+	}
+
+	r.Update("abc", func(v interface{}, _ bool) (interface{}, bool) { return v, true })
+	r.SetBytes([]byte("FOO/X"), 1)
+	r.GetOrSet("FOObar", 1)
+	r.MergeMap(map[string]interface{}{"foobaz": 1})
+	exp = []string{"abc", "ABC/äPFEL", "Foo", "FOO/X", "fooBAR", "foobaz"}
+	if keys := r.Keys(); !reflect.DeepEqual(keys, exp) {
+		t.Fatalf("expected %q, got %q", exp, keys)
+	}
+	if k, _, _ := r.LongestPrefix("FOO/X/y"); k != "FOO/X" {
+		t.Fatalf("expected FOO/X, got %q", k)
+	}
+
+	// strict trees keep the first casing
+	r = New[interface{}](true, WithStrictFold())
+	for _, k := range []string{"abc", "ABC", "Abc"} {
+		r.Set(k, k)
+		r.Update(k, func(v interface{}, _ bool) (interface{}, bool) { return v, true })
+	}
+	if keys := r.Keys(); !reflect.DeepEqual(keys, []string{"abc"}) {
+		t.Fatalf("expected [abc], got %q", keys)
+	}
+}
+
+func TestGetExact(t *testing.T) {
+	for _, fold := range []bool{false, true} {
+		r := New[interface{}](fold)
+		r.Set("admin", "admin")
+		r.Set("/Users/Äpfel", "äpfel")
+
+		cases := []struct {
+			key          string
+			exact, found bool // found is the result of Get in a case-insensitive tree
+		}{
+			{"admin", true, true},
+			{"Admin", false, true},
+			{"ADMIN", false, true},
+			{"/Users/Äpfel", true, true},
+			{"/users/äpfel", false, true},
+			{"adm", false, false},
+			{"admin2", false, false},
+		}
+		for _, c := range cases {
+			if _, ok := r.GetExact(c.key); ok != c.exact {
+				t.Fatalf("fold=%v: GetExact(%q): expected %v", fold, c.key, c.exact)
+			}
+			found := c.exact
+			if fold {
+				found = c.found
+			}
+			if _, ok := r.Get(c.key); ok != found {
+				t.Fatalf("fold=%v: Get(%q): expected %v", fold, c.key, found)
+			}
+		}
+	}
+}
+
+func TestBytesKeys(t *testing.T) {
+	keys := []string{"", "foo", "foobar", "foo/bar", "Äpfel", "äpfel/x", "日本語"}
+	queries := append([]string{"f", "FOO", "fooba", "ÄPFEL", "äpfel", "äpfel/X", "日本", "foo/bar/"}, keys...)
+
+	for _, fold := range []bool{false, true} {
+		r := New[interface{}](fold)
+		for _, k := range keys {
+			r.Set(k, k)
+		}
+
+		for _, q := range queries {
+			ev, eok := r.Get(q)
+			if v, ok := r.GetBytes([]byte(q)); v != ev || ok != eok {
+				t.Fatalf("fold=%v: GetBytes(%q): expected (%v, %v), got (%v, %v)", fold, q, ev, eok, v, ok)
+			}
 		}
 
-		out := []string{}
-		fn := func(s string, v interface{}) bool {
-			out = append(out, s)
-			return false
+		exp := r.Clone()
+		for i, q := range queries {
+			eo, ef := exp.Set(q, i)
+			if o, f := r.SetBytes([]byte(q), i); o != eo || f != ef {
+				t.Fatalf("fold=%v: SetBytes(%q): expected (%v, %v), got (%v, %v)", fold, q, eo, ef, o, f)
+			}
+		}
+		if !reflect.DeepEqual(r.ToMap(), exp.ToMap()) {
+			t.Fatalf("fold=%v: mis-match", fold)
 		}
-		r.Walk(fn)
 
-		if !reflect.DeepEqual(out, test.out) {
-			t.Fatalf("mis-match: %v %v", out, test.out)
+		for _, q := range queries {
+			eo, ef := exp.Delete(q)
+			if o, f := r.DeleteBytes([]byte(q)); o != eo || f != ef {
+				t.Fatalf("fold=%v: DeleteBytes(%q): expected (%v, %v), got (%v, %v)", fold, q, eo, ef, o, f)
+			}
+		}
+		if r.Len() != 0 || exp.Len() != 0 {
+			t.Fatalf("fold=%v: expected empty trees, got %d %d", fold, r.Len(), exp.Len())
 		}
 	}
+
+	r := New[interface{}](false)
+	r.Set("/api/v1/users", 1)
+	key := []byte("/api/v1/users")
+	if n := testing.AllocsPerRun(100, func() {
+		r.GetBytes(key)
+		r.SetBytes(key, 2)
+	}); n != 0 {
+		t.Fatalf("expected no allocations, got %v", n)
+	}
 }
 
 func TestLongestPrefix(t *testing.T) {
@@ -294,6 +3347,654 @@ func TestWalkPrefix(t *testing.T) {
 	}
 }
 
+func TestValuesPrefix(t *testing.T) {
+	r := New[interface{}](false)
+	for i := 0; i < 1000; i++ {
+		r.Set(fmt.Sprintf("/api/%02d/%03d/%04d", i%10, i%100, i), i)
+	}
+
+	for _, prefix := range []string{"", "/", "/api/0", "/api/03/", "/api/03/003/0003", "/api/03/003/00034", "/x"} {
+		var exp []interface{}
+		r.WalkPrefix(prefix, func(_ string, v interface{}) bool {
+			exp = append(exp, v)
+			return false
+		})
+		if got := r.ValuesPrefix(prefix); !reflect.DeepEqual(got, exp) {
+			t.Fatalf("ValuesPrefix(%q): expected %v, got %v", prefix, exp, got)
+		}
+	}
+}
+
+func TestWalkValues(t *testing.T) {
+	r := New[interface{}](false)
+	for i := 0; i < 300; i++ {
+		r.Set(fmt.Sprintf("/c/%d/%03d", i%2, i), i)
+	}
+	over := func(v interface{}) bool { return v.(int) > 100 }
+
+	var exp, got []string
+	r.Walk(func(k string, v interface{}) bool {
+		if over(v) {
+			exp = append(exp, k)
+		}
+		return false
+	})
+	r.WalkValues(over, func(k string, _ interface{}) bool {
+		got = append(got, k)
+		return false
+	})
+	if len(exp) != 199 || !reflect.DeepEqual(got, exp) {
+		t.Fatalf("WalkValues: expected %v, got %v", exp, got)
+	}
+
+	exp, got = exp[:0], got[:0]
+	r.WalkPrefix("/c/1/", func(k string, v interface{}) bool {
+		if over(v) {
+			exp = append(exp, k)
+		}
+		return false
+	})
+	r.WalkPrefixValues("/c/1/", over, func(k string, _ interface{}) bool {
+		got = append(got, k)
+		return len(got) == 10
+	})
+	if !reflect.DeepEqual(got, exp[:10]) {
+		t.Fatalf("WalkPrefixValues: expected %v, got %v", exp[:10], got)
+	}
+}
+
+func TestWalkErrPrune(t *testing.T) {
+	r := New[interface{}](false)
+	keys := []string{"", "a", "a/b", "a/b/c", "a/c", "b", "b/a", "c"}
+	for _, k := range keys {
+		r.Set(k, nil)
+	}
+
+	walk := func(fn func(k string) (bool, error)) (visited []string, err error) {
+		err = r.WalkErrPrune(func(k string, _ interface{}) (bool, error) {
+			visited = append(visited, k)
+			return fn(k)
+		})
+		return
+	}
+
+	visited, err := walk(func(string) (bool, error) { return false, nil })
+	if err != nil || !reflect.DeepEqual(visited, keys) {
+		t.Fatalf("expected %q, got %q (%v)", keys, visited, err)
+	}
+
+	visited, err = walk(func(k string) (bool, error) { return k == "a/b" || k == "b", nil })
+	if exp := []string{"", "a", "a/b", "a/c", "b", "c"}; err != nil || !reflect.DeepEqual(visited, exp) {
+		t.Fatalf("expected %q, got %q (%v)", exp, visited, err)
+	}
+
+	visited, err = walk(func(k string) (bool, error) { return k == "", nil })
+	if exp := []string{""}; err != nil || !reflect.DeepEqual(visited, exp) {
+		t.Fatalf("expected %q, got %q (%v)", exp, visited, err)
+	}
+
+	errStop := errors.New("stop")
+	visited, err = walk(func(k string) (bool, error) {
+		if k == "a/c" {
+			return false, errStop
+		}
+		return false, nil
+	})
+	if exp := []string{"", "a", "a/b", "a/b/c", "a/c"}; err != errStop || !reflect.DeepEqual(visited, exp) {
+		t.Fatalf("expected %q, got %q (%v)", exp, visited, err)
+	}
+}
+
+// globMatcher matches keys against a path.Match pattern, pruning on the literal prefix of the pattern.
+type globMatcher struct {
+	pattern, lit string
+	prefixCalls  int
+}
+
+func newGlobMatcher(pattern string) *globMatcher {
+	lit := pattern
+	if i := strings.IndexAny(pattern, `*?[\`); i != -1 {
+		lit = pattern[:i]
+	}
+	return &globMatcher{pattern: pattern, lit: lit}
+}
+
+func (m *globMatcher) MatchPrefix(prefix string) bool {
+	m.prefixCalls++
+	return strings.HasPrefix(prefix, m.lit) || strings.HasPrefix(m.lit, prefix)
+}
+
+func (m *globMatcher) Match(key string) bool {
+	ok, _ := path.Match(m.pattern, key)
+	return ok
+}
+
+// regexpMatcher matches keys against an anchored regexp, pruning on its literal prefix.
+type regexpMatcher struct {
+	re          *regexp.Regexp
+	lit         string
+	prefixCalls int
+}
+
+func (m *regexpMatcher) MatchPrefix(prefix string) bool {
+	m.prefixCalls++
+	return strings.HasPrefix(prefix, m.lit) || strings.HasPrefix(m.lit, prefix)
+}
+
+func (m *regexpMatcher) Match(key string) bool {
+	return m.re.MatchString(key)
+}
+
+func TestWalkMatch(t *testing.T) {
+	r := New[interface{}](false)
+	for i := 0; i < 1000; i++ {
+		r.Set(fmt.Sprintf("/users/%d/profile", i), i)
+		r.Set(fmt.Sprintf("/users/%d/posts", i), i)
+		r.Set(fmt.Sprintf("/groups/%d", i), i)
+	}
+
+	var nodes int
+	walkNodes(&r.root, func(*node[interface{}]) { nodes++ })
+
+	walk := func(m Matcher) (out []string) {
+		r.WalkMatch(m, func(k string, _ interface{}) bool {
+			out = append(out, k)
+			return false
+		})
+		return
+	}
+	brute := func(match func(string) bool) (out []string) {
+		r.Walk(func(k string, _ interface{}) bool {
+			if match(k) {
+				out = append(out, k)
+			}
+			return false
+		})
+		return
+	}
+
+	gm := newGlobMatcher("/users/1?/p*")
+	if got, exp := walk(gm), brute(gm.Match); len(exp) != 20 || !reflect.DeepEqual(got, exp) {
+		t.Fatalf("glob: expected %q, got %q", exp, got)
+	}
+	if gm.prefixCalls > nodes/10 {
+		t.Fatalf("glob: expected pruning, %d prefix calls for %d nodes", gm.prefixCalls, nodes)
+	}
+
+	re := regexp.MustCompile(`^/groups/9\d$`)
+	lit, _ := re.LiteralPrefix()
+	rm := &regexpMatcher{re: re, lit: lit}
+	if got, exp := walk(rm), brute(rm.Match); len(exp) != 10 || !reflect.DeepEqual(got, exp) {
+		t.Fatalf("regexp: expected %q, got %q", exp, got)
+	}
+	if rm.prefixCalls > nodes/10 {
+		t.Fatalf("regexp: expected pruning, %d prefix calls for %d nodes", rm.prefixCalls, nodes)
+	}
+
+	var n int
+	if !r.WalkMatch(newGlobMatcher("/users/*/posts"), func(string, interface{}) bool {
+		n++
+		return n == 3
+	}) || n != 3 {
+		t.Fatalf("expected the walk to abort after 3 keys, got %d", n)
+	}
+}
+
+func TestWalkN(t *testing.T) {
+	r := New[interface{}](false)
+	for _, k := range []string{"", "a", "a/b", "a/c", "a/d", "b", "c"} {
+		r.Set(k, k)
+	}
+
+	walk := func(walk func(fn WalkFn[interface{}]) bool, abortAt string) (out []string, aborted bool) {
+		aborted = walk(func(k string, _ interface{}) bool {
+			out = append(out, k)
+			return k == abortAt
+		})
+		return
+	}
+
+	cases := []struct {
+		prefix  string
+		n       int
+		abortAt string // "-" never aborts
+		exp     []string
+		aborted bool
+	}{
+		{"", 3, "-", []string{"", "a", "a/b"}, false},
+		{"", 100, "-", []string{"", "a", "a/b", "a/c", "a/d", "b", "c"}, false},
+		{"", 0, "-", nil, false},
+		{"", -1, "-", nil, false},
+		{"a/", 2, "-", []string{"a/b", "a/c"}, false},
+		{"a/", 5, "a/c", []string{"a/b", "a/c"}, true},
+		{"a/", 2, "a/c", []string{"a/b", "a/c"}, true},
+		{"x", 2, "-", nil, false},
+	}
+	for _, c := range cases {
+		out, aborted := walk(func(fn WalkFn[interface{}]) bool { return r.WalkPrefixN(c.prefix, c.n, fn) }, c.abortAt)
+		if !reflect.DeepEqual(out, c.exp) || aborted != c.aborted {
+			t.Fatalf("WalkPrefixN(%q, %d): expected %q (%v), got %q (%v)", c.prefix, c.n, c.exp, c.aborted, out, aborted)
+		}
+		if c.prefix != "" {
+			continue
+		}
+		if out, aborted = walk(func(fn WalkFn[interface{}]) bool { return r.WalkN(c.n, fn) }, c.abortAt); !reflect.DeepEqual(out, c.exp) || aborted != c.aborted {
+			t.Fatalf("WalkN(%d): expected %q (%v), got %q (%v)", c.n, c.exp, c.aborted, out, aborted)
+		}
+	}
+}
+
+func TestWalkDepth(t *testing.T) {
+	r := NewSafe[interface{}](false)
+	for _, k := range []string{"", "a", "ab", "abc", "ac", "b", "foo/bar", "foo/baz"} {
+		r.Set(k, k)
+	}
+
+	var got []string
+	r.WalkDepth(func(k string, v interface{}, depth int) bool {
+		if v != k {
+			t.Fatalf("%q: unexpected value %v", k, v)
+		}
+		got = append(got, fmt.Sprintf("%s:%d", k, depth))
+		return false
+	})
+	// foo/bar and foo/baz are under a single foo/ba node
+	exp := []string{":0", "a:1", "ab:2", "abc:3", "ac:2", "b:1", "foo/bar:2", "foo/baz:2"}
+	if !reflect.DeepEqual(got, exp) {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+
+	r.Delete("ab")
+	got = got[:0]
+	aborted := r.WalkDepth(func(k string, _ interface{}, depth int) bool {
+		got = append(got, fmt.Sprintf("%s:%d", k, depth))
+		return k == "abc"
+	})
+	if exp := []string{":0", "a:1", "abc:2"}; !aborted || !reflect.DeepEqual(got, exp) {
+		t.Fatalf("expected %v, got %v (%v)", exp, got, aborted)
+	}
+}
+
+func TestWalkParallel(t *testing.T) {
+	r := New[interface{}](false)
+	r.Set("", -1)
+	r.Set("/", -2)
+	for i := 0; i < 1000; i++ {
+		r.Set(fmt.Sprintf("/api/%d/%d", i%10, i), i)
+	}
+
+	for _, workers := range []int{0, 1, 4, 100} {
+		var (
+			mux  sync.Mutex
+			keys []string
+			last = map[string]string{}
+		)
+		aborted := r.WalkParallel(workers, func(k string, _ interface{}) bool {
+			mux.Lock()
+			defer mux.Unlock()
+			keys = append(keys, k)
+			if len(k) > 5 {
+				// keys are in order within each /api/N/ subtree
+				part := k[:7]
+				if last[part] > k {
+					t.Errorf("%s visited after %s", k, last[part])
+				}
+				last[part] = k
+			}
+			return false
+		})
+		if aborted {
+			t.Fatal("unexpected abort")
+		}
+		sort.Strings(keys)
+		if exp := r.Keys(); !reflect.DeepEqual(keys, exp) {
+			t.Fatalf("workers=%d: expected %d keys, got %d", workers, len(exp), len(keys))
+		}
+	}
+
+	var n int32
+	aborted := NewSafe[interface{}](false).WalkParallel(4, func(string, interface{}) bool { return true })
+	if aborted {
+		t.Fatal("unexpected abort on an empty tree")
+	}
+	aborted = r.WalkParallel(4, func(k string, _ interface{}) bool {
+		return atomic.AddInt32(&n, 1) >= 10
+	})
+	if !aborted || n < 10 || n > 10+4 {
+		t.Fatalf("expected the walk to abort after 10 keys, got %v %d", aborted, n)
+	}
+}
+
+func TestComplete(t *testing.T) {
+	r := New[interface{}](true)
+	words := []string{"car", "card", "Care", "careful", "cart", "cat", "dog", "carbon", "CARGO"}
+	for i, w := range words {
+		r.Set(w, i)
+	}
+
+	cases := []struct {
+		prefix string
+		limit  int
+		exp    []string
+	}{
+		{"car", 3, []string{"car", "carbon", "card"}},
+		{"CAR", 0, []string{"car", "carbon", "card", "Care", "careful", "CARGO", "cart"}},
+		{"care", -1, []string{"Care", "careful"}},
+		{"ca", 100, []string{"car", "carbon", "card", "Care", "careful", "CARGO", "cart", "cat"}},
+		{"x", 5, nil},
+	}
+	for _, c := range cases {
+		got := r.Complete(c.prefix, c.limit)
+		if !reflect.DeepEqual(got, c.exp) {
+			t.Fatalf("Complete(%q, %d): expected %q, got %q", c.prefix, c.limit, c.exp, got)
+		}
+		if !sort.SliceIsSorted(got, func(i, j int) bool { return compareFold(got[i], got[j]) < 0 }) {
+			t.Fatalf("Complete(%q, %d): unsorted %q", c.prefix, c.limit, got)
+		}
+
+		es := r.CompleteWithValues(c.prefix, c.limit)
+		if len(es) != len(got) {
+			t.Fatalf("CompleteWithValues(%q, %d): expected %d entries, got %d", c.prefix, c.limit, len(got), len(es))
+		}
+		for i, e := range es {
+			if v, _ := r.Get(got[i]); e.Key != got[i] || e.Value != v {
+				t.Fatalf("CompleteWithValues(%q, %d): expected %q=%v, got %q=%v", c.prefix, c.limit, got[i], v, e.Key, e.Value)
+			}
+		}
+	}
+}
+
+func TestFuzzy(t *testing.T) {
+	words := []string{
+		"", "a", "book", "Books", "boon", "boot", "cake", "cape", "cart", "cat", "cats", "CUT",
+		"kitten", "mitten", "sitting", "smitten", "äpfel", "Apfel", "straße", "strasse",
+	}
+
+	levenshtein := func(a, b string, fold bool) int {
+		if fold {
+			a, b = strings.ToLower(a), strings.ToLower(b)
+		}
+		ra, rb := []rune(a), []rune(b)
+		row := make([]int, len(rb)+1)
+		for j := range row {
+			row[j] = j
+		}
+		for i := 1; i <= len(ra); i++ {
+			prev := row[0]
+			row[0] = i
+			for j := 1; j <= len(rb); j++ {
+				cost := 1
+				if ra[i-1] == rb[j-1] {
+					cost = 0
+				}
+				cur := row[j]
+				row[j] = minInt(row[j]+1, row[j-1]+1, prev+cost)
+				prev = cur
+			}
+		}
+		return row[len(rb)]
+	}
+
+	for _, fold := range []bool{false, true} {
+		r := New[interface{}](fold)
+		for _, w := range words {
+			r.Set(w, w)
+		}
+
+		for _, q := range []string{"", "a", "boo", "BOOK", "cat", "kitten", "sittin", "apfel", "strase", "xyz"} {
+			for d := -1; d <= 3; d++ {
+				var exp []string
+				r.Walk(func(k string, _ interface{}) bool {
+					if d >= 0 && levenshtein(q, k, fold) <= d {
+						exp = append(exp, k)
+					}
+					return false
+				})
+				if got := r.Fuzzy(q, d); !reflect.DeepEqual(got, exp) {
+					t.Fatalf("fold=%v: Fuzzy(%q, %d): expected %q, got %q", fold, q, d, exp, got)
+				}
+			}
+		}
+	}
+}
+
+// countingMatcher counts the calls to MatchPrefix of the wrapped Matcher.
+type countingMatcher struct {
+	Matcher
+	prefixCalls int
+}
+
+func (m *countingMatcher) MatchPrefix(prefix string) bool {
+	m.prefixCalls++
+	return m.Matcher.MatchPrefix(prefix)
+}
+
+func TestWalkGlob(t *testing.T) {
+	globRe := func(pattern string, fold bool) *regexp.Regexp {
+		var sb strings.Builder
+		sb.WriteString("(?s)")
+		if fold {
+			sb.WriteString("(?i)")
+		}
+		sb.WriteByte('^')
+		for _, r := range pattern {
+			switch r {
+			case '*':
+				sb.WriteString(".*")
+			case '?':
+				sb.WriteByte('.')
+			default:
+				sb.WriteString(regexp.QuoteMeta(string(r)))
+			}
+		}
+		sb.WriteByte('$')
+		return regexp.MustCompile(sb.String())
+	}
+
+	keys := []string{"", "foo", "foo/bar/baz", "foo/baz", "foo/qux/baz", "foo/qux/baz/x", "Foo/ü/baz", "fo", "bar/baz", "ü", "üü"}
+	for i := 0; i < 200; i++ {
+		keys = append(keys, fmt.Sprintf("data/%d/file%d.txt", i%10, i))
+	}
+	patterns := []string{
+		"", "*", "?", "??", "foo", "foo/*", "foo/*/baz", "*/baz", "*baz*", "f?o/*", "FOO/*/BAZ", "foo/*/baz/?",
+		"data/1/*.txt", "data/?/file1?.txt", "*1?.txt", "**", "*?*", "ü*", "?ü", "x*",
+	}
+
+	for _, fold := range []bool{false, true} {
+		r := New[interface{}](fold)
+		for _, k := range keys {
+			r.Set(k, k)
+		}
+		for _, p := range patterns {
+			re := globRe(p, fold)
+			var exp, got []string
+			r.Walk(func(k string, _ interface{}) bool {
+				if re.MatchString(k) {
+					exp = append(exp, k)
+				}
+				return false
+			})
+			r.WalkGlob(p, func(k string, _ interface{}) bool {
+				got = append(got, k)
+				return false
+			})
+			if !reflect.DeepEqual(got, exp) {
+				t.Fatalf("fold=%v: WalkGlob(%q): expected %q, got %q", fold, p, exp, got)
+			}
+		}
+	}
+
+	r := New[interface{}](false)
+	for _, k := range keys {
+		r.Set(k, k)
+	}
+	var nodes int
+	walkNodes(&r.root, func(*node[interface{}]) { nodes++ })
+
+	m := &countingMatcher{Matcher: globPattern{pattern: "data/3/*.txt"}}
+	var n int
+	r.WalkMatch(m, func(string, interface{}) bool {
+		n++
+		return false
+	})
+	if n != 20 || m.prefixCalls > nodes/4 {
+		t.Fatalf("expected 20 keys with pruning, got %d keys and %d prefix calls for %d nodes", n, m.prefixCalls, nodes)
+	}
+}
+
+func TestWalkApply(t *testing.T) {
+	r := New[interface{}](false)
+	for i, k := range []string{"c", "a", "e", "b", "d", "f"} {
+		r.Set(k, i)
+	}
+
+	errOdd := errors.New("odd value")
+	errs := r.WalkApply(func(k string, v interface{}) error {
+		if v.(int)%2 == 1 {
+			return errOdd
+		}
+		return nil
+	})
+
+	var keys []string
+	for _, err := range errs {
+		var ke *KeyError
+		if !errors.As(err, &ke) || !errors.Is(err, errOdd) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		keys = append(keys, ke.Key)
+	}
+	if exp := []string{"a", "b", "f"}; !reflect.DeepEqual(keys, exp) {
+		t.Fatalf("expected %q, got %q", exp, keys)
+	}
+
+	if errs := r.WalkApply(func(string, interface{}) error { return nil }); errs != nil {
+		t.Fatalf("expected no errors, got %v", errs)
+	}
+}
+
+func TestWalkStacks(t *testing.T) {
+	wide, small := New[interface{}](false), New[interface{}](false)
+	for i := 0; i < 5000; i++ {
+		wide.Set(fmt.Sprintf("%c", 0x100+i), i)
+	}
+	small.Set("a", 1)
+	small.Set("b", 2)
+
+	for i := 0; i < 10; i++ {
+		if n := countNode(&wide.root); n != 5000 {
+			t.Fatalf("expected 5000 keys, got %d", n)
+		}
+		if n := countNode(&small.root); n != 2 {
+			t.Fatalf("expected 2 keys, got %d", n)
+		}
+	}
+
+	// pooled stacks are small and don't hold on to nodes
+	for i := 0; i < 10; i++ {
+		sp := walkStacks.Get().(*[]interface{})
+		stack := *sp
+		if cap(stack) > maxPooledStack {
+			t.Fatalf("pooled stack with a capacity of %d", cap(stack))
+		}
+		for _, v := range stack[:cap(stack)] {
+			if v != nil {
+				t.Fatalf("pooled stack holding %v", v)
+			}
+		}
+	}
+}
+
+func TestWalkDeep(t *testing.T) {
+	const depth = 20000
+
+	// every key is a prefix of the next one, which creates a node per byte,
+	// the keys share the same backing string to keep the memory usage sane.
+	s := strings.Repeat("a", depth)
+	keys := make([]string, depth)
+	values := make([]interface{}, depth)
+	for i := range keys {
+		keys[i], values[i] = s[:i+1], i
+	}
+
+	r := New[interface{}](false, WithNodePool())
+	if err := r.SetSorted(keys, values); err != nil {
+		t.Fatal(err)
+	}
+	if r.Len() != depth {
+		t.Fatalf("bad len: %v", r.Len())
+	}
+
+	var n int
+	r.Walk(func(k string, v interface{}) bool {
+		if k != keys[n] || v != n {
+			t.Fatalf("expected %d, got %d", n, len(k))
+		}
+		n++
+		return false
+	})
+	if n != depth {
+		t.Fatalf("expected %d keys, got %d", depth, n)
+	}
+
+	n = 0
+	if !r.WalkPrefix(s[:depth/2], func(k string, v interface{}) bool {
+		n++
+		return len(k) == depth-1
+	}) || n != depth/2 {
+		t.Fatalf("expected the walk to abort after %d keys, got %d", depth/2, n)
+	}
+
+	if es := r.LargestN(2); len(es) != 2 || es[0].Key != keys[depth-1] || es[1].Key != keys[depth-2] {
+		t.Fatalf("unexpected LargestN: %d", len(es))
+	}
+
+	// the node walks don't recurse either
+	cp := r.Clone()
+	if err := cp.Validate(); err != nil || cp.Len() != depth {
+		t.Fatalf("bad clone: %v %d", err, cp.Len())
+	}
+	if n := cp.Repair(); n != 0 {
+		t.Fatalf("expected no fixes, got %d", n)
+	}
+	if h := cp.LeafDepthHistogram(); len(h) != depth || h[1] != 1 || h[depth] != 1 {
+		t.Fatalf("unexpected histogram with %d depths", len(h))
+	}
+	if n := r.DeletePrefix(s[:1]); n != depth || r.Len() != 0 {
+		t.Fatalf("expected %d deleted keys, got %d", depth, n)
+	}
+	if cp.Len() != depth {
+		t.Fatal("deleting from the tree modified its clone")
+	}
+}
+
+func TestWalkNested(t *testing.T) {
+	r := New[interface{}](false)
+	for _, k := range []string{"a", "a/b", "a/c", "b", "b/a"} {
+		r.Set(k, nil)
+	}
+
+	var outer, inner int
+	r.Walk(func(k string, _ interface{}) bool {
+		outer++
+		r.WalkPrefix(k, func(string, interface{}) bool {
+			inner++
+			return false
+		})
+		return false
+	})
+	if outer != 5 || inner != 8 {
+		t.Fatalf("bad counts: %v %v", outer, inner)
+	}
+
+	sp := walkStacks.Get().(*[]interface{})
+	for _, n := range (*sp)[:cap(*sp)] {
+		if n != nil {
+			t.Fatal("pooled stack holds a node")
+		}
+	}
+}
+
 func TestWalkPath(t *testing.T) {
 	r := New[interface{}](true)
 
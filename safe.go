@@ -4,8 +4,10 @@
 package radix
 
 import (
+	"encoding/json"
 	"io"
 	"sync"
+	"time"
 )
 
 // Safe returns a concurrency-safe version of the tree.
@@ -17,9 +19,10 @@ func (t *Tree[VT]) Safe() *SafeTree[VT] {
 }
 
 // NewSafe returns a concurrency-safe radix tree.
-func NewSafe[VT any](caseInsensitive bool) *SafeTree[VT] {
+func NewSafe[VT any](caseInsensitive bool, opts ...Option) *SafeTree[VT] {
 	var lt SafeTree[VT]
 	lt.t.fold = caseInsensitive
+	lt.t.init(opts)
 	return &lt
 }
 
@@ -36,6 +39,60 @@ func (lt *SafeTree[VT]) Update(fn func(t *Tree[VT])) {
 	fn(&lt.t)
 }
 
+// UpdateKey calls Tree.Update under the write lock.
+// It is *NOT* safe to call lt inside fn.
+func (lt *SafeTree[VT]) UpdateKey(key string, fn func(old VT, found bool) (VT, bool)) {
+	lt.m.Lock()
+	defer lt.m.Unlock()
+	lt.t.Update(key, fn)
+}
+
+func (lt *SafeTree[VT]) Subtree(prefix string) (t *Tree[VT], found bool) {
+	lt.m.RLock()
+	t, found = lt.t.Subtree(prefix)
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree[VT]) SubtreeStripped(prefix string) (t *Tree[VT], found bool) {
+	lt.m.RLock()
+	t, found = lt.t.SubtreeStripped(prefix)
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree[VT]) Filter(pred func(key string, v VT) bool) (t *Tree[VT]) {
+	lt.m.RLock()
+	t = lt.t.Filter(pred)
+	lt.m.RUnlock()
+	return
+}
+
+// Snapshot returns a copy of the underlying tree that can be read without any locks,
+// it doesn't reflect any writes made after it was taken.
+func (lt *SafeTree[VT]) Snapshot() (t *Tree[VT]) {
+	lt.m.RLock()
+	t = lt.t.Clone()
+	lt.m.RUnlock()
+	return
+}
+
+// Transaction calls fn with a copy of the tree under the write lock, if fn returns nil, the copy replaces the tree,
+// otherwise the changes are discarded and the error is returned.
+// Unlike Update, the tree is left untouched if fn fails or panics.
+func (lt *SafeTree[VT]) Transaction(fn func(t *Tree[VT]) error) error {
+	lt.m.Lock()
+	defer lt.m.Unlock()
+
+	nt := lt.t.Clone()
+	nt.metrics = lt.t.metrics
+	if err := fn(nt); err != nil {
+		return err
+	}
+	lt.t = *nt
+	return nil
+}
+
 func (lt *SafeTree[VT]) Set(key string, value VT) (old VT, found bool) {
 	lt.m.Lock()
 	old, found = lt.t.Set(key, value)
@@ -43,6 +100,87 @@ func (lt *SafeTree[VT]) Set(key string, value VT) (old VT, found bool) {
 	return
 }
 
+func (lt *SafeTree[VT]) SetFold(key string, value VT) (old VT, found, collided bool) {
+	lt.m.Lock()
+	old, found, collided = lt.t.SetFold(key, value)
+	lt.m.Unlock()
+	return
+}
+
+// GetOrSet is like Tree.GetOrSet, it's atomic with regards to other calls on the tree.
+func (lt *SafeTree[VT]) GetOrSet(key string, value VT) (actual VT, loaded bool) {
+	lt.m.Lock()
+	actual, loaded = lt.t.GetOrSet(key, value)
+	lt.m.Unlock()
+	return
+}
+
+func (lt *SafeTree[VT]) SetIfAbsent(key string, value VT) (actual VT, set bool) {
+	lt.m.Lock()
+	actual, set = lt.t.SetIfAbsent(key, value)
+	lt.m.Unlock()
+	return
+}
+
+// CompareAndSwap sets key to new only if its current value equals old according to eq,
+// it returns false if key doesn't exist.
+func (lt *SafeTree[VT]) CompareAndSwap(key string, old, new VT, eq func(a, b VT) bool) (swapped bool) {
+	lt.m.Lock()
+	if l := lt.t.getLeaf(key); l != nil && eq(l.Value, old) {
+		lt.t.Set(l.Key, new)
+		swapped = true
+	}
+	lt.m.Unlock()
+	return
+}
+
+func (lt *SafeTree[VT]) SetInfo(key string, value VT) (old VT, found, prefixKey bool) {
+	lt.m.Lock()
+	old, found, prefixKey = lt.t.SetInfo(key, value)
+	lt.m.Unlock()
+	return
+}
+
+func (lt *SafeTree[VT]) SetSorted(keys []string, values []VT) (err error) {
+	lt.m.Lock()
+	err = lt.t.SetSorted(keys, values)
+	lt.m.Unlock()
+	return
+}
+
+// FoldKey doesn't lock, the case sensitivity of the tree never changes.
+func (lt *SafeTree[VT]) FoldKey(key string) string {
+	return lt.t.FoldKey(key)
+}
+
+func (lt *SafeTree[VT]) GetExact(key string) (val VT, found bool) {
+	lt.m.RLock()
+	val, found = lt.t.GetExact(key)
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree[VT]) GetBytes(key []byte) (val VT, found bool) {
+	lt.m.RLock()
+	val, found = lt.t.GetBytes(key)
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree[VT]) SetBytes(key []byte, value VT) (old VT, found bool) {
+	lt.m.Lock()
+	old, found = lt.t.SetBytes(key, value)
+	lt.m.Unlock()
+	return
+}
+
+func (lt *SafeTree[VT]) DeleteBytes(key []byte) (old VT, found bool) {
+	lt.m.Lock()
+	old, found = lt.t.DeleteBytes(key)
+	lt.m.Unlock()
+	return
+}
+
 func (lt *SafeTree[VT]) Delete(key string) (old VT, found bool) {
 	lt.m.Lock()
 	old, found = lt.t.Delete(key)
@@ -50,6 +188,21 @@ func (lt *SafeTree[VT]) Delete(key string) (old VT, found bool) {
 	return
 }
 
+func (lt *SafeTree[VT]) DeleteInfo(key string) (old VT, found, merged bool) {
+	lt.m.Lock()
+	old, found, merged = lt.t.DeleteInfo(key)
+	lt.m.Unlock()
+	return
+}
+
+// DeleteMany holds the write lock for the whole batch.
+func (lt *SafeTree[VT]) DeleteMany(keys []string) (count int) {
+	lt.m.Lock()
+	count = lt.t.DeleteMany(keys)
+	lt.m.Unlock()
+	return
+}
+
 func (lt *SafeTree[VT]) DeletePrefix(prefix string) (count int) {
 	lt.m.Lock()
 	count = lt.t.DeletePrefix(prefix)
@@ -57,6 +210,30 @@ func (lt *SafeTree[VT]) DeletePrefix(prefix string) (count int) {
 	return
 }
 
+// DeletePrefixFn
+// It is *NOT* safe to modify the tree inside fn.
+func (lt *SafeTree[VT]) DeletePrefixFn(prefix string, fn func(key string, v VT)) int {
+	lt.m.Lock()
+	defer lt.m.Unlock()
+	return lt.t.DeletePrefixFn(prefix, fn)
+}
+
+// DeletePrefixKeys atomically deletes the subtree under prefix and returns the deleted keys.
+func (lt *SafeTree[VT]) DeletePrefixKeys(prefix string) (keys []string) {
+	lt.m.Lock()
+	keys = lt.t.DeletePrefixKeys(prefix)
+	lt.m.Unlock()
+	return
+}
+
+// DrainPrefix atomically deletes the subtree under prefix and returns the deleted entries.
+func (lt *SafeTree[VT]) DrainPrefix(prefix string) (out map[string]VT) {
+	lt.m.Lock()
+	out = lt.t.DrainPrefix(prefix)
+	lt.m.Unlock()
+	return
+}
+
 func (lt *SafeTree[VT]) Get(key string) (val VT, found bool) {
 	lt.m.RLock()
 	val, found = lt.t.Get(key)
@@ -64,6 +241,27 @@ func (lt *SafeTree[VT]) Get(key string) (val VT, found bool) {
 	return
 }
 
+func (lt *SafeTree[VT]) KeyForValue(v VT, eq func(a, b VT) bool) (key string, found bool) {
+	lt.m.RLock()
+	key, found = lt.t.KeyForValue(v, eq)
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree[VT]) AnyPrefixOf(s string) (found bool) {
+	lt.m.RLock()
+	found = lt.t.AnyPrefixOf(s)
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree[VT]) PrefixChain(s string) (out []Entry[VT]) {
+	lt.m.RLock()
+	out = lt.t.PrefixChain(s)
+	lt.m.RUnlock()
+	return
+}
+
 func (lt *SafeTree[VT]) LongestPrefix(prefix string) (key string, val VT, found bool) {
 	lt.m.RLock()
 	key, val, found = lt.t.LongestPrefix(prefix)
@@ -71,6 +269,46 @@ func (lt *SafeTree[VT]) LongestPrefix(prefix string) (key string, val VT, found
 	return
 }
 
+func (lt *SafeTree[VT]) LongestPrefixDiverge(s string) (matched string, divergedAt int, v VT, found bool) {
+	lt.m.RLock()
+	matched, divergedAt, v, found = lt.t.LongestPrefixDiverge(s)
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree[VT]) PrefixInfo(prefix string) (exists bool, isKey bool, descendants int) {
+	lt.m.RLock()
+	exists, isKey, descendants = lt.t.PrefixInfo(prefix)
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree[VT]) KeyLenRange() (min, max int) {
+	lt.m.RLock()
+	min, max = lt.t.KeyLenRange()
+	lt.m.RUnlock()
+	return
+}
+
+// Metrics doesn't need to lock, since the counters are atomic.
+func (lt *SafeTree[VT]) Metrics() TreeMetrics {
+	return lt.t.Metrics()
+}
+
+func (lt *SafeTree[VT]) Predecessor(key string) (k string, val VT, found bool) {
+	lt.m.RLock()
+	k, val, found = lt.t.Predecessor(key)
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree[VT]) Successor(key string) (k string, val VT, found bool) {
+	lt.m.RLock()
+	k, val, found = lt.t.Successor(key)
+	lt.m.RUnlock()
+	return
+}
+
 func (lt *SafeTree[VT]) Minimum() (key string, val VT, found bool) {
 	lt.m.RLock()
 	key, val, found = lt.t.Minimum()
@@ -85,6 +323,20 @@ func (lt *SafeTree[VT]) Maximum() (key string, val VT, found bool) {
 	return
 }
 
+func (lt *SafeTree[VT]) MinimumPrefix(prefix string) (key string, val VT, found bool) {
+	lt.m.RLock()
+	key, val, found = lt.t.MinimumPrefix(prefix)
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree[VT]) MaximumPrefix(prefix string) (key string, val VT, found bool) {
+	lt.m.RLock()
+	key, val, found = lt.t.MaximumPrefix(prefix)
+	lt.m.RUnlock()
+	return
+}
+
 func (lt *SafeTree[VT]) Len() (ln int) {
 	lt.m.RLock()
 	ln = lt.t.Len()
@@ -100,6 +352,159 @@ func (lt *SafeTree[VT]) Walk(fn WalkFn[VT]) bool {
 	return lt.t.Walk(fn)
 }
 
+// WalkRuns
+// It is *NOT* safe to modify the tree inside fn.
+func (lt *SafeTree[VT]) WalkRuns(eq func(a, b VT) bool, fn func(startKey, endKey string, v VT, count int) bool) bool {
+	lt.m.RLock()
+	defer lt.m.RUnlock()
+	return lt.t.WalkRuns(eq, fn)
+}
+
+// LeafKeys
+// It is *NOT* safe to modify the tree inside fn.
+func (lt *SafeTree[VT]) LeafKeys(fn WalkFn[VT]) bool {
+	lt.m.RLock()
+	defer lt.m.RUnlock()
+	return lt.t.LeafKeys(fn)
+}
+
+// WalkBuf
+// It is *NOT* safe to modify the tree inside fn.
+func (lt *SafeTree[VT]) WalkBuf(fn func(key []byte, v VT) bool) bool {
+	lt.m.RLock()
+	defer lt.m.RUnlock()
+	return lt.t.WalkBuf(fn)
+}
+
+// WalkCopy copies all the entries under the read lock, then calls fn on each after releasing it,
+// so a slow fn doesn't block writers and it's safe to modify the tree inside fn.
+// The copy costs a slice of Len() entries for the duration of the walk.
+func (lt *SafeTree[VT]) WalkCopy(fn WalkFn[VT]) bool {
+	lt.m.RLock()
+	entries := make([]Entry[VT], 0, lt.t.size)
+	lt.t.Walk(func(k string, v VT) bool {
+		entries = append(entries, Entry[VT]{k, v})
+		return false
+	})
+	lt.m.RUnlock()
+
+	for _, e := range entries {
+		if fn(e.Key, e.Value) {
+			return true
+		}
+	}
+	return false
+}
+
+// WalkRange
+// It is *NOT* safe to modify the tree inside fn.
+func (lt *SafeTree[VT]) WalkRange(from, to string, fn WalkFn[VT]) bool {
+	lt.m.RLock()
+	defer lt.m.RUnlock()
+	return lt.t.WalkRange(from, to, fn)
+}
+
+// WalkErrPrune
+// It is *NOT* safe to modify the tree inside fn.
+func (lt *SafeTree[VT]) WalkErrPrune(fn func(key string, v VT) (prune bool, err error)) error {
+	lt.m.RLock()
+	defer lt.m.RUnlock()
+	return lt.t.WalkErrPrune(fn)
+}
+
+func (lt *SafeTree[VT]) Complete(prefix string, limit int) (keys []string) {
+	lt.m.RLock()
+	keys = lt.t.Complete(prefix, limit)
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree[VT]) CompleteWithValues(prefix string, limit int) (out []Entry[VT]) {
+	lt.m.RLock()
+	out = lt.t.CompleteWithValues(prefix, limit)
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree[VT]) Fuzzy(query string, maxDist int) (keys []string) {
+	lt.m.RLock()
+	keys = lt.t.Fuzzy(query, maxDist)
+	lt.m.RUnlock()
+	return
+}
+
+// WalkN
+// It is *NOT* safe to modify the tree inside fn.
+func (lt *SafeTree[VT]) WalkN(n int, fn WalkFn[VT]) bool {
+	lt.m.RLock()
+	defer lt.m.RUnlock()
+	return lt.t.WalkN(n, fn)
+}
+
+// WalkPrefixN
+// It is *NOT* safe to modify the tree inside fn.
+func (lt *SafeTree[VT]) WalkPrefixN(prefix string, n int, fn WalkFn[VT]) bool {
+	lt.m.RLock()
+	defer lt.m.RUnlock()
+	return lt.t.WalkPrefixN(prefix, n, fn)
+}
+
+// WalkDepth
+// It is *NOT* safe to modify the tree inside fn.
+func (lt *SafeTree[VT]) WalkDepth(fn func(key string, v VT, depth int) bool) bool {
+	lt.m.RLock()
+	defer lt.m.RUnlock()
+	return lt.t.WalkDepth(fn)
+}
+
+// WalkParallel
+// It is *NOT* safe to modify the tree inside fn.
+func (lt *SafeTree[VT]) WalkParallel(workers int, fn WalkFn[VT]) bool {
+	lt.m.RLock()
+	defer lt.m.RUnlock()
+	return lt.t.WalkParallel(workers, fn)
+}
+
+// WalkMatch
+// It is *NOT* safe to modify the tree inside fn.
+func (lt *SafeTree[VT]) WalkMatch(m Matcher, fn WalkFn[VT]) bool {
+	lt.m.RLock()
+	defer lt.m.RUnlock()
+	return lt.t.WalkMatch(m, fn)
+}
+
+// WalkGlob
+// It is *NOT* safe to modify the tree inside fn.
+func (lt *SafeTree[VT]) WalkGlob(pattern string, fn WalkFn[VT]) bool {
+	lt.m.RLock()
+	defer lt.m.RUnlock()
+	return lt.t.WalkGlob(pattern, fn)
+}
+
+// WalkApply
+// It is *NOT* safe to modify the tree inside fn.
+func (lt *SafeTree[VT]) WalkApply(fn func(key string, v VT) error) []error {
+	lt.m.RLock()
+	defer lt.m.RUnlock()
+	return lt.t.WalkApply(fn)
+}
+
+// WalkValues
+// It is *NOT* safe to modify the tree inside fn.
+func (lt *SafeTree[VT]) WalkValues(pred func(VT) bool, fn WalkFn[VT]) bool {
+	lt.m.RLock()
+	defer lt.m.RUnlock()
+	return lt.t.WalkValues(pred, fn)
+}
+
+// WalkPrefixValues
+// It is *NOT* safe to modify the tree inside fn.
+func (lt *SafeTree[VT]) WalkPrefixValues(prefix string, pred func(VT) bool, fn WalkFn[VT]) bool {
+	lt.m.RLock()
+	defer lt.m.RUnlock()
+	return lt.t.WalkPrefixValues(prefix, pred, fn)
+}
+
 // WalkPrefix
 // It is *NOT* safe to modify the tree inside fn.
 func (lt *SafeTree[VT]) WalkPrefix(prefix string, fn WalkFn[VT]) bool {
@@ -116,6 +521,14 @@ func (lt *SafeTree[VT]) WalkPath(path string, fn WalkFn[VT]) bool {
 	return lt.t.WalkPath(path, fn)
 }
 
+// WalkPathSkipRoot
+// It is *NOT* safe to modify the tree inside fn.
+func (lt *SafeTree[VT]) WalkPathSkipRoot(path string, fn WalkFn[VT]) bool {
+	lt.m.RLock()
+	defer lt.m.RUnlock()
+	return lt.t.WalkPathSkipRoot(path, fn)
+}
+
 // WalkNearestPath
 // It is *NOT* safe to modify the tree inside fn.
 func (lt *SafeTree[VT]) WalkNearestPath(path string, fn WalkFn[VT]) bool {
@@ -130,6 +543,12 @@ func (lt *SafeTree[VT]) MergeMap(m map[string]VT) {
 	lt.m.Unlock()
 }
 
+func (lt *SafeTree[VT]) MergeMapSorted(m map[string]VT) {
+	lt.m.Lock()
+	lt.t.MergeMapSorted(m)
+	lt.m.Unlock()
+}
+
 func (lt *SafeTree[VT]) MergeTree(t *Tree[VT]) {
 	lt.m.Lock()
 	lt.t.Merge(t)
@@ -142,25 +561,235 @@ func (lt *SafeTree[VT]) Merge(ot *SafeTree[VT]) {
 	lt.MergeTree(&ot.t)
 }
 
-func (lt *SafeTree[VT]) ToMap() map[string]VT {
+// Apply atomically applies a set of changes to the tree, see Tree.Apply.
+func (lt *SafeTree[VT]) Apply(changes map[string]*VT) (upserted, deleted int) {
+	lt.m.Lock()
+	upserted, deleted = lt.t.Apply(changes)
+	lt.m.Unlock()
+	return
+}
+
+func (lt *SafeTree[VT]) Validate() error {
 	lt.m.RLock()
-	out := make(map[string]VT, lt.t.size)
-	lt.t.Walk(func(k string, v VT) bool {
-		out[k] = v
-		return false
-	})
+	defer lt.m.RUnlock()
+	return lt.t.Validate()
+}
+
+func (lt *SafeTree[VT]) Repair() (fixes int) {
+	lt.m.Lock()
+	fixes = lt.t.Repair()
+	lt.m.Unlock()
+	return
+}
+
+func (lt *SafeTree[VT]) RenameKeys(fn func(old string) (new string, keep bool)) (changed int) {
+	lt.m.Lock()
+	changed = lt.t.RenameKeys(fn)
+	lt.m.Unlock()
+	return
+}
+
+func (lt *SafeTree[VT]) Partitions(n int) []string {
+	lt.m.RLock()
+	defer lt.m.RUnlock()
+	return lt.t.Partitions(n)
+}
+
+func (lt *SafeTree[VT]) Prune(isEmpty func(VT) bool) (n int) {
+	lt.m.Lock()
+	n = lt.t.Prune(isEmpty)
+	lt.m.Unlock()
+	return
+}
+
+// ForEachSafe runs under the write lock, changes are made through fn's return values.
+// It is *NOT* safe to call lt inside fn.
+func (lt *SafeTree[VT]) ForEachSafe(fn func(key string, v VT) (newVal VT, keep bool, changed bool)) {
+	lt.m.Lock()
+	lt.t.ForEachSafe(fn)
+	lt.m.Unlock()
+}
+
+func (lt *SafeTree[VT]) SmallestN(n int) (out []Entry[VT]) {
+	lt.m.RLock()
+	out = lt.t.SmallestN(n)
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree[VT]) LargestN(n int) (out []Entry[VT]) {
+	lt.m.RLock()
+	out = lt.t.LargestN(n)
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree[VT]) Rebuild() {
+	lt.m.Lock()
+	lt.t.Rebuild()
+	lt.m.Unlock()
+}
+
+func (lt *SafeTree[VT]) ApproxSizeBytes() (sz int) {
+	lt.m.RLock()
+	sz = lt.t.ApproxSizeBytes()
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree[VT]) MarshalPrefix(w io.Writer, prefix string) (err error) {
+	lt.m.RLock()
+	err = lt.t.MarshalPrefix(w, prefix)
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree[VT]) LoadPrefix(r io.Reader, from, to string) (n int, err error) {
+	lt.m.Lock()
+	n, err = lt.t.LoadPrefix(r, from, to)
+	lt.m.Unlock()
+	return
+}
+
+func (lt *SafeTree[VT]) StructuralHealth() (score float64) {
+	lt.m.RLock()
+	score = lt.t.StructuralHealth()
 	lt.m.RUnlock()
-	return out
+	return
+}
+
+func (lt *SafeTree[VT]) ValuesPrefix(prefix string) (out []VT) {
+	lt.m.RLock()
+	out = lt.t.ValuesPrefix(prefix)
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree[VT]) LeafDepthHistogram() (h map[int]int) {
+	lt.m.RLock()
+	h = lt.t.LeafDepthHistogram()
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree[VT]) Stats() (st TreeStats) {
+	lt.m.RLock()
+	st = lt.t.Stats()
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree[VT]) Height() (h int) {
+	lt.m.RLock()
+	h = lt.t.Height()
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree[VT]) Keys() (out []string) {
+	lt.m.RLock()
+	out = lt.t.Keys()
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree[VT]) Values() (out []VT) {
+	lt.m.RLock()
+	out = lt.t.Values()
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree[VT]) Clear() {
+	lt.m.Lock()
+	lt.t.Clear()
+	lt.m.Unlock()
+}
+
+func (lt *SafeTree[VT]) MarshalJSONFunc(encodeValue func(VT) (json.RawMessage, error)) (data []byte, err error) {
+	lt.m.RLock()
+	data, err = lt.t.MarshalJSONFunc(encodeValue)
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree[VT]) UnmarshalJSONFunc(data []byte, decodeValue func(json.RawMessage) (VT, error)) (err error) {
+	lt.m.Lock()
+	err = lt.t.UnmarshalJSONFunc(data, decodeValue)
+	lt.m.Unlock()
+	return
+}
+
+func (lt *SafeTree[VT]) CountPrefix(prefix string) (n int) {
+	lt.m.RLock()
+	n = lt.t.CountPrefix(prefix)
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree[VT]) CountPrefixes(prefixes []string) (counts []int) {
+	lt.m.RLock()
+	counts = lt.t.CountPrefixes(prefixes)
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree[VT]) AgeOf(key string) (age time.Duration, found bool) {
+	lt.m.RLock()
+	age, found = lt.t.AgeOf(key)
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree[VT]) OlderThan(d time.Duration) (keys []string) {
+	lt.m.RLock()
+	keys = lt.t.OlderThan(d)
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree[VT]) ToMap() (out map[string]VT) {
+	lt.m.RLock()
+	out = lt.t.ToMap()
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree[VT]) ToMapPrefix(prefix string) (out map[string]VT) {
+	lt.m.RLock()
+	out = lt.t.ToMapPrefix(prefix)
+	lt.m.RUnlock()
+	return
 }
 
 func (lt *SafeTree[VT]) DumpTo(w io.Writer, asJSON bool) error {
 	lt.m.RLock()
-	defer lt.m.Unlock()
+	defer lt.m.RUnlock()
 	return lt.t.DumpTo(w, asJSON)
 }
 
+func (lt *SafeTree[VT]) WriteTo(w io.Writer) (n int64, err error) {
+	lt.m.RLock()
+	n, err = lt.t.WriteTo(w)
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree[VT]) DumpDOT(w io.Writer) (err error) {
+	lt.m.RLock()
+	err = lt.t.DumpDOT(w)
+	lt.m.RUnlock()
+	return
+}
+
 func (lt *SafeTree[VT]) Dump(asJSON bool) string {
 	lt.m.RLock()
-	defer lt.m.Unlock()
+	defer lt.m.RUnlock()
 	return lt.t.Dump(asJSON)
 }
+
+func (lt *SafeTree[VT]) String() string {
+	lt.m.RLock()
+	defer lt.m.RUnlock()
+	return lt.t.String()
+}
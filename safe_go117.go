@@ -4,8 +4,10 @@
 package radix
 
 import (
+	"encoding/json"
 	"io"
 	"sync"
+	"time"
 )
 
 // Safe returns a concurrency-safe version of the tree.
@@ -17,9 +19,10 @@ func (t *Tree) Safe() *SafeTree {
 }
 
 // NewSafe returns a concurrency-safe radix tree.
-func NewSafe(caseInsensitive bool) *SafeTree {
+func NewSafe(caseInsensitive bool, opts ...Option) *SafeTree {
 	var lt SafeTree
 	lt.t.fold = caseInsensitive
+	lt.t.init(opts)
 	return &lt
 }
 
@@ -36,6 +39,60 @@ func (lt *SafeTree) Update(fn func(t *Tree)) {
 	fn(&lt.t)
 }
 
+// UpdateKey calls Tree.Update under the write lock.
+// It is *NOT* safe to call lt inside fn.
+func (lt *SafeTree) UpdateKey(key string, fn func(old interface{}, found bool) (interface{}, bool)) {
+	lt.m.Lock()
+	defer lt.m.Unlock()
+	lt.t.Update(key, fn)
+}
+
+func (lt *SafeTree) Subtree(prefix string) (t *Tree, found bool) {
+	lt.m.RLock()
+	t, found = lt.t.Subtree(prefix)
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree) SubtreeStripped(prefix string) (t *Tree, found bool) {
+	lt.m.RLock()
+	t, found = lt.t.SubtreeStripped(prefix)
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree) Filter(pred func(key string, v interface{}) bool) (t *Tree) {
+	lt.m.RLock()
+	t = lt.t.Filter(pred)
+	lt.m.RUnlock()
+	return
+}
+
+// Snapshot returns a copy of the underlying tree that can be read without any locks,
+// it doesn't reflect any writes made after it was taken.
+func (lt *SafeTree) Snapshot() (t *Tree) {
+	lt.m.RLock()
+	t = lt.t.Clone()
+	lt.m.RUnlock()
+	return
+}
+
+// Transaction calls fn with a copy of the tree under the write lock, if fn returns nil, the copy replaces the tree,
+// otherwise the changes are discarded and the error is returned.
+// Unlike Update, the tree is left untouched if fn fails or panics.
+func (lt *SafeTree) Transaction(fn func(t *Tree) error) error {
+	lt.m.Lock()
+	defer lt.m.Unlock()
+
+	nt := lt.t.Clone()
+	nt.metrics = lt.t.metrics
+	if err := fn(nt); err != nil {
+		return err
+	}
+	lt.t = *nt
+	return nil
+}
+
 func (lt *SafeTree) Set(key string, value interface{}) (old interface{}, found bool) {
 	lt.m.Lock()
 	old, found = lt.t.Set(key, value)
@@ -43,6 +100,87 @@ func (lt *SafeTree) Set(key string, value interface{}) (old interface{}, found b
 	return
 }
 
+func (lt *SafeTree) SetFold(key string, value interface{}) (old interface{}, found, collided bool) {
+	lt.m.Lock()
+	old, found, collided = lt.t.SetFold(key, value)
+	lt.m.Unlock()
+	return
+}
+
+// GetOrSet is like Tree.GetOrSet, it's atomic with regards to other calls on the tree.
+func (lt *SafeTree) GetOrSet(key string, value interface{}) (actual interface{}, loaded bool) {
+	lt.m.Lock()
+	actual, loaded = lt.t.GetOrSet(key, value)
+	lt.m.Unlock()
+	return
+}
+
+func (lt *SafeTree) SetIfAbsent(key string, value interface{}) (actual interface{}, set bool) {
+	lt.m.Lock()
+	actual, set = lt.t.SetIfAbsent(key, value)
+	lt.m.Unlock()
+	return
+}
+
+// CompareAndSwap sets key to new only if its current value equals old according to eq,
+// it returns false if key doesn't exist.
+func (lt *SafeTree) CompareAndSwap(key string, old, new interface{}, eq func(a, b interface{}) bool) (swapped bool) {
+	lt.m.Lock()
+	if l := lt.t.getLeaf(key); l != nil && eq(l.Value, old) {
+		lt.t.Set(l.Key, new)
+		swapped = true
+	}
+	lt.m.Unlock()
+	return
+}
+
+func (lt *SafeTree) SetInfo(key string, value interface{}) (old interface{}, found, prefixKey bool) {
+	lt.m.Lock()
+	old, found, prefixKey = lt.t.SetInfo(key, value)
+	lt.m.Unlock()
+	return
+}
+
+func (lt *SafeTree) SetSorted(keys []string, values []interface{}) (err error) {
+	lt.m.Lock()
+	err = lt.t.SetSorted(keys, values)
+	lt.m.Unlock()
+	return
+}
+
+// FoldKey doesn't lock, the case sensitivity of the tree never changes.
+func (lt *SafeTree) FoldKey(key string) string {
+	return lt.t.FoldKey(key)
+}
+
+func (lt *SafeTree) GetExact(key string) (val interface{}, found bool) {
+	lt.m.RLock()
+	val, found = lt.t.GetExact(key)
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree) GetBytes(key []byte) (val interface{}, found bool) {
+	lt.m.RLock()
+	val, found = lt.t.GetBytes(key)
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree) SetBytes(key []byte, value interface{}) (old interface{}, found bool) {
+	lt.m.Lock()
+	old, found = lt.t.SetBytes(key, value)
+	lt.m.Unlock()
+	return
+}
+
+func (lt *SafeTree) DeleteBytes(key []byte) (old interface{}, found bool) {
+	lt.m.Lock()
+	old, found = lt.t.DeleteBytes(key)
+	lt.m.Unlock()
+	return
+}
+
 func (lt *SafeTree) Delete(key string) (old interface{}, found bool) {
 	lt.m.Lock()
 	old, found = lt.t.Delete(key)
@@ -50,6 +188,21 @@ func (lt *SafeTree) Delete(key string) (old interface{}, found bool) {
 	return
 }
 
+func (lt *SafeTree) DeleteInfo(key string) (old interface{}, found, merged bool) {
+	lt.m.Lock()
+	old, found, merged = lt.t.DeleteInfo(key)
+	lt.m.Unlock()
+	return
+}
+
+// DeleteMany holds the write lock for the whole batch.
+func (lt *SafeTree) DeleteMany(keys []string) (count int) {
+	lt.m.Lock()
+	count = lt.t.DeleteMany(keys)
+	lt.m.Unlock()
+	return
+}
+
 func (lt *SafeTree) DeletePrefix(prefix string) (count int) {
 	lt.m.Lock()
 	count = lt.t.DeletePrefix(prefix)
@@ -57,6 +210,30 @@ func (lt *SafeTree) DeletePrefix(prefix string) (count int) {
 	return
 }
 
+// DeletePrefixFn
+// It is *NOT* safe to modify the tree inside fn.
+func (lt *SafeTree) DeletePrefixFn(prefix string, fn func(key string, v interface{})) int {
+	lt.m.Lock()
+	defer lt.m.Unlock()
+	return lt.t.DeletePrefixFn(prefix, fn)
+}
+
+// DeletePrefixKeys atomically deletes the subtree under prefix and returns the deleted keys.
+func (lt *SafeTree) DeletePrefixKeys(prefix string) (keys []string) {
+	lt.m.Lock()
+	keys = lt.t.DeletePrefixKeys(prefix)
+	lt.m.Unlock()
+	return
+}
+
+// DrainPrefix atomically deletes the subtree under prefix and returns the deleted entries.
+func (lt *SafeTree) DrainPrefix(prefix string) (out map[string]interface{}) {
+	lt.m.Lock()
+	out = lt.t.DrainPrefix(prefix)
+	lt.m.Unlock()
+	return
+}
+
 func (lt *SafeTree) Get(key string) (val interface{}, found bool) {
 	lt.m.RLock()
 	val, found = lt.t.Get(key)
@@ -64,6 +241,27 @@ func (lt *SafeTree) Get(key string) (val interface{}, found bool) {
 	return
 }
 
+func (lt *SafeTree) KeyForValue(v interface{}, eq func(a, b interface{}) bool) (key string, found bool) {
+	lt.m.RLock()
+	key, found = lt.t.KeyForValue(v, eq)
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree) AnyPrefixOf(s string) (found bool) {
+	lt.m.RLock()
+	found = lt.t.AnyPrefixOf(s)
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree) PrefixChain(s string) (out []Entry) {
+	lt.m.RLock()
+	out = lt.t.PrefixChain(s)
+	lt.m.RUnlock()
+	return
+}
+
 func (lt *SafeTree) LongestPrefix(prefix string) (key string, val interface{}, found bool) {
 	lt.m.RLock()
 	key, val, found = lt.t.LongestPrefix(prefix)
@@ -71,6 +269,46 @@ func (lt *SafeTree) LongestPrefix(prefix string) (key string, val interface{}, f
 	return
 }
 
+func (lt *SafeTree) LongestPrefixDiverge(s string) (matched string, divergedAt int, v interface{}, found bool) {
+	lt.m.RLock()
+	matched, divergedAt, v, found = lt.t.LongestPrefixDiverge(s)
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree) PrefixInfo(prefix string) (exists bool, isKey bool, descendants int) {
+	lt.m.RLock()
+	exists, isKey, descendants = lt.t.PrefixInfo(prefix)
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree) KeyLenRange() (min, max int) {
+	lt.m.RLock()
+	min, max = lt.t.KeyLenRange()
+	lt.m.RUnlock()
+	return
+}
+
+// Metrics doesn't need to lock, since the counters are atomic.
+func (lt *SafeTree) Metrics() TreeMetrics {
+	return lt.t.Metrics()
+}
+
+func (lt *SafeTree) Predecessor(key string) (k string, val interface{}, found bool) {
+	lt.m.RLock()
+	k, val, found = lt.t.Predecessor(key)
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree) Successor(key string) (k string, val interface{}, found bool) {
+	lt.m.RLock()
+	k, val, found = lt.t.Successor(key)
+	lt.m.RUnlock()
+	return
+}
+
 func (lt *SafeTree) Minimum() (key string, val interface{}, found bool) {
 	lt.m.RLock()
 	key, val, found = lt.t.Minimum()
@@ -85,6 +323,20 @@ func (lt *SafeTree) Maximum() (key string, val interface{}, found bool) {
 	return
 }
 
+func (lt *SafeTree) MinimumPrefix(prefix string) (key string, val interface{}, found bool) {
+	lt.m.RLock()
+	key, val, found = lt.t.MinimumPrefix(prefix)
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree) MaximumPrefix(prefix string) (key string, val interface{}, found bool) {
+	lt.m.RLock()
+	key, val, found = lt.t.MaximumPrefix(prefix)
+	lt.m.RUnlock()
+	return
+}
+
 func (lt *SafeTree) Len() (ln int) {
 	lt.m.RLock()
 	ln = lt.t.Len()
@@ -100,6 +352,159 @@ func (lt *SafeTree) Walk(fn WalkFn) bool {
 	return lt.t.Walk(fn)
 }
 
+// WalkRuns
+// It is *NOT* safe to modify the tree inside fn.
+func (lt *SafeTree) WalkRuns(eq func(a, b interface{}) bool, fn func(startKey, endKey string, v interface{}, count int) bool) bool {
+	lt.m.RLock()
+	defer lt.m.RUnlock()
+	return lt.t.WalkRuns(eq, fn)
+}
+
+// LeafKeys
+// It is *NOT* safe to modify the tree inside fn.
+func (lt *SafeTree) LeafKeys(fn WalkFn) bool {
+	lt.m.RLock()
+	defer lt.m.RUnlock()
+	return lt.t.LeafKeys(fn)
+}
+
+// WalkBuf
+// It is *NOT* safe to modify the tree inside fn.
+func (lt *SafeTree) WalkBuf(fn func(key []byte, v interface{}) bool) bool {
+	lt.m.RLock()
+	defer lt.m.RUnlock()
+	return lt.t.WalkBuf(fn)
+}
+
+// WalkCopy copies all the entries under the read lock, then calls fn on each after releasing it,
+// so a slow fn doesn't block writers and it's safe to modify the tree inside fn.
+// The copy costs a slice of Len() entries for the duration of the walk.
+func (lt *SafeTree) WalkCopy(fn WalkFn) bool {
+	lt.m.RLock()
+	entries := make([]Entry, 0, lt.t.size)
+	lt.t.Walk(func(k string, v interface{}) bool {
+		entries = append(entries, Entry{k, v})
+		return false
+	})
+	lt.m.RUnlock()
+
+	for _, e := range entries {
+		if fn(e.Key, e.Value) {
+			return true
+		}
+	}
+	return false
+}
+
+// WalkRange
+// It is *NOT* safe to modify the tree inside fn.
+func (lt *SafeTree) WalkRange(from, to string, fn WalkFn) bool {
+	lt.m.RLock()
+	defer lt.m.RUnlock()
+	return lt.t.WalkRange(from, to, fn)
+}
+
+// WalkErrPrune
+// It is *NOT* safe to modify the tree inside fn.
+func (lt *SafeTree) WalkErrPrune(fn func(key string, v interface{}) (prune bool, err error)) error {
+	lt.m.RLock()
+	defer lt.m.RUnlock()
+	return lt.t.WalkErrPrune(fn)
+}
+
+func (lt *SafeTree) Complete(prefix string, limit int) (keys []string) {
+	lt.m.RLock()
+	keys = lt.t.Complete(prefix, limit)
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree) CompleteWithValues(prefix string, limit int) (out []Entry) {
+	lt.m.RLock()
+	out = lt.t.CompleteWithValues(prefix, limit)
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree) Fuzzy(query string, maxDist int) (keys []string) {
+	lt.m.RLock()
+	keys = lt.t.Fuzzy(query, maxDist)
+	lt.m.RUnlock()
+	return
+}
+
+// WalkN
+// It is *NOT* safe to modify the tree inside fn.
+func (lt *SafeTree) WalkN(n int, fn WalkFn) bool {
+	lt.m.RLock()
+	defer lt.m.RUnlock()
+	return lt.t.WalkN(n, fn)
+}
+
+// WalkPrefixN
+// It is *NOT* safe to modify the tree inside fn.
+func (lt *SafeTree) WalkPrefixN(prefix string, n int, fn WalkFn) bool {
+	lt.m.RLock()
+	defer lt.m.RUnlock()
+	return lt.t.WalkPrefixN(prefix, n, fn)
+}
+
+// WalkDepth
+// It is *NOT* safe to modify the tree inside fn.
+func (lt *SafeTree) WalkDepth(fn func(key string, v interface{}, depth int) bool) bool {
+	lt.m.RLock()
+	defer lt.m.RUnlock()
+	return lt.t.WalkDepth(fn)
+}
+
+// WalkParallel
+// It is *NOT* safe to modify the tree inside fn.
+func (lt *SafeTree) WalkParallel(workers int, fn WalkFn) bool {
+	lt.m.RLock()
+	defer lt.m.RUnlock()
+	return lt.t.WalkParallel(workers, fn)
+}
+
+// WalkMatch
+// It is *NOT* safe to modify the tree inside fn.
+func (lt *SafeTree) WalkMatch(m Matcher, fn WalkFn) bool {
+	lt.m.RLock()
+	defer lt.m.RUnlock()
+	return lt.t.WalkMatch(m, fn)
+}
+
+// WalkGlob
+// It is *NOT* safe to modify the tree inside fn.
+func (lt *SafeTree) WalkGlob(pattern string, fn WalkFn) bool {
+	lt.m.RLock()
+	defer lt.m.RUnlock()
+	return lt.t.WalkGlob(pattern, fn)
+}
+
+// WalkApply
+// It is *NOT* safe to modify the tree inside fn.
+func (lt *SafeTree) WalkApply(fn func(key string, v interface{}) error) []error {
+	lt.m.RLock()
+	defer lt.m.RUnlock()
+	return lt.t.WalkApply(fn)
+}
+
+// WalkValues
+// It is *NOT* safe to modify the tree inside fn.
+func (lt *SafeTree) WalkValues(pred func(interface{}) bool, fn WalkFn) bool {
+	lt.m.RLock()
+	defer lt.m.RUnlock()
+	return lt.t.WalkValues(pred, fn)
+}
+
+// WalkPrefixValues
+// It is *NOT* safe to modify the tree inside fn.
+func (lt *SafeTree) WalkPrefixValues(prefix string, pred func(interface{}) bool, fn WalkFn) bool {
+	lt.m.RLock()
+	defer lt.m.RUnlock()
+	return lt.t.WalkPrefixValues(prefix, pred, fn)
+}
+
 // WalkPrefix
 // It is *NOT* safe to modify the tree inside fn.
 func (lt *SafeTree) WalkPrefix(prefix string, fn WalkFn) bool {
@@ -116,6 +521,14 @@ func (lt *SafeTree) WalkPath(path string, fn WalkFn) bool {
 	return lt.t.WalkPath(path, fn)
 }
 
+// WalkPathSkipRoot
+// It is *NOT* safe to modify the tree inside fn.
+func (lt *SafeTree) WalkPathSkipRoot(path string, fn WalkFn) bool {
+	lt.m.RLock()
+	defer lt.m.RUnlock()
+	return lt.t.WalkPathSkipRoot(path, fn)
+}
+
 // WalkNearestPath
 // It is *NOT* safe to modify the tree inside fn.
 func (lt *SafeTree) WalkNearestPath(path string, fn WalkFn) bool {
@@ -130,6 +543,12 @@ func (lt *SafeTree) MergeMap(m map[string]interface{}) {
 	lt.m.Unlock()
 }
 
+func (lt *SafeTree) MergeMapSorted(m map[string]interface{}) {
+	lt.m.Lock()
+	lt.t.MergeMapSorted(m)
+	lt.m.Unlock()
+}
+
 func (lt *SafeTree) MergeTree(t *Tree) {
 	lt.m.Lock()
 	lt.t.Merge(t)
@@ -142,25 +561,235 @@ func (lt *SafeTree) Merge(ot *SafeTree) {
 	lt.MergeTree(&ot.t)
 }
 
-func (lt *SafeTree) ToMap() map[string]interface{} {
+// Apply atomically applies a set of changes to the tree, see Tree.Apply.
+func (lt *SafeTree) Apply(changes map[string]*interface{}) (upserted, deleted int) {
+	lt.m.Lock()
+	upserted, deleted = lt.t.Apply(changes)
+	lt.m.Unlock()
+	return
+}
+
+func (lt *SafeTree) Validate() error {
 	lt.m.RLock()
-	out := make(map[string]interface{}, lt.t.size)
-	lt.t.Walk(func(k string, v interface{}) bool {
-		out[k] = v
-		return false
-	})
+	defer lt.m.RUnlock()
+	return lt.t.Validate()
+}
+
+func (lt *SafeTree) Repair() (fixes int) {
+	lt.m.Lock()
+	fixes = lt.t.Repair()
+	lt.m.Unlock()
+	return
+}
+
+func (lt *SafeTree) RenameKeys(fn func(old string) (new string, keep bool)) (changed int) {
+	lt.m.Lock()
+	changed = lt.t.RenameKeys(fn)
+	lt.m.Unlock()
+	return
+}
+
+func (lt *SafeTree) Partitions(n int) []string {
+	lt.m.RLock()
+	defer lt.m.RUnlock()
+	return lt.t.Partitions(n)
+}
+
+func (lt *SafeTree) Prune(isEmpty func(interface{}) bool) (n int) {
+	lt.m.Lock()
+	n = lt.t.Prune(isEmpty)
+	lt.m.Unlock()
+	return
+}
+
+// ForEachSafe runs under the write lock, changes are made through fn's return values.
+// It is *NOT* safe to call lt inside fn.
+func (lt *SafeTree) ForEachSafe(fn func(key string, v interface{}) (newVal interface{}, keep bool, changed bool)) {
+	lt.m.Lock()
+	lt.t.ForEachSafe(fn)
+	lt.m.Unlock()
+}
+
+func (lt *SafeTree) SmallestN(n int) (out []Entry) {
+	lt.m.RLock()
+	out = lt.t.SmallestN(n)
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree) LargestN(n int) (out []Entry) {
+	lt.m.RLock()
+	out = lt.t.LargestN(n)
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree) Rebuild() {
+	lt.m.Lock()
+	lt.t.Rebuild()
+	lt.m.Unlock()
+}
+
+func (lt *SafeTree) ApproxSizeBytes() (sz int) {
+	lt.m.RLock()
+	sz = lt.t.ApproxSizeBytes()
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree) MarshalPrefix(w io.Writer, prefix string) (err error) {
+	lt.m.RLock()
+	err = lt.t.MarshalPrefix(w, prefix)
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree) LoadPrefix(r io.Reader, from, to string) (n int, err error) {
+	lt.m.Lock()
+	n, err = lt.t.LoadPrefix(r, from, to)
+	lt.m.Unlock()
+	return
+}
+
+func (lt *SafeTree) StructuralHealth() (score float64) {
+	lt.m.RLock()
+	score = lt.t.StructuralHealth()
 	lt.m.RUnlock()
-	return out
+	return
+}
+
+func (lt *SafeTree) ValuesPrefix(prefix string) (out []interface{}) {
+	lt.m.RLock()
+	out = lt.t.ValuesPrefix(prefix)
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree) LeafDepthHistogram() (h map[int]int) {
+	lt.m.RLock()
+	h = lt.t.LeafDepthHistogram()
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree) Stats() (st TreeStats) {
+	lt.m.RLock()
+	st = lt.t.Stats()
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree) Height() (h int) {
+	lt.m.RLock()
+	h = lt.t.Height()
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree) Keys() (out []string) {
+	lt.m.RLock()
+	out = lt.t.Keys()
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree) Values() (out []interface{}) {
+	lt.m.RLock()
+	out = lt.t.Values()
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree) Clear() {
+	lt.m.Lock()
+	lt.t.Clear()
+	lt.m.Unlock()
+}
+
+func (lt *SafeTree) MarshalJSONFunc(encodeValue func(interface{}) (json.RawMessage, error)) (data []byte, err error) {
+	lt.m.RLock()
+	data, err = lt.t.MarshalJSONFunc(encodeValue)
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree) UnmarshalJSONFunc(data []byte, decodeValue func(json.RawMessage) (interface{}, error)) (err error) {
+	lt.m.Lock()
+	err = lt.t.UnmarshalJSONFunc(data, decodeValue)
+	lt.m.Unlock()
+	return
+}
+
+func (lt *SafeTree) CountPrefix(prefix string) (n int) {
+	lt.m.RLock()
+	n = lt.t.CountPrefix(prefix)
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree) CountPrefixes(prefixes []string) (counts []int) {
+	lt.m.RLock()
+	counts = lt.t.CountPrefixes(prefixes)
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree) AgeOf(key string) (age time.Duration, found bool) {
+	lt.m.RLock()
+	age, found = lt.t.AgeOf(key)
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree) OlderThan(d time.Duration) (keys []string) {
+	lt.m.RLock()
+	keys = lt.t.OlderThan(d)
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree) ToMap() (out map[string]interface{}) {
+	lt.m.RLock()
+	out = lt.t.ToMap()
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree) ToMapPrefix(prefix string) (out map[string]interface{}) {
+	lt.m.RLock()
+	out = lt.t.ToMapPrefix(prefix)
+	lt.m.RUnlock()
+	return
 }
 
 func (lt *SafeTree) DumpTo(w io.Writer, asJSON bool) error {
 	lt.m.RLock()
-	defer lt.m.Unlock()
+	defer lt.m.RUnlock()
 	return lt.t.DumpTo(w, asJSON)
 }
 
+func (lt *SafeTree) WriteTo(w io.Writer) (n int64, err error) {
+	lt.m.RLock()
+	n, err = lt.t.WriteTo(w)
+	lt.m.RUnlock()
+	return
+}
+
+func (lt *SafeTree) DumpDOT(w io.Writer) (err error) {
+	lt.m.RLock()
+	err = lt.t.DumpDOT(w)
+	lt.m.RUnlock()
+	return
+}
+
 func (lt *SafeTree) Dump(asJSON bool) string {
 	lt.m.RLock()
-	defer lt.m.Unlock()
+	defer lt.m.RUnlock()
 	return lt.t.Dump(asJSON)
 }
+
+func (lt *SafeTree) String() string {
+	lt.m.RLock()
+	defer lt.m.RUnlock()
+	return lt.t.String()
+}
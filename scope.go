@@ -0,0 +1,51 @@
+//go:build go1.18
+// +build go1.18
+
+package radix
+
+// Scope returns a live view of the tree where all the keys are prefixed with prefix.
+// Changes to the view are reflected in the tree and vice versa.
+func (t *Tree[VT]) Scope(prefix string) *ScopedTree[VT] {
+	return &ScopedTree[VT]{t: t, prefix: prefix}
+}
+
+// ScopedTree is a view of a tree under a prefix, see Tree.Scope.
+// Keys passed to and returned from a scoped tree don't include the prefix.
+type ScopedTree[VT any] struct {
+	t      *Tree[VT]
+	prefix string
+}
+
+// Prefix returns the scope's prefix.
+func (st *ScopedTree[VT]) Prefix() string {
+	return st.prefix
+}
+
+// Scope returns a nested scope.
+func (st *ScopedTree[VT]) Scope(prefix string) *ScopedTree[VT] {
+	return st.t.Scope(st.prefix + prefix)
+}
+
+func (st *ScopedTree[VT]) Set(key string, value VT) (VT, bool) {
+	return st.t.Set(st.prefix+key, value)
+}
+
+func (st *ScopedTree[VT]) Get(key string) (VT, bool) {
+	return st.t.Get(st.prefix + key)
+}
+
+func (st *ScopedTree[VT]) Delete(key string) (VT, bool) {
+	return st.t.Delete(st.prefix + key)
+}
+
+// Walk walks all the keys in the scope.
+func (st *ScopedTree[VT]) Walk(fn WalkFn[VT]) bool {
+	return st.WalkPrefix("", fn)
+}
+
+// WalkPrefix walks all the keys in the scope under prefix.
+func (st *ScopedTree[VT]) WalkPrefix(prefix string, fn WalkFn[VT]) bool {
+	return st.t.WalkPrefix(st.prefix+prefix, func(k string, v VT) bool {
+		return fn(k[len(st.prefix):], v)
+	})
+}
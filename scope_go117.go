@@ -0,0 +1,51 @@
+//go:build !go1.18
+// +build !go1.18
+
+package radix
+
+// Scope returns a live view of the tree where all the keys are prefixed with prefix.
+// Changes to the view are reflected in the tree and vice versa.
+func (t *Tree) Scope(prefix string) *ScopedTree {
+	return &ScopedTree{t: t, prefix: prefix}
+}
+
+// ScopedTree is a view of a tree under a prefix, see Tree.Scope.
+// Keys passed to and returned from a scoped tree don't include the prefix.
+type ScopedTree struct {
+	t      *Tree
+	prefix string
+}
+
+// Prefix returns the scope's prefix.
+func (st *ScopedTree) Prefix() string {
+	return st.prefix
+}
+
+// Scope returns a nested scope.
+func (st *ScopedTree) Scope(prefix string) *ScopedTree {
+	return st.t.Scope(st.prefix + prefix)
+}
+
+func (st *ScopedTree) Set(key string, value interface{}) (interface{}, bool) {
+	return st.t.Set(st.prefix+key, value)
+}
+
+func (st *ScopedTree) Get(key string) (interface{}, bool) {
+	return st.t.Get(st.prefix + key)
+}
+
+func (st *ScopedTree) Delete(key string) (interface{}, bool) {
+	return st.t.Delete(st.prefix + key)
+}
+
+// Walk walks all the keys in the scope.
+func (st *ScopedTree) Walk(fn WalkFn) bool {
+	return st.WalkPrefix("", fn)
+}
+
+// WalkPrefix walks all the keys in the scope under prefix.
+func (st *ScopedTree) WalkPrefix(prefix string, fn WalkFn) bool {
+	return st.t.WalkPrefix(st.prefix+prefix, func(k string, v interface{}) bool {
+		return fn(k[len(st.prefix):], v)
+	})
+}
@@ -0,0 +1,530 @@
+//go:build go1.18
+// +build go1.18
+
+package radix
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/bits"
+	"unicode/utf8"
+)
+
+const succinctMagic = "RDXS\x02"
+
+// ErrInvalidSuccinct is returned from OpenSuccinct if the data isn't a valid encoded tree.
+var ErrInvalidSuccinct = errors.New("radix: invalid succinct data")
+
+// EncodeSuccinct writes a LOUDS-encoded read-only version of the tree to w,
+// values are encoded using encoding/json.
+//
+// Layout (little endian):
+//
+//	magic | fold byte | nodes uint32 | leaves uint32
+//	louds bits | leaf bits | key bits | prefixes | keys | values
+//
+// nodes are stored in breadth-first order, edge labels are the first rune of the node prefixes and keys
+// are rebuilt from the prefixes on their path, only the keys that differ from it (case-insensitive trees
+// keep the casing of each key) are marked in the key bits and stored in the keys table.
+// String tables are stored as (count+1) uint32 offsets followed by the string data.
+func (t *Tree[VT]) EncodeSuccinct(w io.Writer) error {
+	var (
+		nodes   = []*node[VT]{&t.root}
+		parents = []int{-1}
+	)
+
+	for i := 0; i < len(nodes); i++ {
+		for _, e := range nodes[i].Edges {
+			nodes = append(nodes, e.Node)
+			parents = append(parents, i)
+		}
+	}
+
+	var (
+		louds    = make([]uint64, bitWords(2*len(nodes)+1))
+		leafBits = make([]uint64, bitWords(len(nodes)))
+		keyBits  []uint64
+		prefixes = make([]string, 0, len(nodes))
+		keys     []string
+		values   []string
+		pos      = 2
+	)
+
+	louds[0] = 1 // super root
+	for i, n := range nodes {
+		for range n.Edges {
+			louds[pos/64] |= 1 << (pos % 64)
+			pos++
+		}
+		pos++
+
+		prefixes = append(prefixes, n.Prefix)
+		if !n.isLeafInTheWind() {
+			continue
+		}
+
+		v, err := json.Marshal(n.Leaf.Value)
+		if err != nil {
+			return err
+		}
+		leafBits[i/64] |= 1 << (i % 64)
+		if len(values)%64 == 0 {
+			keyBits = append(keyBits, 0)
+		}
+		if !isPathKey(nodes, parents, i) {
+			keyBits[len(values)/64] |= 1 << (len(values) % 64)
+			keys = append(keys, n.Leaf.Key)
+		}
+		values = append(values, string(v))
+	}
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString(succinctMagic)
+	if t.fold {
+		bw.WriteByte(1)
+	} else {
+		bw.WriteByte(0)
+	}
+	writeUint32(bw, uint32(len(nodes)))
+	writeUint32(bw, uint32(len(values)))
+
+	for _, words := range [][]uint64{louds, leafBits, keyBits} {
+		for _, word := range words {
+			var b [8]byte
+			binary.LittleEndian.PutUint64(b[:], word)
+			bw.Write(b[:])
+		}
+	}
+
+	for _, tbl := range [][]string{prefixes, keys, values} {
+		var off uint32
+		writeUint32(bw, 0)
+		for _, s := range tbl {
+			off += uint32(len(s))
+			writeUint32(bw, off)
+		}
+		for _, s := range tbl {
+			bw.WriteString(s)
+		}
+	}
+
+	return bw.Flush()
+}
+
+// isPathKey returns true if the key of the leaf of nodes[i] is the concatenation of the prefixes on its path.
+func isPathKey[VT any](nodes []*node[VT], parents []int, i int) bool {
+	key := nodes[i].Leaf.Key
+	for ; i != -1; i = parents[i] {
+		p := nodes[i].Prefix
+		if len(key) < len(p) || key[len(key)-len(p):] != p {
+			return false
+		}
+		key = key[:len(key)-len(p)]
+	}
+	return key == ""
+}
+
+// OpenSuccinct returns a read-only tree operating directly on data created by EncodeSuccinct.
+// data must not be modified while the tree is in use.
+// The structure of the tree is checked and the values are decoded once, so corrupted data is rejected
+// with ErrInvalidSuccinct rather than failing later.
+func OpenSuccinct[VT any](data []byte) (*ReadOnlyTree[VT], error) {
+	var rt ReadOnlyTree[VT]
+	if len(data) < len(succinctMagic)+9 || string(data[:len(succinctMagic)]) != succinctMagic {
+		return nil, ErrInvalidSuccinct
+	}
+	data = data[len(succinctMagic):]
+	rt.fold = data[0] == 1
+	rt.n = int(binary.LittleEndian.Uint32(data[1:]))
+	leaves := int(binary.LittleEndian.Uint32(data[5:]))
+	data = data[9:]
+
+	if rt.n == 0 || leaves > rt.n {
+		return nil, ErrInvalidSuccinct
+	}
+
+	var ok bool
+	if rt.louds, data, ok = newBitsView(data, 2*rt.n+1); !ok {
+		return nil, ErrInvalidSuccinct
+	}
+	if rt.leafBits, data, ok = newBitsView(data, rt.n); !ok {
+		return nil, ErrInvalidSuccinct
+	}
+	if rt.keyBits, data, ok = newBitsView(data, leaves); !ok {
+		return nil, ErrInvalidSuccinct
+	}
+	if rt.louds.rank1(2*rt.n+1) != rt.n || rt.leafBits.rank1(rt.n) != leaves {
+		return nil, ErrInvalidSuccinct
+	}
+
+	if rt.prefixes, data, ok = newStrTable(data, rt.n); !ok {
+		return nil, ErrInvalidSuccinct
+	}
+	if rt.keys, data, ok = newStrTable(data, rt.keyBits.rank1(leaves)); !ok {
+		return nil, ErrInvalidSuccinct
+	}
+	values, data, ok := newStrTable(data, leaves)
+	if !ok || len(data) != 0 {
+		return nil, ErrInvalidSuccinct
+	}
+
+	if !rt.validate() {
+		return nil, ErrInvalidSuccinct
+	}
+
+	rt.values = make([]VT, leaves)
+	for i := range rt.values {
+		if json.Unmarshal(values.bytes(i), &rt.values[i]) != nil {
+			return nil, ErrInvalidSuccinct
+		}
+	}
+	return &rt, nil
+}
+
+// validate checks that every node's children are after it and in range, that only the root has an empty
+// prefix and that the children of a node are sorted by label, so lookups and walks always terminate.
+func (rt *ReadOnlyTree[VT]) validate() bool {
+	if len(rt.prefixes.bytes(0)) != 0 {
+		return false
+	}
+
+	next := 1
+	for k := 0; k < rt.n; k++ {
+		first, num := rt.children(k)
+		if first != next || first <= k || first+num > rt.n {
+			return false
+		}
+		next += num
+
+		for c := first; c < first+num; c++ {
+			if len(rt.prefixes.bytes(c)) == 0 || c > first && rt.label(c-1) >= rt.label(c) {
+				return false
+			}
+		}
+	}
+	return next == rt.n
+}
+
+// ReadOnlyTree is a read-only tree backed by a succinct encoding, see OpenSuccinct.
+type ReadOnlyTree[VT any] struct {
+	louds    bitsView
+	leafBits bitsView
+	keyBits  bitsView
+	prefixes strTable
+	keys     strTable
+	values   []VT
+
+	n    int
+	fold bool
+}
+
+// Len returns the number of keys in the tree.
+func (rt *ReadOnlyTree[VT]) Len() int {
+	return len(rt.values)
+}
+
+// Get is used to lookup a specific key, returning
+// the value and if it was found.
+func (rt *ReadOnlyTree[VT]) Get(s string) (v VT, found bool) {
+	k, search := 0, s
+	for {
+		// Check for key exhaution
+		if len(search) == 0 {
+			if i, ok := rt.leaf(k); ok {
+				return rt.values[i], true
+			}
+			break
+		}
+
+		// Look for an edge
+		if k = rt.child(k, nextRune(search)); k == -1 {
+			break
+		}
+
+		// Consume the search prefix
+		if p := rt.prefixes.bytes(k); hasBytesPrefix(search, p, rt.fold) {
+			search = search[len(p):]
+		} else {
+			break
+		}
+	}
+	return
+}
+
+// LongestPrefix is like Get, but instead of an
+// exact match, it will return the longest prefix match.
+func (rt *ReadOnlyTree[VT]) LongestPrefix(s string) (key string, v VT, found bool) {
+	// case-insensitive trees return the stored casing of the prefixes, otherwise it's the same as s
+	var path []byte
+	if rt.fold {
+		path = make([]byte, 0, len(s))
+	}
+
+	k, search, last, lastLen := 0, s, -1, 0
+	for {
+		// Look for a leaf node
+		if i, ok := rt.leaf(k); ok {
+			last, lastLen = i, len(s)-len(search)
+		}
+
+		// Check for key exhaution
+		if len(search) == 0 {
+			break
+		}
+
+		// Look for an edge
+		if k = rt.child(k, nextRune(search)); k == -1 {
+			break
+		}
+
+		// Consume the search prefix
+		if p := rt.prefixes.bytes(k); hasBytesPrefix(search, p, rt.fold) {
+			search = search[len(p):]
+			if rt.fold {
+				path = append(path, p...)
+			}
+		} else {
+			break
+		}
+	}
+
+	switch {
+	case last == -1:
+		return
+	case rt.keyBits.get(last):
+		key = rt.keys.get(rt.keyBits.rank1(last))
+	case rt.fold:
+		key = string(path[:lastLen])
+	default:
+		key = s[:lastLen]
+	}
+	return key, rt.values[last], true
+}
+
+// Walk is used to walk the tree.
+func (rt *ReadOnlyTree[VT]) Walk(fn WalkFn[VT]) bool {
+	return rt.walk(0, nil, fn)
+}
+
+// WalkPrefix is used to walk the tree under a prefix.
+func (rt *ReadOnlyTree[VT]) WalkPrefix(prefix string, fn WalkFn[VT]) bool {
+	var path []byte
+	k, search, above := 0, prefix, 0
+	for {
+		// Check for key exhaution
+		if len(search) == 0 {
+			return rt.walk(k, path[:above], fn)
+		}
+
+		// Look for an edge
+		if k = rt.child(k, nextRune(search)); k == -1 {
+			break
+		}
+
+		// Consume the search prefix
+		if p := rt.prefixes.bytes(k); hasBytesPrefix(search, p, rt.fold) {
+			search = search[len(p):]
+			above, path = len(path), append(path, p...)
+		} else if hasPrefixBytes(p, search, rt.fold) {
+			// Child may be under our search prefix
+			return rt.walk(k, path, fn)
+		} else {
+			break
+		}
+	}
+
+	return false
+}
+
+// walk calls fn for every leaf under node k in order, path holds the prefixes on the path above k.
+func (rt *ReadOnlyTree[VT]) walk(k int, path []byte, fn WalkFn[VT]) bool {
+	type frame struct {
+		k   int // node id
+		off int // length of the path above k
+	}
+
+	stack := []frame{{k, len(path)}}
+	for len(stack) > 0 {
+		f := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		path = append(path[:f.off], rt.prefixes.bytes(f.k)...)
+		if i, ok := rt.leaf(f.k); ok && fn(rt.key(i, path), rt.values[i]) {
+			return true
+		}
+
+		// push the children in reverse, so they're popped in order
+		first, num := rt.children(f.k)
+		for c := first + num - 1; c >= first; c-- {
+			stack = append(stack, frame{c, len(path)})
+		}
+	}
+	return false
+}
+
+// key returns the key of leaf i, path holds the prefixes on the path to it.
+func (rt *ReadOnlyTree[VT]) key(i int, path []byte) string {
+	if rt.keyBits.get(i) {
+		return rt.keys.get(rt.keyBits.rank1(i))
+	}
+	return string(path)
+}
+
+// children returns the id of the first child of node k and the number of children.
+func (rt *ReadOnlyTree[VT]) children(k int) (first, num int) {
+	start, end := rt.louds.select0(k)+1, rt.louds.select0(k+1)
+	return rt.louds.rank1(start), end - start
+}
+
+// child returns the id of the child of node k with the given edge label or -1.
+func (rt *ReadOnlyTree[VT]) child(k int, label rune) int {
+	if rt.fold {
+		label = foldRune(label)
+	}
+
+	first, num := rt.children(k)
+	i, j := first, first+num
+	for i < j {
+		h := int(uint(i+j) >> 1)
+		l := rt.label(h)
+		if l == label {
+			return h
+		} else if l < label {
+			i = h + 1
+		} else {
+			j = h
+		}
+	}
+	return -1
+}
+
+// label returns the label of the edge leading to node k, the (folded) first rune of its prefix.
+func (rt *ReadOnlyTree[VT]) label(k int) rune {
+	p := rt.prefixes.bytes(k)
+	if len(p) == 0 {
+		return 0
+	}
+
+	r := rune(p[0])
+	if r >= utf8.RuneSelf {
+		r, _ = utf8.DecodeRune(p)
+	}
+	if rt.fold {
+		r = foldRune(r)
+	}
+	return r
+}
+
+// leaf returns the leaf index of node k, if it has one.
+func (rt *ReadOnlyTree[VT]) leaf(k int) (int, bool) {
+	if !rt.leafBits.get(k) {
+		return 0, false
+	}
+	return rt.leafBits.rank1(k), true
+}
+
+// bitsView is a bitset view over encoded little endian words, with a rank directory.
+type bitsView struct {
+	data []byte
+	rank []uint32 // rank[i] is the number of set bits before word i
+}
+
+func newBitsView(data []byte, nbits int) (bv bitsView, rest []byte, ok bool) {
+	words := bitWords(nbits)
+	if len(data) < 8*words {
+		return
+	}
+	bv.data, rest = data[:8*words], data[8*words:]
+	bv.rank = make([]uint32, words+1)
+	for i := 0; i < words; i++ {
+		bv.rank[i+1] = bv.rank[i] + uint32(bits.OnesCount64(bv.word(i)))
+	}
+	return bv, rest, true
+}
+
+func (bv *bitsView) word(i int) uint64 {
+	return binary.LittleEndian.Uint64(bv.data[8*i:])
+}
+
+func (bv *bitsView) get(p int) bool {
+	return bv.word(p/64)&(1<<(p%64)) != 0
+}
+
+// rank1 returns the number of set bits in [0, p).
+func (bv *bitsView) rank1(p int) int {
+	w, b := p/64, p%64
+	if b == 0 {
+		return int(bv.rank[w])
+	}
+	return int(bv.rank[w]) + bits.OnesCount64(bv.word(w)&(1<<b-1))
+}
+
+// select0 returns the position of the j-th (0-based) unset bit.
+func (bv *bitsView) select0(j int) int {
+	zeros := func(w int) int { return 64*w - int(bv.rank[w]) }
+
+	// find the last word with less than j+1 zeros before it
+	i, k := 0, len(bv.rank)-1
+	for i < k {
+		h := int(uint(i+k+1) >> 1)
+		if zeros(h) <= j {
+			i = h
+		} else {
+			k = h - 1
+		}
+	}
+
+	w := ^bv.word(i)
+	for n := j - zeros(i); n > 0; n-- {
+		w &= w - 1
+	}
+	return 64*i + bits.TrailingZeros64(w)
+}
+
+// strTable is a view over encoded string offsets and data.
+type strTable struct {
+	offs []byte
+	data []byte
+}
+
+func newStrTable(data []byte, n int) (st strTable, rest []byte, ok bool) {
+	if len(data) < 4*(n+1) {
+		return
+	}
+	st.offs, data = data[:4*(n+1)], data[4*(n+1):]
+
+	var last uint32
+	for i := 0; i <= n; i++ {
+		off := binary.LittleEndian.Uint32(st.offs[4*i:])
+		if off < last {
+			return
+		}
+		last = off
+	}
+	if binary.LittleEndian.Uint32(st.offs) != 0 || int(last) > len(data) {
+		return
+	}
+	st.data = data[:last]
+	return st, data[last:], true
+}
+
+func (st *strTable) bytes(i int) []byte {
+	return st.data[binary.LittleEndian.Uint32(st.offs[4*i:]):binary.LittleEndian.Uint32(st.offs[4*i+4:])]
+}
+
+func (st *strTable) get(i int) string {
+	return string(st.bytes(i))
+}
+
+func bitWords(nbits int) int {
+	return (nbits + 63) / 64
+}
+
+func writeUint32(w io.Writer, v uint32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	w.Write(b[:])
+}
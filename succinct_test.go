@@ -0,0 +1,219 @@
+//go:build go1.18
+// +build go1.18
+
+package radix
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestSuccinct(t *testing.T) {
+	keys := []string{
+		"",
+		"foo",
+		"foobar",
+		"foobarbaz",
+		"foobarbazzip",
+		"foozip",
+		"foo/bar/baz",
+		"foo/baz/bar",
+		"zipzap",
+		"/u/äpfêl/",
+		"/u/Äpfel",
+		"FOOzap",
+	}
+	for i := 0; i < 200; i++ {
+		keys = append(keys, generateUUID())
+	}
+
+	queries := []string{
+		"", "a", "f", "fo", "foo", "FOO", "foob", "foobarba", "foobarbazzipzap", "foo/",
+		"foo/ba", "foozipzap", "z", "/u/", "/u/ÄPFÊL/", "/u/äpfel", "/u/äpfel/x",
+	}
+	queries = append(queries, keys...)
+
+	for _, fold := range []bool{false, true} {
+		r := New[interface{}](fold)
+		for _, k := range keys {
+			r.Set(k, "v:"+k)
+		}
+
+		var buf bytes.Buffer
+		if err := r.EncodeSuccinct(&buf); err != nil {
+			t.Fatal(err)
+		}
+
+		rt, err := OpenSuccinct[interface{}](buf.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rt.Len() != r.Len() {
+			t.Fatalf("bad len: %v %v", rt.Len(), r.Len())
+		}
+
+		for _, q := range queries {
+			ev, eok := r.Get(q)
+			v, ok := rt.Get(q)
+			if v != ev || ok != eok {
+				t.Fatalf("fold=%v: Get(%q): expected (%v, %v), got (%v, %v)", fold, q, ev, eok, v, ok)
+			}
+
+			ek, ev, eok := r.LongestPrefix(q)
+			k, v, ok := rt.LongestPrefix(q)
+			if k != ek || v != ev || ok != eok {
+				t.Fatalf("fold=%v: LongestPrefix(%q): expected (%q, %v, %v), got (%q, %v, %v)", fold, q, ek, ev, eok, k, v, ok)
+			}
+
+			var exp, got []string
+			r.WalkPrefix(q, func(k string, _ interface{}) bool {
+				exp = append(exp, k)
+				return false
+			})
+			rt.WalkPrefix(q, func(k string, _ interface{}) bool {
+				got = append(got, k)
+				return false
+			})
+			if !reflect.DeepEqual(exp, got) {
+				t.Fatalf("fold=%v: WalkPrefix(%q): expected %v, got %v", fold, q, exp, got)
+			}
+		}
+	}
+}
+
+func TestSuccinctSize(t *testing.T) {
+	for _, fold := range []bool{false, true} {
+		r := New[interface{}](fold)
+		for i := 0; i < 10000; i++ {
+			r.Set(fmt.Sprintf("/api/v%d/users/%d/posts", i%3, i), i)
+			r.Set(generateUUID(), i)
+		}
+
+		var buf bytes.Buffer
+		if err := r.EncodeSuccinct(&buf); err != nil {
+			t.Fatal(err)
+		}
+		j, err := json.Marshal(r.ToMap())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if buf.Len() >= len(j) {
+			t.Fatalf("fold=%v: expected the encoded tree to be smaller than its json (%d), got %d", fold, len(j), buf.Len())
+		}
+
+		rt, err := OpenSuccinct[interface{}](buf.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := testing.AllocsPerRun(100, func() { rt.Get("/api/v1/users/1/posts") }); n != 0 {
+			t.Fatalf("fold=%v: expected Get not to allocate, got %v allocs", fold, n)
+		}
+	}
+}
+
+func TestSuccinctEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	if err := New[interface{}](false).EncodeSuccinct(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	rt, err := OpenSuccinct[interface{}](buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := rt.Get(""); ok || rt.Len() != 0 {
+		t.Fatal("expected an empty tree")
+	}
+
+	if _, err = OpenSuccinct[interface{}](buf.Bytes()[:buf.Len()-1]); err != ErrInvalidSuccinct {
+		t.Fatalf("expected ErrInvalidSuccinct, got %v", err)
+	}
+}
+
+func TestSuccinctCorrupted(t *testing.T) {
+	r := New[interface{}](false)
+	for _, k := range []string{"", "foo", "foobar", "foozip", "foo/bar", "zip", "zap"} {
+		r.Set(k, len(k))
+	}
+
+	var buf bytes.Buffer
+	if err := r.EncodeSuccinct(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	// open must either fail or return a tree that can be fully walked and queried
+	check := func(name string, data []byte) {
+		rt, err := OpenSuccinct[interface{}](data)
+		if err != nil {
+			if err != ErrInvalidSuccinct {
+				t.Fatalf("%s: expected ErrInvalidSuccinct, got %v", name, err)
+			}
+			return
+		}
+
+		n := 0
+		rt.Walk(func(k string, _ interface{}) bool {
+			if n++; n > rt.Len() {
+				t.Fatalf("%s: walked more than %d keys", name, rt.Len())
+			}
+			rt.Get(k)
+			rt.LongestPrefix(k + "x")
+			return false
+		})
+		rt.WalkPrefix("foo", func(string, interface{}) bool { return false })
+	}
+
+	for i := 0; i < len(data); i++ {
+		if _, err := OpenSuccinct[interface{}](data[:i]); err != ErrInvalidSuccinct {
+			t.Fatalf("truncated at %d: expected ErrInvalidSuccinct, got %v", i, err)
+		}
+	}
+
+	flipped := 0
+	for i := 0; i < 8*len(data); i++ {
+		cp := append([]byte(nil), data...)
+		cp[i/8] ^= 1 << (i % 8)
+		if _, err := OpenSuccinct[interface{}](cp); err != nil {
+			flipped++
+		}
+		check(fmt.Sprintf("bit %d", i), cp)
+	}
+	if flipped == 0 {
+		t.Fatal("expected some bit flips to be rejected")
+	}
+
+	// swapping louds bits keeps the rank counts valid, but moves child ranges around,
+	// including ranges pointing back at their parent, which used to recurse forever
+	nodes := int(binary.LittleEndian.Uint32(data[len(succinctMagic)+1:]))
+	off, rejected := 8*(len(succinctMagic)+9), 0
+	for i := 0; i < 2*nodes+1; i++ {
+		for j := i + 1; j < 2*nodes+1; j++ {
+			cp := append([]byte(nil), data...)
+			bi, bj := cp[(off+i)/8]>>((off+i)%8)&1, cp[(off+j)/8]>>((off+j)%8)&1
+			if bi == bj {
+				continue
+			}
+			cp[(off+i)/8] ^= 1 << ((off + i) % 8)
+			cp[(off+j)/8] ^= 1 << ((off + j) % 8)
+			if _, err := OpenSuccinct[interface{}](cp); err != nil {
+				rejected++
+			}
+			check(fmt.Sprintf("swapped %d and %d", i, j), cp)
+		}
+	}
+	if rejected == 0 {
+		t.Fatal("expected some swapped louds bits to be rejected")
+	}
+
+	// a value that doesn't decode
+	cp := append([]byte(nil), data...)
+	cp[len(cp)-1] = '}'
+	if _, err := OpenSuccinct[interface{}](cp); err != ErrInvalidSuccinct {
+		t.Fatalf("expected ErrInvalidSuccinct, got %v", err)
+	}
+}
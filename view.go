@@ -0,0 +1,58 @@
+//go:build go1.18
+// +build go1.18
+
+package radix
+
+// ReadOnly returns a read-only view of the tree, changes to the tree are visible through the view.
+func (t *Tree[VT]) ReadOnly() ReadOnlyView[VT] {
+	return ReadOnlyView[VT]{r: t}
+}
+
+// ReadOnly returns a read-only view of the tree, every call on the view takes the read lock.
+func (lt *SafeTree[VT]) ReadOnly() ReadOnlyView[VT] {
+	return ReadOnlyView[VT]{r: lt}
+}
+
+// ReadOnlyView is a read-only view of a Tree or a SafeTree, it exposes no methods that can modify the tree.
+type ReadOnlyView[VT any] struct {
+	r reader[VT]
+}
+
+// reader is the set of read methods shared between Tree and SafeTree.
+type reader[VT any] interface {
+	Len() int
+	Get(key string) (VT, bool)
+	LongestPrefix(prefix string) (string, VT, bool)
+	Minimum() (string, VT, bool)
+	Maximum() (string, VT, bool)
+	Walk(fn WalkFn[VT]) bool
+	WalkPrefix(prefix string, fn WalkFn[VT]) bool
+}
+
+func (v ReadOnlyView[VT]) Len() int {
+	return v.r.Len()
+}
+
+func (v ReadOnlyView[VT]) Get(key string) (VT, bool) {
+	return v.r.Get(key)
+}
+
+func (v ReadOnlyView[VT]) LongestPrefix(prefix string) (string, VT, bool) {
+	return v.r.LongestPrefix(prefix)
+}
+
+func (v ReadOnlyView[VT]) Minimum() (string, VT, bool) {
+	return v.r.Minimum()
+}
+
+func (v ReadOnlyView[VT]) Maximum() (string, VT, bool) {
+	return v.r.Maximum()
+}
+
+func (v ReadOnlyView[VT]) Walk(fn WalkFn[VT]) bool {
+	return v.r.Walk(fn)
+}
+
+func (v ReadOnlyView[VT]) WalkPrefix(prefix string, fn WalkFn[VT]) bool {
+	return v.r.WalkPrefix(prefix, fn)
+}
@@ -0,0 +1,58 @@
+//go:build !go1.18
+// +build !go1.18
+
+package radix
+
+// ReadOnly returns a read-only view of the tree, changes to the tree are visible through the view.
+func (t *Tree) ReadOnly() ReadOnlyView {
+	return ReadOnlyView{r: t}
+}
+
+// ReadOnly returns a read-only view of the tree, every call on the view takes the read lock.
+func (lt *SafeTree) ReadOnly() ReadOnlyView {
+	return ReadOnlyView{r: lt}
+}
+
+// ReadOnlyView is a read-only view of a Tree or a SafeTree, it exposes no methods that can modify the tree.
+type ReadOnlyView struct {
+	r reader
+}
+
+// reader is the set of read methods shared between Tree and SafeTree.
+type reader interface {
+	Len() int
+	Get(key string) (interface{}, bool)
+	LongestPrefix(prefix string) (string, interface{}, bool)
+	Minimum() (string, interface{}, bool)
+	Maximum() (string, interface{}, bool)
+	Walk(fn WalkFn) bool
+	WalkPrefix(prefix string, fn WalkFn) bool
+}
+
+func (v ReadOnlyView) Len() int {
+	return v.r.Len()
+}
+
+func (v ReadOnlyView) Get(key string) (interface{}, bool) {
+	return v.r.Get(key)
+}
+
+func (v ReadOnlyView) LongestPrefix(prefix string) (string, interface{}, bool) {
+	return v.r.LongestPrefix(prefix)
+}
+
+func (v ReadOnlyView) Minimum() (string, interface{}, bool) {
+	return v.r.Minimum()
+}
+
+func (v ReadOnlyView) Maximum() (string, interface{}, bool) {
+	return v.r.Maximum()
+}
+
+func (v ReadOnlyView) Walk(fn WalkFn) bool {
+	return v.r.Walk(fn)
+}
+
+func (v ReadOnlyView) WalkPrefix(prefix string, fn WalkFn) bool {
+	return v.r.WalkPrefix(prefix, fn)
+}